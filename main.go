@@ -9,6 +9,15 @@ import (
 	"go_code/pkg/bill"
 	"go_code/pkg/webhook"
 	"go_code/pkg/third_party"
+	"go_code/pkg/statement"
+	"go_code/pkg/schedule"
+	"go_code/pkg/fee"
+	"go_code/pkg/reversal"
+	"go_code/pkg/reconciliation"
+	"go_code/pkg/msisdn"
+	"go_code/pkg/float"
+	"go_code/pkg/funding"
+	"time"
 	"github.com/joho/godotenv"
 	"github.com/gin-gonic/gin"
 	_ "github.com/jackc/pgx/v4/stdlib" // Import the PostgreSQL driver
@@ -45,14 +54,85 @@ func main() {
 	// View all banks
 	application.GET("/wallet/banks", wallet.ViewAllBanksHandler)
 
+	// Dedicated accounts on additional banks
+	application.GET("/wallet/dva/providers", wallet.DVAProvidersHandler)
+	application.GET("/wallet/:user_id/dva", wallet.DedicatedAccountsHandler)
+	application.POST("/wallet/:user_id/dva", wallet.RequestDVAHandler)
+
+	// Whether the wallet's dedicated accounts have been assigned
+	application.GET("/wallet/:user_id/status", wallet.WalletStatusHandler)
+
+	// Dedicated account lifecycle, for the account owner and for admins
+	application.POST("/wallet/:user_id/dva/:dva_id/deactivate", wallet.DeactivateDVAHandler)
+	application.POST("/wallet/:user_id/dva/:dva_id/reactivate", wallet.ReactivateDVAHandler)
+	application.POST("/wallet/:user_id/dva/:dva_id/requery", wallet.RequeryDVAHandler)
+	application.POST("/admin/dva/:dva_id/deactivate", auth.RequireStaff(auth.RoleAdmin), wallet.DeactivateDVAHandler)
+	application.POST("/admin/dva/:dva_id/reactivate", auth.RequireStaff(auth.RoleAdmin), wallet.ReactivateDVAHandler)
+	application.POST("/admin/dva/:dva_id/requery", auth.RequireStaff(auth.RoleSupport), wallet.RequeryDVAHandler)
+
+	// Split payments into a merchant's dedicated account with a Paystack subaccount
+	application.POST("/admin/dva/:dva_id/split", auth.RequireStaff(auth.RoleAdmin), wallet.SplitDVAHandler)
+	application.DELETE("/admin/dva/:dva_id/split", auth.RequireStaff(auth.RoleAdmin), wallet.RemoveDVASplitHandler)
+
+	// Card top-ups through Paystack checkout or a saved card
+	application.POST("/funding/card", funding.FundWalletHandler)
+	application.GET("/funding/card/verify", funding.VerifyCardFundingHandler)
+	application.GET("/funding/cards/:user_id", funding.SavedCardsHandler)
+	application.POST("/funding/cards/:user_id/sync", funding.SyncCardsHandler)
+	application.DELETE("/funding/cards/:user_id/:card_id", funding.DeleteCardHandler)
+
+	// Automatic card top-ups when the wallet balance runs low
+	application.POST("/funding/auto_topup", funding.SaveAutoTopUpHandler)
+	application.GET("/funding/auto_topup/:user_id", funding.GetAutoTopUpHandler)
+	application.DELETE("/funding/auto_topup/:user_id", funding.DisableAutoTopUpHandler)
+
 	// Check Dojah balance
 	application.GET("/dojah_balance", third_party_balance.BalanceHandler)
 
+	// Provider float monitoring
+	application.GET("/admin/float", auth.RequireStaff(auth.RoleAdmin), float.FloatStatusHandler)
+	application.GET("/admin/float/:provider/history", auth.RequireStaff(auth.RoleAdmin), float.FloatHistoryHandler)
+
 	// Transactions API
 	application.POST("/transaction/transfer", transaction.FundTransferHandler)
+
+	// Quote a transfer, then confirm it once the account name has been checked
+	application.POST("/transaction/quote", transaction.TransferQuoteHandler)
+	application.POST("/transaction/confirm", transaction.ConfirmTransferHandler)
+
+	// Wallet-to-wallet transfers
+	application.GET("/transaction/p2p/recipient", transaction.LookupWalletRecipientHandler)
+	application.POST("/transaction/p2p", transaction.P2PTransferHandler)
+
+	// Bulk transfers
+	application.POST("/transaction/bulk", transaction.BulkTransferHandler)
+	application.GET("/transaction/bulk/:batch_id", transaction.GetBulkTransferHandler)
+
+	// Saved beneficiaries
+	application.POST("/beneficiary", transaction.CreateBeneficiaryHandler)
+	application.GET("/beneficiary/user/:user_id", transaction.ListBeneficiariesHandler)
+	application.PUT("/beneficiary/:beneficiary_id", transaction.UpdateBeneficiaryHandler)
+	application.DELETE("/beneficiary/:beneficiary_id", transaction.DeleteBeneficiaryHandler)
 	
+	// Fee quote for a transfer or bill purchase
+	application.GET("/fee/quote", fee.QuoteHandler)
+
+	// Reversals, restricted to support staff
+	application.POST("/reversal", auth.RequireStaff(auth.RoleSupport), reversal.ReverseTransactionHandler)
+	application.GET("/reversal/transaction/:transaction_id", auth.RequireStaff(auth.RoleSupport), reversal.GetReversalHandler)
+
 	// KYC
 	application.POST("/biometric_kyc", kyc.PhotoIDVerificationHandler)
+	application.POST("/kyc/bvn", kyc.BVNVerificationHandler)
+	application.POST("/kyc/nin", kyc.NINVerificationHandler)
+	application.GET("/kyc/identity/:user_id", kyc.IdentityHandler)
+	application.GET("/kyc/tier/:user_id", kyc.TierHandler)
+	application.POST("/kyc/address", auth.RequireStaff(auth.RoleSupport), kyc.VerifyAddressHandler)
+	application.GET("/kyc/verifications/:user_id", auth.RequireStaff(auth.RoleSupport), kyc.VerificationHistoryHandler)
+	application.GET("/kyc/reviews", auth.RequireStaff(auth.RoleSupport), kyc.ReviewQueueHandler)
+	application.POST("/kyc/reviews/:verification_id", auth.RequireStaff(auth.RoleSupport), kyc.ReviewDecisionHandler)
+	application.GET("/kyc/reviews/:verification_id/documents", auth.RequireStaff(auth.RoleSupport), kyc.VerificationDocumentsHandler)
+	application.GET("/kyc/documents/:document_id", auth.RequireStaff(auth.RoleSupport), kyc.DocumentHandler)
 
 	// Bill
 	// Airtime purchase
@@ -63,10 +143,74 @@ func main() {
 	
 	// Fetch all data plans
 	application.GET("/bill/data_plan", bill.DataPlansHandler)
+	application.GET("/bill/vas_purchase/:reference", bill.VASPurchaseStatusHandler)
+	application.POST("/bill/bulk", bill.BulkVASHandler)
+	application.GET("/bill/bulk/:batch_id", bill.GetBulkVASHandler)
+
+	// Phone number network detection
+	application.GET("/msisdn/lookup", msisdn.LookupHandler)
+	application.POST("/msisdn/ported", auth.RequireStaff(auth.RoleSupport), msisdn.SavePortedNumberHandler)
+	application.DELETE("/msisdn/ported/:number", auth.RequireStaff(auth.RoleSupport), msisdn.DeletePortedNumberHandler)
+
+	// Electricity, cable TV and betting wallet payments
+	application.GET("/bill/:category/services", bill.BillServicesHandler)
+	application.GET("/bill/:category/variations", bill.BillVariationsHandler)
+	application.POST("/bill/:category/validate", bill.ValidateBillCustomerHandler)
+	application.POST("/bill/:category/pay", bill.BillPaymentHandler)
+
+	// Daily reconciliation against Paystack and the VAS providers, restricted to admins
+	application.POST("/admin/reconciliation/runs", auth.RequireStaff(auth.RoleAdmin), reconciliation.RunReconciliationHandler)
+	application.GET("/admin/reconciliation/runs", auth.RequireStaff(auth.RoleAdmin), reconciliation.ListRunsHandler)
+	application.GET("/admin/reconciliation/runs/:run_id/items", auth.RequireStaff(auth.RoleAdmin), reconciliation.RunItemsHandler)
+	application.POST("/admin/reconciliation/items/:item_id", auth.RequireStaff(auth.RoleAdmin), reconciliation.ResolveItemHandler)
 
 	// Webhook
 	application.POST("/webhook", webhook.WebhookHandler)
 
+	// Statement
+	// Download statement as CSV or PDF
+	application.GET("/statement/:user_id", statement.DownloadStatementHandler)
+
+	// Email statement to the user
+	application.POST("/statement/email", statement.EmailStatementHandler)
+
+	// Scheduled and recurring payments
+	application.POST("/schedule", schedule.CreateScheduleHandler)
+	application.GET("/schedule/user/:user_id", schedule.ListSchedulesHandler)
+	application.GET("/schedule/:schedule_id/runs", schedule.ListScheduleRunsHandler)
+	application.POST("/schedule/:schedule_id/pause", schedule.PauseScheduleHandler)
+	application.POST("/schedule/:schedule_id/resume", schedule.ResumeScheduleHandler)
+	application.POST("/schedule/:schedule_id/cancel", schedule.CancelScheduleHandler)
+
+	// Run due scheduled payments every minute
+	schedule.StartScheduler(time.Minute)
+
+	// Keep the data plan catalogue warm
+	bill.StartPlanCatalogueRefresh(10 * time.Minute)
+
+	// Requery pending airtime and data purchases every minute
+	bill.StartVASRequery(time.Minute)
+
+	// Requery transfers whose submission outcome is unknown every five minutes
+	transaction.StartTransferRequery(5 * time.Minute)
+
+	// Poll provider float balances every five minutes
+	float.StartMonitor(5*time.Minute, append(bill.FloatSources(), float.Paystack())...)
+
+	// Purge KYC documents past their retention period every hour
+	kyc.StartDocumentRetention(time.Hour)
+
+	// Retry failed dedicated account assignments every five minutes
+	wallet.StartDVARetry(5 * time.Minute)
+
+	// Top up low wallets from saved cards every five minutes
+	funding.StartAutoTopUp(5 * time.Minute)
+
+	// Settle saved card charges with an unknown outcome every five minutes
+	funding.StartCardFundingRequery(5 * time.Minute)
+
+	// Reconcile the previous day once it is over, checking every hour
+	reconciliation.StartReconciliation(time.Hour)
 
     // Run the application on port 8081
     application.Run(":8081")
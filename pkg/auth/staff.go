@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Staff roles
+const (
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// staffContextKey is the gin context key the authenticated staff member is stored under
+const staffContextKey = "staff"
+
+// Staff represents a support or admin user calling a back-office endpoint
+type Staff struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// RequireStaff authenticates the X-Staff-Key header against STAFF_API_KEYS and rejects
+// staff whose role is not in roles. Admins are allowed on every staff endpoint.
+// STAFF_API_KEYS is a comma separated list of name:role:key entries.
+func RequireStaff(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		staff, ok := lookupStaff(c.GetHeader("X-Staff-Key"))
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, Response{
+				Status:  "error",
+				Message: "Invalid or missing staff key",
+			})
+			return
+		}
+
+		allowed := staff.Role == RoleAdmin
+		for _, role := range roles {
+			if staff.Role == role {
+				allowed = true
+			}
+		}
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, Response{
+				Status:  "error",
+				Message: "You are not allowed to perform this action",
+			})
+			return
+		}
+
+		c.Set(staffContextKey, staff)
+		c.Next()
+	}
+}
+
+// StaffFromContext returns the staff member authenticated by RequireStaff
+func StaffFromContext(c *gin.Context) (Staff, bool) {
+	value, ok := c.Get(staffContextKey)
+	if !ok {
+		return Staff{}, false
+	}
+	staff, ok := value.(Staff)
+	return staff, ok
+}
+
+// lookupStaff finds the staff member the key belongs to
+func lookupStaff(key string) (Staff, bool) {
+	if key == "" {
+		return Staff{}, false
+	}
+
+	for _, entry := range strings.Split(os.Getenv("STAFF_API_KEYS"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(parts) != 3 || parts[2] == "" {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(parts[2]), []byte(key)) == 1 {
+			return Staff{Name: parts[0], Role: parts[1]}, true
+		}
+	}
+
+	return Staff{}, false
+}
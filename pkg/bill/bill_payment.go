@@ -0,0 +1,198 @@
+package bill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go_code/database"
+	"go_code/pkg/notification"
+	"go_code/pkg/reversal"
+)
+
+// billPaymentCheck represents a bill payment claimed for a status check
+type billPaymentCheck struct {
+	paymentID     int64
+	userID        int
+	transactionID int64
+	reference     string
+	category      string
+	customerID    string
+	amount        float64
+	fee           float64
+	attempts      int
+}
+
+// saveBillPayment records a bill payment and the token or units returned for it.
+// Pending payments are queued for a status check.
+func saveBillPayment(userID int, transactionID int64, result *BillPaymentResult) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	var nextCheck *time.Time
+	if result.Status == BillPaymentPending {
+		next := time.Now().Add(requeryBackoff)
+		nextCheck = &next
+	}
+
+	_, err = db.Exec(context.Background(), `
+		INSERT INTO bill_payment (user_id, transaction_id, reference, provider_reference, category, service_id, customer_id,
+			customer_name, variation_code, amount, fee, status, token, units, next_check_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, NULLIF($9, ''), $10, $11, $12, NULLIF($13, ''), NULLIF($14, ''), $15)
+	`, userID, transactionID, result.Reference, result.ProviderReference, result.Category, result.ServiceID, result.CustomerID,
+		result.CustomerName, result.VariationCode, result.Amount, result.Fee, result.Status, result.Token, result.Units, nextCheck)
+	return err
+}
+
+// requeryPendingBillPayments claims pending bill payments that are due a status check and checks them with their biller
+func requeryPendingBillPayments() error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		UPDATE bill_payment SET next_check_at = NOW() + INTERVAL '`+requeryClaimTimeout+`'
+		WHERE payment_id IN (
+			SELECT payment_id FROM bill_payment
+			WHERE status = 'pending' AND next_check_at <= NOW()
+			ORDER BY next_check_at
+			LIMIT 50
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING payment_id, user_id, transaction_id, reference, category, customer_id, amount, fee, attempts`)
+	if err != nil {
+		return fmt.Errorf("failed to claim pending bill payments: %w", err)
+	}
+
+	var due []*billPaymentCheck
+	for rows.Next() {
+		var payment billPaymentCheck
+		err := rows.Scan(&payment.paymentID, &payment.userID, &payment.transactionID, &payment.reference, &payment.category,
+			&payment.customerID, &payment.amount, &payment.fee, &payment.attempts)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, &payment)
+	}
+	rows.Close()
+
+	for _, payment := range due {
+		requeryBillPayment(payment)
+	}
+
+	return nil
+}
+
+// requeryBillPayment checks a pending bill payment with its biller. Completed payments get their token,
+// failed ones are refunded and payments still pending are checked again later.
+func requeryBillPayment(payment *billPaymentCheck) {
+	biller, ok := billers[payment.category]
+	if !ok {
+		finishBillRequery(payment, nil, "Unsupported bill category "+payment.category)
+		return
+	}
+
+	result, err := biller.QueryStatus(payment.reference)
+	if err != nil {
+		finishBillRequery(payment, nil, err.Error())
+		return
+	}
+
+	if result.Status == BillPaymentFailed {
+		_, err = reversal.Reverse(payment.transactionID, "Biller reported "+categoryName(payment.category)+" payment failed", reversal.InitiatedBySystem)
+		if err != nil && !errors.Is(err, reversal.ErrAlreadyReversed) {
+			finishBillRequery(payment, nil, "Failed to refund: "+err.Error())
+			return
+		}
+	}
+
+	finishBillRequery(payment, result, "")
+	if result.Status != BillPaymentPending {
+		notifyBillPayment(payment, result)
+	}
+}
+
+// finishBillRequery records the outcome of a status check. A payment that is still pending after
+// maxRequeryAttempts checks is no longer requeried and the admin is alerted to review it.
+func finishBillRequery(payment *billPaymentCheck, result *BillPaymentResult, lastError string) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		log.Printf("Failed to connect to database: %v\n", err)
+		return
+	}
+	defer db.Close(context.Background())
+
+	if result == nil {
+		result = &BillPaymentResult{Status: BillPaymentPending}
+	}
+
+	payment.attempts++
+	var nextCheck *time.Time
+	if result.Status == BillPaymentPending && payment.attempts < maxRequeryAttempts {
+		next := time.Now().Add(requeryBackoff * time.Duration(payment.attempts+1))
+		nextCheck = &next
+	}
+
+	_, err = db.Exec(context.Background(), `
+		UPDATE bill_payment
+		SET status = $2, attempts = $3, last_error = NULLIF($4, ''), next_check_at = $5,
+			provider_reference = COALESCE(NULLIF($6, ''), provider_reference),
+			token = COALESCE(NULLIF($7, ''), token), units = COALESCE(NULLIF($8, ''), units)
+		WHERE payment_id = $1
+	`, payment.paymentID, result.Status, payment.attempts, lastError, nextCheck, result.ProviderReference, result.Token, result.Units)
+	if err != nil {
+		log.Printf("Failed to update bill payment %s: %v\n", payment.reference, err)
+		return
+	}
+
+	if result.Status == BillPaymentPending && nextCheck == nil {
+		body := fmt.Sprintf("The %s payment %s for %s is still pending after %d status checks and needs manual review.",
+			categoryName(payment.category), payment.reference, payment.customerID, payment.attempts)
+		if err := notification.SendEmail(os.Getenv("ADMIN_EMAIL"), "Pending bill payment needs review", body); err != nil {
+			fmt.Println("Failed to send email:", err)
+		}
+	}
+}
+
+// notifyBillPayment emails the user the final outcome of a bill payment that was pending, with the token if one was issued
+func notifyBillPayment(payment *billPaymentCheck, result *BillPaymentResult) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		log.Printf("Failed to connect to database: %v\n", err)
+		return
+	}
+	defer db.Close(context.Background())
+
+	var fullname, email string
+	err = db.QueryRow(context.Background(), "SELECT fullname, email FROM users WHERE user_id = $1", payment.userID).Scan(&fullname, &email)
+	if err != nil {
+		log.Printf("Failed to fetch user for bill payment %s: %v\n", payment.reference, err)
+		return
+	}
+
+	label := categoryName(payment.category)
+	subject := label + " payment successful"
+	outcome := fmt.Sprintf("Your %s payment of NGN %.2f for %s has been completed.", label, payment.amount, payment.customerID)
+	if result.Token != "" {
+		outcome += "\nToken: " + result.Token
+	}
+	if result.Status == BillPaymentFailed {
+		subject = label + " payment failed"
+		outcome = fmt.Sprintf("Your %s payment of NGN %.2f for %s failed and NGN %.2f has been refunded to your wallet.",
+			label, payment.amount, payment.customerID, payment.amount+payment.fee)
+	}
+
+	body := fmt.Sprintf("Dear %s,\n\n%s\nReference: %s", fullname, outcome, payment.reference)
+	if err := notification.SendEmail(email, subject, body); err != nil {
+		fmt.Println("Failed to send email:", err)
+	}
+}
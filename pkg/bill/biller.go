@@ -0,0 +1,394 @@
+package bill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go_code/database"
+	"go_code/pkg/fee"
+	"go_code/pkg/float"
+	"go_code/pkg/kyc"
+	"go_code/pkg/ledger"
+)
+
+// Bill categories paid through a Biller
+const (
+	CategoryElectricity = "electricity"
+	CategoryCableTV     = "cable_tv"
+	CategoryBetting     = "betting"
+)
+
+// Bill payment statuses
+const (
+	BillPaymentPending = "pending"
+	BillPaymentSuccess = "success"
+	BillPaymentFailed  = "failed"
+)
+
+// Biller is implemented by the providers of a bill category
+type Biller interface {
+	// Category returns the bill category the biller pays
+	Category() string
+	// Services returns the billers (distribution companies, TV providers, betting platforms) in the category
+	Services() []BillService
+	// Variations returns the meter types or bouquets available for a service
+	Variations(serviceID string) ([]BillVariation, error)
+	// Validate looks up the meter, smartcard or betting account before payment
+	Validate(serviceID, customerID, variationCode string) (*BillCustomer, error)
+	// Balance returns the float available with the provider
+	Balance() (float64, error)
+	// Pay pays the bill under our reference. It only fails when the provider declined the payment;
+	// payments whose outcome is unknown are returned as pending.
+	Pay(request BillPaymentRequest, reference string) (*BillPaymentResult, error)
+	// QueryStatus looks up the status of an earlier payment by our reference
+	QueryStatus(reference string) (*BillPaymentResult, error)
+}
+
+// BillService represents a biller within a category
+type BillService struct {
+	ServiceID string `json:"service_id"`
+	Name      string `json:"name"`
+}
+
+// BillVariation represents a meter type or a cable TV bouquet
+type BillVariation struct {
+	Code       string  `json:"code"`
+	Name       string  `json:"name"`
+	Amount     float64 `json:"amount"`
+	FixedPrice bool    `json:"fixed_price"`
+}
+
+// BillCustomer represents the validated owner of a meter, smartcard or betting account
+type BillCustomer struct {
+	CustomerID     string  `json:"customer_id"`
+	CustomerName   string  `json:"customer_name"`
+	Address        string  `json:"address,omitempty"`
+	MeterType      string  `json:"meter_type,omitempty"`
+	CurrentBouquet string  `json:"current_bouquet,omitempty"`
+	DueDate        string  `json:"due_date,omitempty"`
+	MinimumAmount  float64 `json:"minimum_amount,omitempty"`
+}
+
+// BillValidationRequest represents the request payload for validating a customer
+type BillValidationRequest struct {
+	ServiceID     string `json:"service_id"`
+	CustomerID    string `json:"customer_id"`
+	VariationCode string `json:"variation_code"`
+}
+
+// BillPaymentRequest represents the request payload for paying a bill
+type BillPaymentRequest struct {
+	UserID        int     `json:"user_id"`
+	ServiceID     string  `json:"service_id"`
+	CustomerID    string  `json:"customer_id"`    // Meter number, smartcard number or betting account ID
+	VariationCode string  `json:"variation_code"` // Meter type or bouquet code
+	Amount        float64 `json:"amount"`         // Ignored for fixed price bouquets
+	Phone         string  `json:"phone"`
+}
+
+// BillPaymentResult represents the receipt of a bill payment
+type BillPaymentResult struct {
+	Reference         string  `json:"reference"`
+	ProviderReference string  `json:"provider_reference"`
+	Category          string  `json:"category"`
+	ServiceID         string  `json:"service_id"`
+	CustomerID        string  `json:"customer_id"`
+	CustomerName      string  `json:"customer_name"`
+	VariationCode     string  `json:"variation_code,omitempty"`
+	Amount            float64 `json:"amount"`
+	Fee               float64 `json:"fee"`
+	TotalDebited      float64 `json:"total_debited"`
+	Status            string  `json:"status"`
+	Token             string  `json:"token,omitempty"`
+	Units             string  `json:"units,omitempty"`
+}
+
+// billers holds the biller of each category
+var billers = map[string]Biller{
+	CategoryElectricity: newAggregatorBiller(CategoryElectricity, electricityServices),
+	CategoryCableTV:     newAggregatorBiller(CategoryCableTV, cableTVServices),
+	CategoryBetting:     newAggregatorBiller(CategoryBetting, bettingServices),
+}
+
+// billerFromContext returns the biller for the category in the URL, writing an error response if there is none
+func billerFromContext(c *gin.Context) (Biller, bool) {
+	biller, ok := billers[c.Param("category")]
+	if !ok {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Unsupported bill category",
+		})
+	}
+	return biller, ok
+}
+
+// BillServicesHandler lists the billers in a category
+func BillServicesHandler(c *gin.Context) {
+	biller, ok := billerFromContext(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Services fetched successfully",
+		Result:  biller.Services(),
+	})
+}
+
+// BillVariationsHandler lists the meter types or bouquets of a service
+func BillVariationsHandler(c *gin.Context) {
+	biller, ok := billerFromContext(c)
+	if !ok {
+		return
+	}
+
+	variations, err := biller.Variations(c.Query("service_id"))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, Response{
+			Status:  "error",
+			Message: "Failed to fetch variations: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Variations fetched successfully",
+		Result:  variations,
+	})
+}
+
+// ValidateBillCustomerHandler validates a meter, smartcard or betting account before payment
+func ValidateBillCustomerHandler(c *gin.Context) {
+	biller, ok := billerFromContext(c)
+	if !ok {
+		return
+	}
+
+	var request BillValidationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON input: " + err.Error(),
+		})
+		return
+	}
+
+	customer, err := biller.Validate(request.ServiceID, strings.TrimSpace(request.CustomerID), request.VariationCode)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Failed to validate customer: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Customer validated successfully",
+		Result:  customer,
+	})
+}
+
+// BillPaymentHandler pays an electricity, cable TV or betting bill from the wallet
+func BillPaymentHandler(c *gin.Context) {
+	biller, ok := billerFromContext(c)
+	if !ok {
+		return
+	}
+
+	var request BillPaymentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON input: " + err.Error(),
+		})
+		return
+	}
+
+	result, err := PayBill(biller, request)
+	if err != nil {
+		respondPurchaseError(c, err)
+		return
+	}
+
+	message := "Bill payment successful"
+	if result.Status == BillPaymentPending {
+		message = "Bill payment is processing"
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: message,
+		Result:  result,
+	})
+}
+
+// PayBill validates the customer, checks the user's and provider's balances, debits the wallet
+// and pays the bill. The debit is reversed if the provider declines the payment; payments whose outcome
+// is unknown are left pending and requeried.
+func PayBill(biller Biller, request BillPaymentRequest) (*BillPaymentResult, error) {
+	request.CustomerID = strings.TrimSpace(request.CustomerID)
+	if request.ServiceID == "" || request.CustomerID == "" {
+		return nil, &PurchaseError{StatusCode: http.StatusBadRequest, Message: "Service ID and customer ID are required"}
+	}
+
+	// Step 1: Price the payment from the bouquet when it has a fixed price
+	if request.VariationCode != "" {
+		variations, err := biller.Variations(request.ServiceID)
+		if err != nil {
+			return nil, &PurchaseError{StatusCode: http.StatusBadGateway, Message: "Failed to fetch variations: " + err.Error(), Err: err}
+		}
+		found := false
+		for _, variation := range variations {
+			if variation.Code == request.VariationCode {
+				found = true
+				if variation.FixedPrice {
+					request.Amount = variation.Amount
+				}
+				break
+			}
+		}
+		if !found {
+			return nil, &PurchaseError{StatusCode: http.StatusBadRequest, Message: "Invalid variation selected"}
+		}
+	}
+	request.Amount = math.Round(request.Amount*100) / 100
+	if request.Amount <= 0 {
+		return nil, &PurchaseError{StatusCode: http.StatusBadRequest, Message: "Amount must be greater than zero"}
+	}
+
+	// Step 2: Validate the customer
+	customer, err := biller.Validate(request.ServiceID, request.CustomerID, request.VariationCode)
+	if err != nil {
+		return nil, &PurchaseError{StatusCode: http.StatusBadRequest, Message: "Failed to validate customer: " + err.Error(), Err: err}
+	}
+	if customer.MinimumAmount > 0 && request.Amount < customer.MinimumAmount {
+		return nil, &PurchaseError{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("The minimum amount for this customer is NGN %.2f", customer.MinimumAmount)}
+	}
+
+	// Step 3: Check the user's balance
+	quote, err := fee.Calculate(request.UserID, biller.Category(), request.Amount)
+	if err != nil {
+		return nil, &PurchaseError{StatusCode: http.StatusInternalServerError, Message: "Failed to calculate fee: " + err.Error(), Err: err}
+	}
+	if err := checkKYCLimits(request.UserID, request.Amount); err != nil {
+		return nil, err
+	}
+	if err := checkWalletBalance(request.UserID, quote.Total); err != nil {
+		return nil, err
+	}
+
+	// Step 4: Check the provider's float
+	if err := checkProviderFloat("aggregator", biller.Balance, request.Amount); err != nil {
+		return nil, err
+	}
+
+	// Step 5: Debit the wallet and the fee before paying the provider
+	reference := ledger.NewReference("BIL")
+	narration := fmt.Sprintf("%s payment for %s", categoryName(biller.Category()), request.CustomerID)
+	transactionID, err := debitPurchase(request.UserID, request.Amount, quote, reference, narration)
+	if err != nil {
+		return nil, debitError(err)
+	}
+
+	// Step 6: Pay the bill, reversing the debit if the provider declines it
+	result, err := biller.Pay(request, reference)
+	if err != nil {
+		return nil, reversePurchase(transactionID, &PurchaseError{StatusCode: http.StatusBadGateway, Message: "Bill payment failed: " + err.Error(), Err: err})
+	}
+
+	result.Reference = reference
+	result.Category = biller.Category()
+	result.ServiceID = request.ServiceID
+	result.CustomerID = request.CustomerID
+	result.CustomerName = customer.CustomerName
+	result.VariationCode = request.VariationCode
+	result.Amount = request.Amount
+	result.Fee = quote.Fee
+	result.TotalDebited = quote.Total
+
+	// Step 7: Record the payment against the wallet debit
+	if err := linkProviderReference(transactionID, result.ProviderReference); err != nil {
+		fmt.Println("Failed to save transaction data:", err)
+	}
+	if err := saveBillPayment(request.UserID, transactionID, result); err != nil {
+		fmt.Println("Failed to save bill payment:", err)
+	}
+
+	return result, nil
+}
+
+// checkKYCLimits rejects a purchase that would exceed the user's KYC tier limits
+func checkKYCLimits(userID int, amounts ...float64) error {
+	err := kyc.CheckDebit(userID, amounts...)
+	if err == nil {
+		return nil
+	}
+
+	var limitErr *kyc.LimitError
+	if errors.As(err, &limitErr) {
+		return &PurchaseError{StatusCode: http.StatusForbidden, Message: limitErr.Error(), Result: limitErr, Err: err}
+	}
+	return &PurchaseError{StatusCode: http.StatusInternalServerError, Message: "Failed to check KYC limits: " + err.Error(), Err: err}
+}
+
+// checkWalletBalance rejects a purchase the user's wallet cannot cover
+func checkWalletBalance(userID int, total float64) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return &PurchaseError{StatusCode: http.StatusInternalServerError, Message: "Failed to connect to database: " + err.Error(), Err: err}
+	}
+	defer db.Close(context.Background())
+
+	var currentBalance float64
+	err = db.QueryRow(context.Background(), "SELECT current_balance FROM wallet WHERE user_id = $1", userID).Scan(&currentBalance)
+	if err != nil {
+		return &PurchaseError{StatusCode: http.StatusInternalServerError, Message: "Failed to retrieve balance: " + err.Error(), Err: err}
+	}
+
+	if currentBalance < total {
+		return &PurchaseError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Insufficient balance.",
+			Result:     map[string]float64{"current_balance": currentBalance},
+			Err:        ledger.ErrInsufficientBalance,
+		}
+	}
+
+	return nil
+}
+
+// checkProviderFloat rejects a purchase the provider's float cannot cover and reports the low float
+func checkProviderFloat(provider string, balance func() (float64, error), amount float64) error {
+	available, err := balance()
+	if err != nil {
+		return &PurchaseError{StatusCode: http.StatusInternalServerError, Message: "Failed to check provider balance: " + err.Error(), Err: err}
+	}
+
+	if available < amount {
+		float.ReportLow(provider, available)
+		return &PurchaseError{StatusCode: http.StatusServiceUnavailable, Message: "Insufficient balance in our vault, please try again later."}
+	}
+
+	return nil
+}
+
+// categoryName returns the label used for a bill category in narrations
+func categoryName(category string) string {
+	switch category {
+	case CategoryElectricity:
+		return "Electricity"
+	case CategoryCableTV:
+		return "Cable TV"
+	case CategoryBetting:
+		return "Betting wallet"
+	}
+	return "Bill"
+}
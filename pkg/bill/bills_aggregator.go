@@ -0,0 +1,351 @@
+package bill
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Services offered by the bills aggregator in each category
+var (
+	electricityServices = []BillService{
+		{ServiceID: "ikeja-electric", Name: "Ikeja Electric (IKEDC)"},
+		{ServiceID: "eko-electric", Name: "Eko Electric (EKEDC)"},
+		{ServiceID: "abuja-electric", Name: "Abuja Electric (AEDC)"},
+		{ServiceID: "ibadan-electric", Name: "Ibadan Electric (IBEDC)"},
+		{ServiceID: "enugu-electric", Name: "Enugu Electric (EEDC)"},
+		{ServiceID: "portharcourt-electric", Name: "Port Harcourt Electric (PHED)"},
+		{ServiceID: "kano-electric", Name: "Kano Electric (KEDCO)"},
+		{ServiceID: "kaduna-electric", Name: "Kaduna Electric (KAEDCO)"},
+		{ServiceID: "jos-electric", Name: "Jos Electric (JED)"},
+		{ServiceID: "benin-electric", Name: "Benin Electric (BEDC)"},
+		{ServiceID: "aba-electric", Name: "Aba Electric (ABA)"},
+		{ServiceID: "yola-electric", Name: "Yola Electric (YEDC)"},
+	}
+	cableTVServices = []BillService{
+		{ServiceID: "dstv", Name: "DStv"},
+		{ServiceID: "gotv", Name: "GOtv"},
+		{ServiceID: "startimes", Name: "StarTimes"},
+		{ServiceID: "showmax", Name: "Showmax"},
+	}
+	bettingServices = []BillService{
+		{ServiceID: "bet9ja", Name: "Bet9ja"},
+		{ServiceID: "sportybet", Name: "SportyBet"},
+		{ServiceID: "betking", Name: "BetKing"},
+		{ServiceID: "1xbet", Name: "1xBet"},
+		{ServiceID: "nairabet", Name: "NairaBet"},
+	}
+)
+
+// Meter types accepted for electricity payments
+var meterTypes = []BillVariation{
+	{Code: "prepaid", Name: "Prepaid"},
+	{Code: "postpaid", Name: "Postpaid"},
+}
+
+// Aggregator response codes
+const (
+	aggregatorSuccess    = "000"
+	aggregatorProcessing = "099"
+)
+
+// aggregatorBiller pays bills through the bills aggregator configured by BILLS_API_URL,
+// BILLS_API_KEY and BILLS_SECRET_KEY
+type aggregatorBiller struct {
+	category string
+	services []BillService
+}
+
+// AggregatorVariationsResponse represents the response from the aggregator for service variations
+type AggregatorVariationsResponse struct {
+	ResponseDescription string `json:"response_description"`
+	Content             struct {
+		Variations []struct {
+			VariationCode   string `json:"variation_code"`
+			Name            string `json:"name"`
+			VariationAmount string `json:"variation_amount"`
+			FixedPrice      string `json:"fixedPrice"`
+		} `json:"variations"`
+	} `json:"content"`
+}
+
+// AggregatorVerifyResponse represents the response from the aggregator for a customer lookup
+type AggregatorVerifyResponse struct {
+	Code    string `json:"code"`
+	Content struct {
+		CustomerName   string      `json:"Customer_Name"`
+		Address        string      `json:"Address"`
+		MeterType      string      `json:"Meter_Type"`
+		CurrentBouquet string      `json:"Current_Bouquet"`
+		DueDate        string      `json:"Due_Date"`
+		MinimumAmount  json.Number `json:"Minimum_Amount"`
+		Error          string      `json:"error"`
+	} `json:"content"`
+}
+
+// AggregatorPayResponse represents the response from the aggregator for a payment
+type AggregatorPayResponse struct {
+	Code                string `json:"code"`
+	ResponseDescription string `json:"response_description"`
+	RequestID           string `json:"requestId"`
+	Content             struct {
+		Transactions struct {
+			Status        string `json:"status"`
+			TransactionID string `json:"transactionId"`
+		} `json:"transactions"`
+	} `json:"content"`
+	PurchasedCode  string      `json:"purchased_code"`
+	MainToken      string      `json:"mainToken"`
+	MainTokenUnits json.Number `json:"mainTokenUnits"`
+}
+
+// AggregatorBalanceResponse represents the response from the aggregator for the wallet balance
+type AggregatorBalanceResponse struct {
+	Contents struct {
+		Balance float64 `json:"balance"`
+	} `json:"contents"`
+}
+
+func newAggregatorBiller(category string, services []BillService) *aggregatorBiller {
+	return &aggregatorBiller{category: category, services: services}
+}
+
+func (b *aggregatorBiller) Category() string {
+	return b.category
+}
+
+func (b *aggregatorBiller) Services() []BillService {
+	return b.services
+}
+
+func (b *aggregatorBiller) Variations(serviceID string) ([]BillVariation, error) {
+	if !b.hasService(serviceID) {
+		return nil, fmt.Errorf("unknown service %q", serviceID)
+	}
+
+	switch b.category {
+	case CategoryElectricity:
+		return meterTypes, nil
+	case CategoryBetting:
+		return []BillVariation{}, nil
+	}
+
+	body, err := aggregatorRequest("GET", "/service-variations?serviceID="+serviceID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var variationsResponse AggregatorVariationsResponse
+	if err := json.Unmarshal(body, &variationsResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse variations: %w", err)
+	}
+
+	variations := make([]BillVariation, 0, len(variationsResponse.Content.Variations))
+	for _, v := range variationsResponse.Content.Variations {
+		amount, _ := strconv.ParseFloat(v.VariationAmount, 64)
+		variations = append(variations, BillVariation{
+			Code:       v.VariationCode,
+			Name:       v.Name,
+			Amount:     amount,
+			FixedPrice: strings.EqualFold(v.FixedPrice, "yes"),
+		})
+	}
+
+	return variations, nil
+}
+
+func (b *aggregatorBiller) Validate(serviceID, customerID, variationCode string) (*BillCustomer, error) {
+	if !b.hasService(serviceID) {
+		return nil, fmt.Errorf("unknown service %q", serviceID)
+	}
+	if customerID == "" {
+		return nil, fmt.Errorf("customer ID is required")
+	}
+
+	payload := map[string]string{
+		"serviceID":   serviceID,
+		"billersCode": customerID,
+	}
+	if b.category == CategoryElectricity {
+		if variationCode != "prepaid" && variationCode != "postpaid" {
+			return nil, fmt.Errorf("meter type must be prepaid or postpaid")
+		}
+		payload["type"] = variationCode
+	}
+
+	body, err := aggregatorRequest("POST", "/merchant-verify", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var verifyResponse AggregatorVerifyResponse
+	if err := json.Unmarshal(body, &verifyResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse validation response: %w", err)
+	}
+	if verifyResponse.Content.Error != "" {
+		return nil, fmt.Errorf("%s", verifyResponse.Content.Error)
+	}
+	if verifyResponse.Code != aggregatorSuccess || verifyResponse.Content.CustomerName == "" {
+		return nil, fmt.Errorf("customer not found")
+	}
+
+	minimumAmount, _ := verifyResponse.Content.MinimumAmount.Float64()
+	return &BillCustomer{
+		CustomerID:     customerID,
+		CustomerName:   strings.TrimSpace(verifyResponse.Content.CustomerName),
+		Address:        verifyResponse.Content.Address,
+		MeterType:      verifyResponse.Content.MeterType,
+		CurrentBouquet: verifyResponse.Content.CurrentBouquet,
+		DueDate:        verifyResponse.Content.DueDate,
+		MinimumAmount:  minimumAmount,
+	}, nil
+}
+
+func (b *aggregatorBiller) Balance() (float64, error) {
+	body, err := aggregatorRequest("GET", "/balance", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var balanceResponse AggregatorBalanceResponse
+	if err := json.Unmarshal(body, &balanceResponse); err != nil {
+		return 0, fmt.Errorf("failed to parse balance: %w", err)
+	}
+
+	return balanceResponse.Contents.Balance, nil
+}
+
+func (b *aggregatorBiller) Pay(request BillPaymentRequest, reference string) (*BillPaymentResult, error) {
+	payload := map[string]interface{}{
+		"request_id":  reference,
+		"serviceID":   request.ServiceID,
+		"billersCode": request.CustomerID,
+		"amount":      request.Amount,
+		"phone":       request.Phone,
+	}
+	if request.VariationCode != "" {
+		payload["variation_code"] = request.VariationCode
+	}
+	if b.category == CategoryCableTV {
+		payload["subscription_type"] = "change"
+	}
+
+	body, err := aggregatorRequest("POST", "/pay", payload)
+	if err != nil {
+		return pendingBillPayment(err)
+	}
+
+	var payResponse AggregatorPayResponse
+	if err := json.Unmarshal(body, &payResponse); err != nil {
+		return pendingBillPayment(&ProviderError{Provider: "aggregator", Err: fmt.Errorf("failed to parse payment response: %w", err)})
+	}
+
+	result := billPaymentResult(payResponse)
+	if result.Status == BillPaymentFailed {
+		return nil, &ProviderError{Provider: "aggregator", Retryable: true, Err: errors.New(payResponse.ResponseDescription)}
+	}
+
+	return result, nil
+}
+
+// QueryStatus requeries a payment. The aggregator identifies payments by our request ID.
+func (b *aggregatorBiller) QueryStatus(reference string) (*BillPaymentResult, error) {
+	body, err := aggregatorRequest("POST", "/requery", map[string]string{"request_id": reference})
+	if err != nil {
+		return nil, err
+	}
+
+	var payResponse AggregatorPayResponse
+	if err := json.Unmarshal(body, &payResponse); err != nil {
+		return nil, &ProviderError{Provider: "aggregator", Err: fmt.Errorf("failed to parse requery response: %w", err)}
+	}
+
+	return billPaymentResult(payResponse), nil
+}
+
+// billPaymentResult reads the status, token and units of a payment from the aggregator's pay or requery response
+func billPaymentResult(payResponse AggregatorPayResponse) *BillPaymentResult {
+	result := &BillPaymentResult{
+		ProviderReference: payResponse.Content.Transactions.TransactionID,
+		Token:             payResponse.MainToken,
+		Units:             payResponse.MainTokenUnits.String(),
+	}
+	switch aggregatorStatus(payResponse.Code, payResponse.Content.Transactions.Status) {
+	case VASStatusSuccess:
+		result.Status = BillPaymentSuccess
+	case VASStatusFailed:
+		result.Status = BillPaymentFailed
+	default:
+		result.Status = BillPaymentPending
+	}
+	if result.Token == "" {
+		result.Token = strings.TrimSpace(strings.TrimPrefix(payResponse.PurchasedCode, "Token :"))
+	}
+	return result
+}
+
+// pendingBillPayment turns an error after which the aggregator may still have processed the payment into
+// a pending payment, so it is requeried rather than refunded
+func pendingBillPayment(err error) (*BillPaymentResult, error) {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) && !providerErr.Retryable {
+		fmt.Printf("Bill payment outcome unknown, marking as pending: %v\n", err)
+		return &BillPaymentResult{Status: BillPaymentPending}, nil
+	}
+	return nil, err
+}
+
+// hasService reports whether the service belongs to the biller's category
+func (b *aggregatorBiller) hasService(serviceID string) bool {
+	for _, service := range b.services {
+		if service.ServiceID == serviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregatorRequest sends a request to the bills aggregator and returns the body of a successful response
+func aggregatorRequest(method, path string, payload interface{}) ([]byte, error) {
+	requestBody := &bytes.Buffer{}
+	if payload != nil {
+		payloadJSON, err := json.Marshal(payload)
+		if err != nil {
+			return nil, &ProviderError{Provider: "aggregator", Retryable: true, Err: fmt.Errorf("failed to marshal JSON: %w", err)}
+		}
+		requestBody = bytes.NewBuffer(payloadJSON)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(os.Getenv("BILLS_API_URL"), "/")+path, requestBody)
+	if err != nil {
+		return nil, &ProviderError{Provider: "aggregator", Retryable: true, Err: fmt.Errorf("failed to create request: %w", err)}
+	}
+	req.Header.Set("api-key", os.Getenv("BILLS_API_KEY"))
+	req.Header.Set("secret-key", os.Getenv("BILLS_SECRET_KEY"))
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: providerTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &ProviderError{Provider: "aggregator", Retryable: notSent(err), Err: fmt.Errorf("failed to send request: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &ProviderError{Provider: "aggregator", Err: fmt.Errorf("failed to read response: %w", err)}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// Only a 4xx is a certain rejection; after a 5xx or gateway error the aggregator may still deliver
+		return nil, &ProviderError{Provider: "aggregator", Retryable: resp.StatusCode >= 400 && resp.StatusCode < 500, Err: fmt.Errorf("returned %d: %s", resp.StatusCode, string(body))}
+	}
+
+	return body, nil
+}
@@ -0,0 +1,825 @@
+package bill
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/fee"
+	"go_code/pkg/kyc"
+	"go_code/pkg/ledger"
+	"go_code/pkg/notification"
+)
+
+// maxBulkVASItems is the largest number of lines accepted in one batch
+const maxBulkVASItems = 200
+
+// defaultBulkVASConcurrency is the number of purchases sent at once when BULK_VAS_CONCURRENCY is not set
+const defaultBulkVASConcurrency = 5
+
+// BulkVASQueued marks a batch line that has not been sent to a provider yet
+const BulkVASQueued = "queued"
+
+// BulkVASSending marks a batch line that is being sent to a provider
+const BulkVASSending = "sending"
+
+// bulkVASRecoveryDelay is how long a line can stay queued or sending before it is treated as left behind by a restart
+const bulkVASRecoveryDelay = "10 minutes"
+
+// BulkVASRequest represents the request payload for a bulk airtime and data purchase
+type BulkVASRequest struct {
+	UserID int           `json:"user_id"`
+	Items  []BulkVASItem `json:"items"`
+}
+
+// BulkVASItem represents one line in a bulk purchase. Lines with a plan buy data, the rest buy airtime.
+type BulkVASItem struct {
+	ItemID            int64     `json:"item_id,omitempty"`
+	Reference         string    `json:"reference,omitempty"`
+	Product           string    `json:"product,omitempty"`
+	Destination       string    `json:"destination"`
+	Network           string    `json:"network,omitempty"`
+	Plan              string    `json:"plan,omitempty"`
+	Amount            float64   `json:"amount"`
+	Fee               float64   `json:"fee"`
+	Status            string    `json:"status,omitempty"`
+	Provider          string    `json:"provider,omitempty"`
+	ProviderReference string    `json:"provider_reference,omitempty"`
+	Error             string    `json:"error,omitempty"`
+	UpdatedAt         time.Time `json:"updated_at,omitempty"`
+	plan              *DataPlan
+	quote             *fee.Quote
+}
+
+// BulkVASBatch represents the summary of a bulk airtime and data purchase
+type BulkVASBatch struct {
+	BatchID        int64         `json:"batch_id"`
+	UserID         int           `json:"user_id"`
+	Reference      string        `json:"reference"`
+	Status         string        `json:"status"`
+	TotalAmount    float64       `json:"total_amount"`
+	TotalFee       float64       `json:"total_fee"`
+	ItemCount      int           `json:"item_count"`
+	SuccessCount   int           `json:"success_count"`
+	PendingCount   int           `json:"pending_count"`
+	FailedCount    int           `json:"failed_count"`
+	RefundedAmount float64       `json:"refunded_amount"`
+	CreatedAt      time.Time     `json:"created_at"`
+	Items          []BulkVASItem `json:"items,omitempty"`
+}
+
+// BulkVASHandler validates a batch of airtime and data lines, reserves the total from the wallet
+// and processes the lines in the background. The batch is accepted as JSON or as a CSV upload
+// in the "file" form field.
+func BulkVASHandler(c *gin.Context) {
+	request, err := parseBulkVASRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if len(request.Items) == 0 {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "The batch does not contain any lines",
+		})
+		return
+	}
+	if len(request.Items) > maxBulkVASItems {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("A batch can contain at most %d lines", maxBulkVASItems),
+		})
+		return
+	}
+
+	// Validate every line before any money moves
+	if invalid := validateBulkVASItems(request); invalid > 0 {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("%d of %d lines failed validation", invalid, len(request.Items)),
+			Result:  request.Items,
+		})
+		return
+	}
+
+	amounts := make([]float64, len(request.Items))
+	var totalCost float64
+	for i, item := range request.Items {
+		amounts[i] = item.Amount
+		totalCost += itemCost(item)
+	}
+	if err := checkKYCLimits(request.UserID, amounts...); err != nil {
+		respondPurchaseError(c, err)
+		return
+	}
+	if err := vasRouter.CheckFloat(totalCost); err != nil {
+		respondPurchaseError(c, err)
+		return
+	}
+
+	batch, err := reserveBulkVAS(request)
+	if err == ledger.ErrInsufficientBalance {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Insufficient balance.",
+			Result:  map[string]float64{"total_amount": batch.TotalAmount, "total_fee": batch.TotalFee},
+		})
+		return
+	}
+	var limitErr *kyc.LimitError
+	if errors.As(err, &limitErr) {
+		respondPurchaseError(c, debitError(err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to reserve bulk purchase: " + err.Error(),
+		})
+		return
+	}
+
+	go processBulkVAS(batch)
+
+	c.JSON(http.StatusAccepted, Response{
+		Status:  "success",
+		Message: "Bulk purchase accepted and is being processed",
+		Result:  batch,
+	})
+}
+
+// GetBulkVASHandler returns the batch summary and per-line status of a bulk purchase
+func GetBulkVASHandler(c *gin.Context) {
+	batchID, err := strconv.ParseInt(c.Param("batch_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid batch_id parameter",
+		})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user_id parameter",
+		})
+		return
+	}
+
+	summary, err := fetchBulkVASBatch(batchID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Bulk purchase not found: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Bulk purchase retrieved successfully",
+		Result:  summary,
+	})
+}
+
+// parseBulkVASRequest reads the batch from a JSON body or a multipart CSV upload
+func parseBulkVASRequest(c *gin.Context) (*BulkVASRequest, error) {
+	var request BulkVASRequest
+
+	if !strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			return nil, fmt.Errorf("Invalid JSON input: %s", err.Error())
+		}
+		return &request, nil
+	}
+
+	userID, err := strconv.Atoi(c.PostForm("user_id"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid user_id field")
+	}
+	request.UserID = userID
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("CSV file is required: %s", err.Error())
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open CSV file: %s", err.Error())
+	}
+	defer file.Close()
+
+	items, err := parseBulkVASCSV(file)
+	if err != nil {
+		return nil, err
+	}
+	request.Items = items
+
+	return &request, nil
+}
+
+// parseBulkVASCSV parses rows of destination, amount and plan. The amount is ignored for data lines.
+// A header row is optional and, when present, may list the columns in any order.
+func parseBulkVASCSV(r io.Reader) ([]BulkVASItem, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CSV file: %s", err.Error())
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{"destination": 0, "amount": 1, "plan": 2}
+	header := map[string]int{}
+	for i, name := range records[0] {
+		header[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	// Destinations are phone numbers, so a first row naming any column is the header
+	_, hasDestination := header["destination"]
+	_, hasAmount := header["amount"]
+	_, hasPlan := header["plan"]
+	if hasDestination || hasAmount || hasPlan {
+		if !hasDestination {
+			return nil, fmt.Errorf("CSV header is missing the destination column")
+		}
+		columns = header
+		records = records[1:]
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var items []BulkVASItem
+	for line, record := range records {
+		item := BulkVASItem{
+			Destination: field(record, "destination"),
+			Plan:        field(record, "plan"),
+		}
+		if item.Plan == "" {
+			amount, err := strconv.ParseFloat(field(record, "amount"), 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid amount on row %d", line+1)
+			}
+			item.Amount = amount
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// validateBulkVASItems normalises each destination, prices each line and checks data plans match
+// the destination's network. It returns the number of lines that failed, recording the failure on each.
+func validateBulkVASItems(request *BulkVASRequest) int {
+	invalid := 0
+	for i := range request.Items {
+		item := &request.Items[i]
+
+		destination, err := detectDestination(item.Destination)
+		if err != nil {
+			item.Error = err.(*PurchaseError).Message
+			invalid++
+			continue
+		}
+		item.Destination = destination.Local
+		item.Network = destination.Network
+
+		item.Product = fee.ProductAirtime
+		if item.Plan != "" {
+			item.Product = fee.ProductData
+			plan, err := planCatalogue.Find(item.Plan)
+			if err != nil {
+				item.Error = "failed to fetch data plans: " + err.Error()
+				invalid++
+				continue
+			}
+			if plan == nil {
+				item.Error = "invalid plan selected"
+				invalid++
+				continue
+			}
+			if plan.Network != "" && plan.Network != destination.Network {
+				item.Error = fmt.Sprintf("plan %s is for %s but the destination is on %s", plan.Plan, plan.Network, destination.Network)
+				invalid++
+				continue
+			}
+			item.plan = plan
+			item.Amount = plan.Amount
+		}
+
+		item.Amount = math.Round(item.Amount*100) / 100
+		if item.Amount <= 0 {
+			item.Error = "amount must be greater than zero"
+			invalid++
+			continue
+		}
+
+		quote, err := fee.Calculate(request.UserID, item.Product, item.Amount)
+		if err != nil {
+			item.Error = "failed to calculate fee: " + err.Error()
+			invalid++
+			continue
+		}
+		item.quote = quote
+		item.Fee = quote.Fee
+	}
+
+	return invalid
+}
+
+// reserveBulkVAS debits the batch total and the fee on each line from the wallet and records the batch and its lines
+func reserveBulkVAS(request *BulkVASRequest) (*BulkVASBatch, error) {
+	batch := &BulkVASBatch{
+		UserID:    request.UserID,
+		Reference: ledger.NewReference("BVAS"),
+		Status:    "processing",
+		ItemCount: len(request.Items),
+		Items:     request.Items,
+	}
+	for _, item := range request.Items {
+		batch.TotalAmount += item.Amount
+		batch.TotalFee += item.Fee
+	}
+	batch.TotalAmount = math.Round(batch.TotalAmount*100) / 100
+	batch.TotalFee = math.Round(batch.TotalFee*100) / 100
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return batch, err
+	}
+	defer db.Close(context.Background())
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return batch, err
+	}
+	defer tx.Rollback(context.Background())
+
+	amounts := make([]float64, len(batch.Items))
+	for i, item := range batch.Items {
+		amounts[i] = item.Amount
+	}
+	if err := kyc.CheckDebitTx(tx, request.UserID, amounts...); err != nil {
+		return batch, err
+	}
+
+	debitID, err := ledger.Debit(tx, ledger.Entry{
+		UserID:    request.UserID,
+		Amount:    batch.TotalAmount,
+		Reference: batch.Reference,
+		Narration: fmt.Sprintf("Bulk airtime and data for %d lines", batch.ItemCount),
+	})
+	if err != nil {
+		return batch, err
+	}
+
+	err = tx.QueryRow(context.Background(), `
+		INSERT INTO bulk_vas_batch (user_id, reference, total_amount, total_fee, item_count, status, debit_transaction_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING batch_id, created_at
+	`, batch.UserID, batch.Reference, batch.TotalAmount, batch.TotalFee, batch.ItemCount, batch.Status, debitID).Scan(&batch.BatchID, &batch.CreatedAt)
+	if err != nil {
+		return batch, fmt.Errorf("failed to save batch: %w", err)
+	}
+
+	for i := range batch.Items {
+		item := &batch.Items[i]
+		item.Reference = fmt.Sprintf("%s-%d", batch.Reference, i+1)
+		item.Status = BulkVASQueued
+		err = tx.QueryRow(context.Background(), `
+			INSERT INTO bulk_vas_item (batch_id, reference, product, destination, network, plan, amount, fee, status)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9)
+			RETURNING item_id
+		`, batch.BatchID, item.Reference, item.Product, item.Destination, item.Network, item.Plan, item.Amount, item.Fee, item.Status).Scan(&item.ItemID)
+		if err != nil {
+			return batch, fmt.Errorf("failed to save batch line: %w", err)
+		}
+
+		if _, err := fee.Charge(tx, batch.UserID, item.quote, item.Reference); err != nil {
+			return batch, err
+		}
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return batch, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return batch, nil
+}
+
+// processBulkVAS buys every line of the batch, at most BULK_VAS_CONCURRENCY at a time,
+// and emails the user a summary once every line has been sent
+func processBulkVAS(batch *BulkVASBatch) {
+	workers := make(chan struct{}, bulkVASConcurrency())
+	var wg sync.WaitGroup
+
+	for i := range batch.Items {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(item *BulkVASItem) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			processBulkVASItem(batch.UserID, item)
+		}(&batch.Items[i])
+	}
+	wg.Wait()
+
+	summary, err := fetchBulkVASBatch(batch.BatchID, batch.UserID)
+	if err != nil {
+		log.Printf("Failed to fetch bulk purchase %s: %v\n", batch.Reference, err)
+		return
+	}
+	notifyBulkVAS(summary)
+}
+
+// processBulkVASItem buys one line. Failed lines are refunded; pending lines are requeried
+// with the other pending purchases and settled when their final status is known.
+func processBulkVASItem(userID int, item *BulkVASItem) {
+	// Only the worker that moves the line out of queued sends it, so a resumed line is never bought twice
+	claimed, err := claimBulkVASItem(item.Reference)
+	if err != nil {
+		log.Printf("Failed to claim bulk purchase line %s: %v\n", item.Reference, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	var purchase *VASPurchase
+	if item.Product == fee.ProductData {
+		purchase, err = vasRouter.PurchaseData(item.Destination, item.plan.vasPlan(), item.Reference)
+	} else {
+		purchase, err = vasRouter.PurchaseAirtime(item.Destination, item.Amount, item.Reference)
+	}
+
+	if err != nil {
+		if updateErr := updateBulkVASItem(item.Reference, VASStatusFailed, vasPurchaseError(err).Message); updateErr != nil {
+			log.Printf("Failed to refund bulk purchase line %s: %v\n", item.Reference, updateErr)
+		}
+		return
+	}
+
+	if err := saveBulkVASPurchase(item, purchase); err != nil {
+		log.Printf("Failed to save bulk purchase line %s: %v\n", item.Reference, err)
+	}
+	if err := saveVASPurchase(userID, 0, item.Reference, item.Product, item.Plan, item.Amount, item.quote, purchase); err != nil {
+		log.Printf("Failed to save purchase %s: %v\n", item.Reference, err)
+	}
+	if purchase.Status == VASStatusSuccess {
+		if err := updateBulkVASItem(item.Reference, VASStatusSuccess, ""); err != nil {
+			log.Printf("Failed to update bulk purchase line %s: %v\n", item.Reference, err)
+		}
+	}
+}
+
+// claimBulkVASItem marks a queued line as being sent and reports whether this caller claimed it
+func claimBulkVASItem(reference string) (bool, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return false, err
+	}
+	defer db.Close(context.Background())
+
+	tag, err := db.Exec(context.Background(), `
+		UPDATE bulk_vas_item SET status = $1, updated_at = NOW()
+		WHERE reference = $2 AND status = $3
+	`, BulkVASSending, reference, BulkVASQueued)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// recoverBulkVASItems resumes bulk purchase lines a restart left behind after the batch was debited.
+// Queued lines were never sent and are sent now. Lines interrupted while being sent may have been
+// delivered, so they are left pending and the admin is alerted to review them.
+func recoverBulkVASItems() error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		UPDATE bulk_vas_item SET status = $1, failure_reason = 'Sending was interrupted, manual review required', updated_at = NOW()
+		WHERE status = $2 AND updated_at < NOW() - INTERVAL '`+bulkVASRecoveryDelay+`'
+		RETURNING reference, product, destination, amount
+	`, VASStatusPending, BulkVASSending)
+	if err != nil {
+		return fmt.Errorf("failed to fetch interrupted bulk purchase lines: %w", err)
+	}
+	var interrupted []string
+	for rows.Next() {
+		var reference, product, destination string
+		var amount float64
+		if err := rows.Scan(&reference, &product, &destination, &amount); err != nil {
+			rows.Close()
+			return err
+		}
+		interrupted = append(interrupted, fmt.Sprintf("%s: %s of NGN %.2f for %s", reference, product, amount, destination))
+	}
+	rows.Close()
+
+	if len(interrupted) > 0 {
+		body := "The following bulk purchase lines were interrupted while being sent and need manual review:\n\n" + strings.Join(interrupted, "\n")
+		if err := notification.SendEmail(os.Getenv("ADMIN_EMAIL"), "Interrupted bulk purchase lines need review", body); err != nil {
+			fmt.Println("Failed to send email:", err)
+		}
+	}
+
+	rows, err = db.Query(context.Background(), `
+		SELECT b.batch_id, b.user_id, b.reference, i.reference, i.product, i.destination, i.network, COALESCE(i.plan, ''), i.amount, i.fee
+		FROM bulk_vas_item i
+		JOIN bulk_vas_batch b ON b.batch_id = i.batch_id
+		WHERE i.status = $1 AND b.created_at < NOW() - INTERVAL '`+bulkVASRecoveryDelay+`'
+		ORDER BY i.item_id
+		LIMIT $2
+	`, BulkVASQueued, maxBulkVASItems)
+	if err != nil {
+		return fmt.Errorf("failed to fetch queued bulk purchase lines: %w", err)
+	}
+
+	var batches []*BulkVASBatch
+	byID := make(map[int64]*BulkVASBatch)
+	for rows.Next() {
+		var batch BulkVASBatch
+		var item BulkVASItem
+		err := rows.Scan(&batch.BatchID, &batch.UserID, &batch.Reference, &item.Reference, &item.Product, &item.Destination,
+			&item.Network, &item.Plan, &item.Amount, &item.Fee)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if byID[batch.BatchID] == nil {
+			byID[batch.BatchID] = &batch
+			batches = append(batches, &batch)
+		}
+		item.Status = BulkVASQueued
+		item.quote = &fee.Quote{Product: item.Product, Amount: item.Amount, Fee: item.Fee, Total: item.Amount + item.Fee}
+		byID[batch.BatchID].Items = append(byID[batch.BatchID].Items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, batch := range batches {
+		log.Printf("Resuming %d queued lines of bulk purchase %s\n", len(batch.Items), batch.Reference)
+		resumeBulkVAS(batch)
+	}
+
+	return nil
+}
+
+// resumeBulkVAS sends the queued lines of a batch that was interrupted. Data lines whose plan is
+// no longer offered are refunded.
+func resumeBulkVAS(batch *BulkVASBatch) {
+	items := batch.Items[:0]
+	for _, item := range batch.Items {
+		if item.Product == fee.ProductData {
+			plan, err := planCatalogue.Find(item.Plan)
+			if err != nil {
+				log.Printf("Failed to fetch data plans for bulk purchase line %s: %v\n", item.Reference, err)
+				continue
+			}
+			if plan == nil {
+				if claimed, err := claimBulkVASItem(item.Reference); err != nil || !claimed {
+					continue
+				}
+				if err := updateBulkVASItem(item.Reference, VASStatusFailed, "Data plan is no longer available"); err != nil {
+					log.Printf("Failed to refund bulk purchase line %s: %v\n", item.Reference, err)
+				}
+				continue
+			}
+			item.plan = plan
+		}
+		items = append(items, item)
+	}
+	batch.Items = items
+
+	processBulkVAS(batch)
+}
+
+// saveBulkVASPurchase records the provider that accepted a line and marks it pending until its status is known
+func saveBulkVASPurchase(item *BulkVASItem, purchase *VASPurchase) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	_, err = db.Exec(context.Background(), `
+		UPDATE bulk_vas_item SET status = $1, provider = $2, provider_reference = NULLIF($3, ''), updated_at = NOW()
+		WHERE reference = $4
+	`, VASStatusPending, purchase.Provider, purchase.ProviderReference, item.Reference)
+	return err
+}
+
+// updateBulkVASItem records the final status of a bulk purchase line. Failed lines are refunded
+// to the wallet exactly once. References that do not belong to a bulk purchase are ignored.
+func updateBulkVASItem(reference, status, failureReason string) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	var batchID int64
+	var userID int
+	var product, destination string
+	var amount, itemFee float64
+	var refunded bool
+	err = tx.QueryRow(context.Background(), `
+		SELECT i.batch_id, b.user_id, i.product, i.destination, i.amount, i.fee, i.refunded
+		FROM bulk_vas_item i
+		JOIN bulk_vas_batch b ON b.batch_id = i.batch_id
+		WHERE i.reference = $1
+		FOR UPDATE OF i
+	`, reference).Scan(&batchID, &userID, &product, &destination, &amount, &itemFee, &refunded)
+	if err == pgx.ErrNoRows {
+		// Not a bulk purchase reference
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch batch line: %w", err)
+	}
+
+	refund := status == VASStatusFailed && !refunded
+	_, err = tx.Exec(context.Background(), `
+		UPDATE bulk_vas_item SET status = $1, failure_reason = NULLIF($2, ''), refunded = refunded OR $3, updated_at = NOW()
+		WHERE reference = $4
+	`, status, failureReason, refund, reference)
+	if err != nil {
+		return fmt.Errorf("failed to update batch line: %w", err)
+	}
+
+	if refund {
+		_, err = ledger.Credit(tx, ledger.Entry{
+			UserID:    userID,
+			Amount:    amount,
+			Reference: reference,
+			Narration: fmt.Sprintf("Refund for failed %s purchase for %s", product, destination),
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := fee.Refund(tx, userID, product, itemFee, reference); err != nil {
+			return err
+		}
+	}
+
+	// Close the batch once no line is still queued or pending
+	_, err = tx.Exec(context.Background(), `
+		UPDATE bulk_vas_batch b SET status = CASE
+				WHEN s.failed = 0 THEN 'completed'
+				WHEN s.failed = b.item_count THEN 'failed'
+				ELSE 'partially_failed'
+			END, updated_at = NOW()
+		FROM (
+			SELECT COUNT(*) FILTER (WHERE status IN ('queued', 'sending', 'pending')) AS open,
+				COUNT(*) FILTER (WHERE status = 'failed') AS failed
+			FROM bulk_vas_item WHERE batch_id = $1
+		) s
+		WHERE b.batch_id = $1 AND s.open = 0
+	`, batchID)
+	if err != nil {
+		return fmt.Errorf("failed to update batch: %w", err)
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// fetchBulkVASBatch fetches a batch owned by the user together with its lines
+func fetchBulkVASBatch(batchID int64, userID int) (*BulkVASBatch, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	var batch BulkVASBatch
+	err = db.QueryRow(context.Background(), `
+		SELECT batch_id, user_id, reference, status, total_amount, total_fee, item_count, created_at
+		FROM bulk_vas_batch
+		WHERE batch_id = $1 AND user_id = $2
+	`, batchID, userID).Scan(&batch.BatchID, &batch.UserID, &batch.Reference, &batch.Status, &batch.TotalAmount, &batch.TotalFee, &batch.ItemCount, &batch.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT item_id, reference, product, destination, network, COALESCE(plan, ''), amount, fee, status,
+			COALESCE(provider, ''), COALESCE(provider_reference, ''), COALESCE(failure_reason, ''), refunded, updated_at
+		FROM bulk_vas_item
+		WHERE batch_id = $1
+		ORDER BY item_id ASC
+	`, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item BulkVASItem
+		var refunded bool
+		err := rows.Scan(&item.ItemID, &item.Reference, &item.Product, &item.Destination, &item.Network, &item.Plan, &item.Amount,
+			&item.Fee, &item.Status, &item.Provider, &item.ProviderReference, &item.Error, &refunded, &item.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		switch item.Status {
+		case VASStatusSuccess:
+			batch.SuccessCount++
+		case VASStatusFailed:
+			batch.FailedCount++
+		default:
+			batch.PendingCount++
+		}
+		if refunded {
+			batch.RefundedAmount += item.Amount + item.Fee
+		}
+		batch.Items = append(batch.Items, item)
+	}
+
+	return &batch, rows.Err()
+}
+
+// notifyBulkVAS emails the user a summary of a processed batch
+func notifyBulkVAS(batch *BulkVASBatch) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		log.Printf("Failed to connect to database: %v\n", err)
+		return
+	}
+	defer db.Close(context.Background())
+
+	var fullname, email string
+	err = db.QueryRow(context.Background(), "SELECT fullname, email FROM users WHERE user_id = $1", batch.UserID).Scan(&fullname, &email)
+	if err != nil {
+		log.Printf("Failed to fetch user for bulk purchase %s: %v\n", batch.Reference, err)
+		return
+	}
+
+	body := fmt.Sprintf("Dear %s,\n\nYour bulk airtime and data purchase %s has been processed.\n\n"+
+		"Lines: %d\nSuccessful: %d\nPending: %d\nFailed: %d\nRefunded: NGN %.2f\n",
+		fullname, batch.Reference, batch.ItemCount, batch.SuccessCount, batch.PendingCount, batch.FailedCount, batch.RefundedAmount)
+	if batch.PendingCount > 0 {
+		body += "\nPending lines will be refunded automatically if they fail."
+	}
+	if err := notification.SendEmail(email, "Bulk airtime and data purchase processed", body); err != nil {
+		fmt.Println("Failed to send email:", err)
+	}
+}
+
+// itemCost returns what a line costs us with the provider
+func itemCost(item BulkVASItem) float64 {
+	if item.plan != nil {
+		return item.plan.Cost
+	}
+	return item.Amount
+}
+
+// bulkVASConcurrency returns the number of bulk purchase lines sent to providers at once
+func bulkVASConcurrency() int {
+	concurrency, err := strconv.Atoi(os.Getenv("BULK_VAS_CONCURRENCY"))
+	if err != nil || concurrency <= 0 {
+		return defaultBulkVASConcurrency
+	}
+	return concurrency
+}
@@ -0,0 +1,90 @@
+package bill
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBulkVASCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []BulkVASItem
+		wantErr string
+	}{
+		{
+			name: "without header",
+			csv:  "08031234567,500\n08051234567,0,GLO-1GB-30D\n",
+			want: []BulkVASItem{
+				{Destination: "08031234567", Amount: 500},
+				{Destination: "08051234567", Plan: "GLO-1GB-30D"},
+			},
+		},
+		{
+			name: "without header and without plan column",
+			csv:  "08031234567, 100.50\n+2348051234567,200\n",
+			want: []BulkVASItem{
+				{Destination: "08031234567", Amount: 100.5},
+				{Destination: "+2348051234567", Amount: 200},
+			},
+		},
+		{
+			name: "with header",
+			csv:  "destination,amount,plan\n08031234567,500,\n08051234567,,GLO-1GB-30D\n",
+			want: []BulkVASItem{
+				{Destination: "08031234567", Amount: 500},
+				{Destination: "08051234567", Plan: "GLO-1GB-30D"},
+			},
+		},
+		{
+			name: "with header in another order",
+			csv:  "Plan, Amount, Destination\n,500,08031234567\nMTN-2GB-30D,,08061234567\n",
+			want: []BulkVASItem{
+				{Destination: "08031234567", Amount: 500},
+				{Destination: "08061234567", Plan: "MTN-2GB-30D"},
+			},
+		},
+		{
+			name: "header only",
+			csv:  "destination,amount\n",
+		},
+		{
+			name: "empty file",
+			csv:  "",
+		},
+		{
+			name:    "header without destination",
+			csv:     "amount,plan\n500,\n",
+			wantErr: "CSV header is missing the destination column",
+		},
+		{
+			name:    "invalid amount",
+			csv:     "08031234567,500\n08051234567,five hundred\n",
+			wantErr: "Invalid amount on row 2",
+		},
+		{
+			name:    "malformed CSV",
+			csv:     "08031234567,\"500\n",
+			wantErr: "Invalid CSV file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBulkVASCSV(strings.NewReader(tt.csv))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseBulkVASCSV() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseBulkVASCSV() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBulkVASCSV() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package bill
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go_code/pkg/msisdn"
+)
+
+// defaultPlanCacheTTL is used when DATA_PLAN_CACHE_TTL_SECONDS is not set
+const defaultPlanCacheTTL = 15 * time.Minute
+
+// DataPlan represents a normalised data plan in our catalogue.
+// Amount is the price we charge, including our markup; Cost is what the provider charges us.
+type DataPlan struct {
+	Plan         string  `json:"plan"`
+	Description  string  `json:"description"`
+	Network      string  `json:"network"`
+	Size         string  `json:"size"`
+	SizeMB       float64 `json:"size_mb"`
+	Validity     string  `json:"validity"`
+	ValidityDays int     `json:"validity_days"`
+	Amount       float64 `json:"amount"`
+	Cost         float64 `json:"-"`
+}
+
+// vasPlan returns the provider plan to buy for the catalogue plan
+func (p DataPlan) vasPlan() VASPlan {
+	return VASPlan{
+		Plan:        p.Plan,
+		Description: p.Description,
+		Network:     p.Network,
+		Amount:      p.Cost,
+	}
+}
+
+// PlanCatalogue caches the data plans of the VAS providers.
+// Stale plans are served while a refresh runs in the background.
+type PlanCatalogue struct {
+	mu         sync.Mutex
+	plans      []DataPlan
+	fetchedAt  time.Time
+	refreshing bool
+	fetch      func() ([]VASPlan, error)
+}
+
+// planCatalogue is the data plan catalogue shared by the handlers and purchases
+var planCatalogue = &PlanCatalogue{fetch: vasRouter.DataPlans}
+
+// StartPlanCatalogueRefresh refreshes the data plan catalogue every interval in a background goroutine
+func StartPlanCatalogueRefresh(interval time.Duration) {
+	go func() {
+		if err := planCatalogue.Refresh(); err != nil {
+			log.Printf("Data plan refresh failed: %v\n", err)
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := planCatalogue.Refresh(); err != nil {
+				log.Printf("Data plan refresh failed: %v\n", err)
+			}
+		}
+	}()
+}
+
+// Plans returns the cached plans. An empty catalogue is loaded before returning;
+// an expired one is returned as is and refreshed in the background.
+func (c *PlanCatalogue) Plans() ([]DataPlan, error) {
+	c.mu.Lock()
+	plans := c.plans
+	expired := time.Since(c.fetchedAt) > planCacheTTL()
+	startRefresh := expired && len(plans) > 0 && !c.refreshing
+	if startRefresh {
+		c.refreshing = true
+	}
+	c.mu.Unlock()
+
+	if len(plans) == 0 {
+		if err := c.Refresh(); err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		plans = c.plans
+		c.mu.Unlock()
+		return plans, nil
+	}
+
+	if startRefresh {
+		go func() {
+			if err := c.Refresh(); err != nil {
+				log.Printf("Data plan refresh failed: %v\n", err)
+			}
+		}()
+	}
+
+	return plans, nil
+}
+
+// Refresh fetches the plans from the providers and replaces the cached catalogue.
+// The cached plans are kept if the fetch fails.
+func (c *PlanCatalogue) Refresh() error {
+	providerPlans, err := c.fetch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = false
+	if err != nil {
+		return fmt.Errorf("failed to fetch data plans: %w", err)
+	}
+
+	plans := make([]DataPlan, 0, len(providerPlans))
+	for _, plan := range providerPlans {
+		plans = append(plans, normaliseDataPlan(plan))
+	}
+	c.plans = plans
+	c.fetchedAt = time.Now()
+	return nil
+}
+
+// Find returns the plan with the given code
+func (c *PlanCatalogue) Find(code string) (*DataPlan, error) {
+	plans, err := c.Plans()
+	if err != nil {
+		return nil, err
+	}
+	for _, plan := range plans {
+		if plan.Plan == code {
+			return &plan, nil
+		}
+	}
+	return nil, nil
+}
+
+// Filter returns the plans on the network, or all plans if network is empty
+func (c *PlanCatalogue) Filter(network string) ([]DataPlan, error) {
+	plans, err := c.Plans()
+	if err != nil {
+		return nil, err
+	}
+	if network == "" {
+		return plans, nil
+	}
+
+	filtered := []DataPlan{}
+	for _, plan := range plans {
+		if plan.Network == network {
+			filtered = append(filtered, plan)
+		}
+	}
+	return filtered, nil
+}
+
+var (
+	planSizePattern     = regexp.MustCompile(`(?i)(\d+(?:\.\d+)?)\s*(TB|GB|MB)`)
+	planValidityPattern = regexp.MustCompile(`(?i)(\d+)\s*-?\s*(hours?|hrs?|days?|weeks?|months?)`)
+)
+
+// normaliseDataPlan parses the network, size and validity out of a provider plan and applies our markup
+func normaliseDataPlan(plan VASPlan) DataPlan {
+	text := plan.Plan + " " + plan.Description
+	dataPlan := DataPlan{
+		Plan:        plan.Plan,
+		Description: plan.Description,
+		Network:     msisdn.ParseNetwork(plan.Network),
+		Cost:        plan.Amount,
+		Amount:      planPrice(plan.Amount),
+	}
+	if dataPlan.Network == "" {
+		dataPlan.Network = networkFromText(text)
+	}
+
+	if match := planSizePattern.FindStringSubmatch(plan.Description); match != nil {
+		dataPlan.Size, dataPlan.SizeMB = planSize(match)
+	} else if match := planSizePattern.FindStringSubmatch(plan.Plan); match != nil {
+		dataPlan.Size, dataPlan.SizeMB = planSize(match)
+	}
+
+	dataPlan.Validity, dataPlan.ValidityDays = planValidity(text)
+	return dataPlan
+}
+
+// networkFromText finds the network named in a plan code or description
+func networkFromText(text string) string {
+	text = strings.ToLower(text)
+	for _, network := range []string{"9mobile", "etisalat", "airtel", "glo", "mtn"} {
+		if strings.Contains(text, network) {
+			return msisdn.ParseNetwork(network)
+		}
+	}
+	return ""
+}
+
+// planSize formats a matched data allowance and converts it to megabytes
+func planSize(match []string) (string, float64) {
+	value, _ := strconv.ParseFloat(match[1], 64)
+	unit := strings.ToUpper(match[2])
+
+	sizeMB := value
+	switch unit {
+	case "GB":
+		sizeMB = value * 1024
+	case "TB":
+		sizeMB = value * 1024 * 1024
+	}
+	return match[1] + unit, sizeMB
+}
+
+// planValidity formats the validity period of a plan and converts it to days
+func planValidity(text string) (string, int) {
+	if match := planValidityPattern.FindStringSubmatch(text); match != nil {
+		value, _ := strconv.Atoi(match[1])
+		unit := strings.ToLower(match[2])
+
+		switch {
+		case strings.HasPrefix(unit, "h"):
+			days := int(math.Ceil(float64(value) / 24))
+			return fmt.Sprintf("%d hours", value), days
+		case strings.HasPrefix(unit, "w"):
+			return pluralise(value, "week"), value * 7
+		case strings.HasPrefix(unit, "m"):
+			return pluralise(value, "month"), value * 30
+		}
+		return pluralise(value, "day"), value
+	}
+
+	lower := strings.ToLower(text)
+	switch {
+	case strings.Contains(lower, "daily"):
+		return "1 day", 1
+	case strings.Contains(lower, "weekly"):
+		return "1 week", 7
+	case strings.Contains(lower, "monthly"):
+		return "1 month", 30
+	}
+	return "", 0
+}
+
+func pluralise(value int, unit string) string {
+	if value == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", value, unit)
+}
+
+// planPrice adds our markup to a provider's price. DATA_PLAN_MARKUP_PERCENT and DATA_PLAN_MARKUP_FLAT
+// configure the markup and the price is rounded up to the nearest naira.
+func planPrice(cost float64) float64 {
+	percent, _ := strconv.ParseFloat(os.Getenv("DATA_PLAN_MARKUP_PERCENT"), 64)
+	flat, _ := strconv.ParseFloat(os.Getenv("DATA_PLAN_MARKUP_FLAT"), 64)
+	if percent <= 0 && flat <= 0 {
+		return cost
+	}
+	return math.Ceil(cost + cost*percent/100 + flat)
+}
+
+// planCacheTTL returns how long fetched plans are served before being refreshed
+func planCacheTTL() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("DATA_PLAN_CACHE_TTL_SECONDS"))
+	if err != nil || seconds <= 0 {
+		return defaultPlanCacheTTL
+	}
+	return time.Duration(seconds) * time.Second
+}
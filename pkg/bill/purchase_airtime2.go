@@ -1,18 +1,20 @@
 package bill
 
 import (
-	"bytes"
+	"errors"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"net/smtp"
-	"os"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"go_code/database"
+	"go_code/pkg/fee"
+	"go_code/pkg/kyc"
+	"go_code/pkg/ledger"
+	"go_code/pkg/msisdn"
+	"go_code/pkg/reversal"
+	"go_code/pkg/transaction"
 )
 
 // AirtimePurchaseRequest represents the request payload for purchasing airtime
@@ -20,16 +22,20 @@ type AirtimePurchaseRequest struct {
 	Amount      string `json:"amount"`
 	Destination string `json:"destination"`
 	UserID      int    `json:"user_id"`
+	// BeneficiaryID optionally selects a saved phone beneficiary instead of Destination
+	BeneficiaryID int64 `json:"beneficiary_id,omitempty"`
 }
 
 // AirtimePurchaseResponse represents the response payload for a successful airtime purchase
 type AirtimePurchaseResponse struct {
 	Entity struct {
-		Data []struct {
-			Destination string `json:"destination"`
-			Status      string `json:"status"`
-		} `json:"data"`
-		ReferenceID string `json:"reference_id"`
+		Data         []vasDestinationStatus `json:"data"`
+		ReferenceID  string                 `json:"reference_id"`
+		Reference    string                 `json:"reference"`
+		Status       string                 `json:"status"`
+		Provider     string                 `json:"provider"`
+		Fee          float64 `json:"fee"`
+		TotalDebited float64 `json:"total_debited"`
 	} `json:"entity"`
 }
 
@@ -45,12 +51,36 @@ type Response struct {
 	Result  interface{} `json:"result,omitempty"`
 }
 
-// BalanceResponse represents the response payload for the Dojah balance
-type BalanceResponse struct {
-	Entity struct {
-		WalletBalance string `json:"wallet_balance"`
-	} `json:"entity"`
-	Error string `json:"error"`
+// PurchaseError represents a failed purchase together with the HTTP status to report it with
+type PurchaseError struct {
+	StatusCode int
+	Message    string
+	Result     interface{}
+	Err        error
+}
+
+func (e *PurchaseError) Error() string {
+	return e.Message
+}
+
+func (e *PurchaseError) Unwrap() error {
+	return e.Err
+}
+
+// respondPurchaseError writes a purchase failure as a JSON error response
+func respondPurchaseError(c *gin.Context, err error) {
+	if purchaseErr, ok := err.(*PurchaseError); ok {
+		c.JSON(purchaseErr.StatusCode, Response{
+			Status:  "error",
+			Message: purchaseErr.Message,
+			Result:  purchaseErr.Result,
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, Response{
+		Status:  "error",
+		Message: err.Error(),
+	})
 }
 
 // AirtimePurchaseHandler handles the airtime purchase process
@@ -64,248 +94,209 @@ func AirtimePurchaseHandler(c *gin.Context) {
 		return
 	}
 
-	// Step 1: Validate the amount
-	amount, err := strconv.ParseFloat(purchaseRequest.Amount, 64)
+	purchaseResponse, err := PurchaseAirtime(purchaseRequest)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, Response{
-			Status:  "error",
-			Message: "Invalid amount format: " + err.Error(),
-		})
+		respondPurchaseError(c, err)
 		return
 	}
 
-	// Step 2: Check the user's balance
-	db, err := database.PostgreSQLConnect()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to connect to database: " + err.Error(),
-		})
-		return
+	message := "Airtime purchase successful"
+	if purchaseResponse.Entity.Status == VASStatusPending {
+		message = "Airtime purchase is processing"
 	}
-	defer db.Close(context.Background())
 
-	var currentBalance float64
-	err = db.QueryRow(context.Background(), "SELECT current_balance FROM wallet WHERE user_id = $1", purchaseRequest.UserID).Scan(&currentBalance)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to retrieve balance: " + err.Error(),
-		})
-		return
-	}
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: message,
+		Result:  purchaseResponse.Entity,
+	})
+}
 
-	if currentBalance < amount {
-		c.JSON(http.StatusBadRequest, Response{
-			Status:  "error",
-			Message: "Insufficient balance.",
-			Result:  map[string]float64{"current_balance": currentBalance},
-		})
-		return
+// PurchaseAirtime checks the user's balance and the providers' float, debits the wallet and buys the airtime.
+// The purchase fails over between providers and the debit is reversed if none delivers.
+func PurchaseAirtime(purchaseRequest AirtimePurchaseRequest) (*AirtimePurchaseResponse, error) {
+	// Use the saved phone beneficiary as the destination if one was selected
+	if purchaseRequest.BeneficiaryID != 0 {
+		destination, err := beneficiaryDestination(purchaseRequest.UserID, purchaseRequest.BeneficiaryID)
+		if err != nil {
+			return nil, &PurchaseError{StatusCode: http.StatusBadRequest, Message: err.Error(), Err: err}
+		}
+		purchaseRequest.Destination = destination
 	}
 
-	// Step 3: Check the Dojah balance
-	dojahBalance, err := CheckDojahBalance()
+	// Normalise the destination and make sure it is on a known network
+	destination, err := detectDestination(purchaseRequest.Destination)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to check Dojah balance: " + err.Error(),
-		})
-		return
+		return nil, err
 	}
+	purchaseRequest.Destination = destination.Local
 
-	dojahBalanceFloat, err := strconv.ParseFloat(dojahBalance, 64)
+	// Step 1: Validate the amount
+	amount, err := strconv.ParseFloat(purchaseRequest.Amount, 64)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to parse Dojah balance: " + err.Error(),
-		})
-		return
-	}
-
-	if dojahBalanceFloat < amount {
-		SendInsufficientBalanceEmail()
-		c.JSON(http.StatusServiceUnavailable, Response{
-			Status:  "error",
-			Message: "Insufficient balance in our vault, please try again later.",
-		})
-		return
+		return nil, &PurchaseError{StatusCode: http.StatusBadRequest, Message: "Invalid amount format: " + err.Error(), Err: err}
 	}
 
-	// Step 4: Proceed with airtime purchase
-	payloadJSON, err := json.Marshal(purchaseRequest)
+	// Step 2: Check the user's balance
+	quote, err := fee.Calculate(purchaseRequest.UserID, fee.ProductAirtime, amount)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to marshal JSON: " + err.Error(),
-		})
-		return
+		return nil, &PurchaseError{StatusCode: http.StatusInternalServerError, Message: "Failed to calculate fee: " + err.Error(), Err: err}
 	}
-
-	url := "https://api.dojah.io/api/v1/purchase/airtime"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadJSON))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to create request: " + err.Error(),
-		})
-		return
+	if err := checkKYCLimits(purchaseRequest.UserID, amount); err != nil {
+		return nil, err
+	}
+	if err := checkWalletBalance(purchaseRequest.UserID, quote.Total); err != nil {
+		return nil, err
 	}
 
-	req.Header.Set("AppId", os.Getenv("DOJAH_APP_ID"))
-	req.Header.Set("Authorization", os.Getenv("DOJAH_SECRET_KEY"))
-	req.Header.Set("accept", "application/json")
-	req.Header.Set("content-type", "application/json")
+	// Step 3: Check that a provider has enough float
+	if err := vasRouter.CheckFloat(amount); err != nil {
+		return nil, err
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	// Step 4: Debit the wallet and the fee before calling the provider
+	reference := ledger.NewReference("AIR")
+	transactionID, err := debitPurchase(purchaseRequest.UserID, amount, quote, reference, "Airtime purchase")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to send request: " + err.Error(),
-		})
-		return
+		return nil, debitError(err)
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	// Step 5: Proceed with airtime purchase, failing over between providers and reversing the debit if none delivers
+	purchase, err := vasRouter.PurchaseAirtime(purchaseRequest.Destination, amount, reference)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to read response: " + err.Error(),
-		})
-		return
+		return nil, reversePurchase(transactionID, vasPurchaseError(err))
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		var errorResponse ErrorResponse
-		if err := json.Unmarshal(body, &errorResponse); err != nil {
-			c.JSON(http.StatusInternalServerError, Response{
-				Status:  "error",
-				Message: "Failed to parse error response: " + err.Error(),
-				Result:  json.RawMessage(body),
-			})
-			return
-		}
-		c.JSON(http.StatusBadRequest, Response{
-			Status:  "error",
-			Message: errorResponse.Error,
-		})
-		return
+	// Step 6: Link the provider's reference to the wallet debit and record the purchase status
+	if err := linkProviderReference(transactionID, purchase.ProviderReference); err != nil {
+		fmt.Println("Failed to save transaction data:", err)
+	}
+	if err := saveVASPurchase(purchaseRequest.UserID, transactionID, reference, fee.ProductAirtime, "", amount, quote, purchase); err != nil {
+		fmt.Println("Failed to save purchase:", err)
 	}
 
 	var purchaseResponse AirtimePurchaseResponse
-	if err := json.Unmarshal(body, &purchaseResponse); err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to parse success response: " + err.Error(),
-			Result:  json.RawMessage(body),
-		})
-		return
-	}
+	purchaseResponse.Entity.Data = append(purchaseResponse.Entity.Data, vasDestinationStatus{
+		Destination: purchase.Destination,
+		Status:      purchase.Status,
+	})
+	purchaseResponse.Entity.ReferenceID = purchase.ProviderReference
+	purchaseResponse.Entity.Reference = reference
+	purchaseResponse.Entity.Status = purchase.Status
+	purchaseResponse.Entity.Provider = purchase.Provider
+	purchaseResponse.Entity.Fee = quote.Fee
+	purchaseResponse.Entity.TotalDebited = quote.Total
+
+	return &purchaseResponse, nil
+}
 
-	// Step 5: Update the user transaction database
-	if err := SaveTransactionData(purchaseRequest.UserID, purchaseResponse.Entity.ReferenceID, amount, "debit"); err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to save transaction data: " + err.Error(),
-		})
-		return
+// vasPurchaseError converts a failed provider purchase into the error reported to the user
+func vasPurchaseError(err error) *PurchaseError {
+	if errors.Is(err, errNoProviderFloat) {
+		return &PurchaseError{StatusCode: http.StatusServiceUnavailable, Message: "Insufficient balance in our vault, please try again later.", Err: err}
 	}
-	
 
-	// Step 6: Update balance
-	if err := UpdateBalanceforAirtime(purchaseRequest.UserID, amount); err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to update balance: " + err.Error(),
-		})
-		return
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) && providerErr.Retryable {
+		return &PurchaseError{StatusCode: http.StatusBadRequest, Message: providerErr.Err.Error(), Err: err}
 	}
-
-	c.JSON(http.StatusOK, Response{
-		Status:  "success",
-		Message: "Airtime purchase successful",
-		Result:  purchaseResponse.Entity,
-	})
+	return &PurchaseError{StatusCode: http.StatusInternalServerError, Message: err.Error(), Err: err}
 }
 
-func CheckDojahBalance() (string, error) {
-	url := "https://api.dojah.io/api/v1/balance"
-	req, err := http.NewRequest("GET", url, nil)
+// debitPurchase checks the KYC limits and debits the purchase amount and its fee from the wallet in a single
+// database transaction. It returns the id of the debit so it can be reversed if the provider does not deliver.
+func debitPurchase(userID int, amount float64, quote *fee.Quote, reference, narration string) (int64, error) {
+	db, err := database.PostgreSQLConnect()
 	if err != nil {
-		return "", err
+		return 0, err
 	}
+	defer db.Close(context.Background())
 
-	req.Header.Set("AppId", os.Getenv("DOJAH_APP_ID"))
-	req.Header.Set("Authorization", os.Getenv("DOJAH_SECRET_KEY"))
-	req.Header.Set("accept", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	tx, err := db.Begin(context.Background())
 	if err != nil {
-		return "", err
+		return 0, err
 	}
-	defer resp.Body.Close()
+	defer tx.Rollback(context.Background())
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+	// Limits are checked again under the wallet lock so concurrent purchases cannot together exceed them
+	if err := kyc.CheckDebitTx(tx, userID, amount); err != nil {
+		return 0, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to retrieve balance: %s", string(body))
+	transactionID, err := ledger.Debit(tx, ledger.Entry{
+		UserID:    userID,
+		Amount:    amount,
+		Reference: reference,
+		Narration: narration,
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	var balanceResponse BalanceResponse
-	if err := json.Unmarshal(body, &balanceResponse); err != nil {
-		return "", err
+	if _, err := fee.Charge(tx, userID, quote, reference); err != nil {
+		return 0, err
 	}
 
-	if balanceResponse.Error != "" {
-		return "", fmt.Errorf("error from Dojah: %s", balanceResponse.Error)
+	if err := tx.Commit(context.Background()); err != nil {
+		return 0, err
 	}
 
-	return balanceResponse.Entity.WalletBalance, nil
+	return transactionID, nil
 }
 
-func SendInsufficientBalanceEmail() {
-	from := os.Getenv("SMTP_FROM")
-	pass := os.Getenv("SMTP_PASS")
-	to := os.Getenv("ADMIN_EMAIL")
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
-
-	msg := "Subject: Insufficient Balance Alert\n\nThe balance in the Dojah wallet is insufficient to process transactions. Please top up the wallet."
+// debitError converts a failed wallet debit into the error reported to the user
+func debitError(err error) error {
+	if err == ledger.ErrInsufficientBalance {
+		return &PurchaseError{StatusCode: http.StatusBadRequest, Message: "Insufficient balance.", Err: err}
+	}
+	var limitErr *kyc.LimitError
+	if errors.As(err, &limitErr) {
+		return &PurchaseError{StatusCode: http.StatusForbidden, Message: limitErr.Error(), Result: limitErr, Err: err}
+	}
+	return &PurchaseError{StatusCode: http.StatusInternalServerError, Message: "Failed to debit wallet: " + err.Error(), Err: err}
+}
 
-	auth := smtp.PlainAuth("", from, pass, smtpHost)
-	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{to}, []byte(msg))
-	if err != nil {
-		// Log the error
-		fmt.Println("Failed to send email:", err)
+// reversePurchase refunds the wallet debit of a purchase the provider failed and returns the purchase error
+func reversePurchase(transactionID int64, purchaseErr *PurchaseError) error {
+	if _, err := reversal.Reverse(transactionID, "Provider failure: "+purchaseErr.Message, reversal.InitiatedBySystem); err != nil {
+		fmt.Println("Failed to reverse purchase:", err)
 	}
+	return purchaseErr
 }
 
-func SaveTransactionData(userID int, referenceID string, amount float64, transactionType string) error {
+// linkProviderReference stores the provider's reference on the wallet debit of a purchase
+func linkProviderReference(transactionID int64, providerReference string) error {
 	db, err := database.PostgreSQLConnect()
 	if err != nil {
 		return err
 	}
 	defer db.Close(context.Background())
 
-	query := `INSERT INTO user_transaction (user_id, reference, amount, transaction_type, narration) VALUES ($1, $2, $3, $4, $5)`
-	_, err = db.Exec(context.Background(), query, userID, referenceID, amount, transactionType, "Airtime purchase")
+	_, err = db.Exec(context.Background(),
+		"UPDATE user_transaction SET reference_id = $1 WHERE transaction_id = $2", providerReference, transactionID)
 	return err
 }
 
-func UpdateBalanceforAirtime(userID int, amount float64) error {
-	db, err := database.PostgreSQLConnect()
+// beneficiaryDestination returns the phone number saved on a user's phone beneficiary
+func beneficiaryDestination(userID int, beneficiaryID int64) (string, error) {
+	beneficiary, err := transaction.FetchBeneficiary(userID, beneficiaryID)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("Failed to fetch beneficiary: %w", err)
 	}
-	defer db.Close(context.Background())
+	if beneficiary.Type != transaction.BeneficiaryTypePhone {
+		return "", fmt.Errorf("Beneficiary is not a phone number")
+	}
+	return beneficiary.Phone, nil
+}
 
-	query := `UPDATE wallet SET previous_balance = current_balance, current_balance = current_balance - $1 WHERE user_id = $2`
-	_, err = db.Exec(context.Background(), query, amount, userID)
-	return err
-}
\ No newline at end of file
+// detectDestination normalises a destination phone number and detects its network
+func detectDestination(destination string) (*msisdn.Lookup, error) {
+	lookup, err := msisdn.Detect(destination)
+	if err == msisdn.ErrInvalidNumber || err == msisdn.ErrUnknownNetwork {
+		return nil, &PurchaseError{StatusCode: http.StatusBadRequest, Message: "Invalid destination: " + err.Error(), Err: err}
+	}
+	if err != nil {
+		return nil, &PurchaseError{StatusCode: http.StatusInternalServerError, Message: "Failed to detect network: " + err.Error(), Err: err}
+	}
+	return lookup, nil
+}
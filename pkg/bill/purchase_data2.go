@@ -1,18 +1,15 @@
 package bill
 
 import (
-	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/smtp"
 	"os"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"go_code/database"
+	"go_code/pkg/fee"
+	"go_code/pkg/ledger"
 )
 
 // DataPurchaseRequest represents the request payload for purchasing data
@@ -20,6 +17,8 @@ type DataPurchaseRequest struct {
 	Destination string `json:"destination"`
 	Plan        string `json:"plan"`
 	UserID      int    `json:"user_id"`
+	// BeneficiaryID optionally selects a saved phone beneficiary instead of Destination
+	BeneficiaryID int64 `json:"beneficiary_id,omitempty"`
 }
 
 // DataPurchaseResponse represents the response payload for a successful data purchase
@@ -27,11 +26,13 @@ type DataPurchaseResponse struct {
 	Status  string `json:"status"`
 	Message string `json:"message"`
 	Result  struct {
-		Data []struct {
-			Destination string `json:"destination"`
-			Status      string `json:"status"`
-		} `json:"data"`
-		ReferenceID string `json:"reference_id"`
+		Data         []vasDestinationStatus `json:"data"`
+		ReferenceID  string                 `json:"reference_id"`
+		Reference    string                 `json:"reference"`
+		Status       string                 `json:"status"`
+		Provider     string                 `json:"provider"`
+		Fee          float64 `json:"fee"`
+		TotalDebited float64 `json:"total_debited"`
 	} `json:"result"`
 }
 
@@ -48,14 +49,6 @@ type Data2Response struct {
 	Result  interface{} `json:"result,omitempty"`
 }
 
-// // BalanceResponse represents the response payload for the Dojah balance
-type Data2BalanceResponse struct {
-	Entity struct {
-		WalletBalance string `json:"wallet_balance"`
-	} `json:"entity"`
-	Error string `json:"error"`
-}
-
 // DataPlansResponse represents the response payload for available data plans
 type DataPlansResponse struct {
 	Entity []struct {
@@ -76,188 +69,110 @@ func DataPurchaseHandler(c *gin.Context) {
 		return
 	}
 
-	// Step 1: Fetch available data plans
-	dataPlans, err := fetchDataPlans()
+	purchaseResponse, err := PurchaseData(purchaseRequest)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to fetch data plans: " + err.Error(),
-		})
+		respondPurchaseError(c, err)
 		return
 	}
 
-	// Step 2: Check if the requested plan is available
-	var planCost float64
-	planFound := false
-	for _, plan := range dataPlans.Entity {
-		if plan.Plan == purchaseRequest.Plan {
-			planCost = float64(plan.Amount)
-			planFound = true
-			break
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: purchaseResponse.Message,
+		Result:  purchaseResponse.Result,
+	})
+}
+
+// PurchaseData checks the plan and balances, debits the wallet and buys the data bundle.
+// The purchase fails over between providers and the debit is reversed if none delivers.
+func PurchaseData(purchaseRequest DataPurchaseRequest) (*DataPurchaseResponse, error) {
+	// Use the saved phone beneficiary as the destination if one was selected
+	if purchaseRequest.BeneficiaryID != 0 {
+		destination, err := beneficiaryDestination(purchaseRequest.UserID, purchaseRequest.BeneficiaryID)
+		if err != nil {
+			return nil, &PurchaseError{StatusCode: http.StatusBadRequest, Message: err.Error(), Err: err}
 		}
+		purchaseRequest.Destination = destination
 	}
 
-	if !planFound {
-		c.JSON(http.StatusBadRequest, Response{
-			Status:  "error",
-			Message: "Invalid plan selected",
-		})
-		return
+	// Step 1: Look up the plan in the catalogue
+	selectedPlan, err := planCatalogue.Find(purchaseRequest.Plan)
+	if err != nil {
+		return nil, &PurchaseError{StatusCode: http.StatusInternalServerError, Message: "Failed to fetch data plans: " + err.Error(), Err: err}
 	}
 
-	// Step 3: Check the user's balance
-	db, err := database.PostgreSQLConnect()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to connect to database: " + err.Error(),
-		})
-		return
+	// Step 2: Check if the requested plan is available
+	if selectedPlan == nil {
+		return nil, &PurchaseError{StatusCode: http.StatusBadRequest, Message: "Invalid plan selected"}
 	}
-	defer db.Close(context.Background())
 
-	var currentBalance float64
-	err = db.QueryRow(context.Background(), "SELECT current_balance FROM wallet WHERE user_id = $1", purchaseRequest.UserID).Scan(&currentBalance)
+	// Step 3: Check that the plan is for the destination's network
+	destination, err := detectDestination(purchaseRequest.Destination)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to retrieve balance: " + err.Error(),
-		})
-		return
+		return nil, err
 	}
-
-	if currentBalance < planCost {
-		c.JSON(http.StatusBadRequest, Response{
-			Status:  "error",
-			Message: "Insufficient balance.",
-			Result:  map[string]float64{"current_balance": currentBalance},
-		})
-		return
+	if selectedPlan.Network != "" && selectedPlan.Network != destination.Network {
+		return nil, &PurchaseError{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("Plan %s is for %s but %s is on %s", selectedPlan.Plan, selectedPlan.Network, destination.Local, destination.Network)}
 	}
+	purchaseRequest.Destination = destination.Local
+	planCost := selectedPlan.Amount
 
-	// Step 4: Check the Dojah balance
-	dojahBalance, err := checkDojahBalance()
+	// Step 4: Check the user's balance
+	quote, err := fee.Calculate(purchaseRequest.UserID, fee.ProductData, planCost)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to check Dojah balance: " + err.Error(),
-		})
-		return
+		return nil, &PurchaseError{StatusCode: http.StatusInternalServerError, Message: "Failed to calculate fee: " + err.Error(), Err: err}
 	}
-
-	dojahBalanceFloat, err := strconv.ParseFloat(dojahBalance, 64)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to parse Dojah balance: " + err.Error(),
-		})
-		return
+	if err := checkKYCLimits(purchaseRequest.UserID, planCost); err != nil {
+		return nil, err
 	}
-
-	if dojahBalanceFloat < planCost {
-		sendInsufficientBalanceEmail()
-		c.JSON(http.StatusServiceUnavailable, Response{
-			Status:  "error",
-			Message: "Insufficient balance in our vault, please try again later.",
-		})
-		return
+	if err := checkWalletBalance(purchaseRequest.UserID, quote.Total); err != nil {
+		return nil, err
 	}
 
-	// Step 5: Proceed with data purchase
-	payloadJSON, err := json.Marshal(purchaseRequest)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to marshal JSON: " + err.Error(),
-		})
-		return
+	// Step 5: Check that a provider has enough float
+	if err := vasRouter.CheckFloat(selectedPlan.Cost); err != nil {
+		return nil, err
 	}
 
-	url := "https://api.dojah.io/api/v1/purchase/data"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadJSON))
+	// Step 6: Debit the wallet and the fee before calling the provider
+	reference := ledger.NewReference("DAT")
+	transactionID, err := debitPurchase(purchaseRequest.UserID, planCost, quote, reference, "Data purchase")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to create request: " + err.Error(),
-		})
-		return
+		return nil, debitError(err)
 	}
 
-	req.Header.Set("AppId", os.Getenv("DOJAH_APP_ID"))
-	req.Header.Set("Authorization", os.Getenv("DOJAH_SECRET_KEY"))
-	req.Header.Set("accept", "application/json")
-	req.Header.Set("content-type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	// Step 7: Proceed with data purchase, failing over between providers and reversing the debit if none delivers
+	purchase, err := vasRouter.PurchaseData(purchaseRequest.Destination, selectedPlan.vasPlan(), reference)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to send request: " + err.Error(),
-		})
-		return
+		return nil, reversePurchase(transactionID, vasPurchaseError(err))
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to read response: " + err.Error(),
-		})
-		return
+	// Step 8: Link the provider's reference to the wallet debit and record the purchase status
+	if err := linkProviderReference(transactionID, purchase.ProviderReference); err != nil {
+		fmt.Println("Failed to save transaction data:", err)
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		var errorResponse ErrorResponsee
-		if err := json.Unmarshal(body, &errorResponse); err != nil {
-			c.JSON(http.StatusInternalServerError, Response{
-				Status:  "error",
-				Message: "Failed to parse error response: " + err.Error(),
-				Result:  json.RawMessage(body),
-			})
-			return
-		}
-		c.JSON(http.StatusBadRequest, Response{
-			Status:  "error",
-			Message: errorResponse.Message,
-		})
-		return
-	}
-
-	var purchaseResponse DataPurchaseResponse
-	if err := json.Unmarshal(body, &purchaseResponse); err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to parse success response: " + err.Error(),
-			Result:  json.RawMessage(body),
-		})
-		return
-	}
-
-	// Step 6: Update the user transaction database
-	if err := SaveTransactionDataforData(purchaseRequest.UserID, purchaseResponse.Result.ReferenceID, planCost, "debit"); err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to save transaction data: " + err.Error(),
-		})
-		return
-	}
-
-	// Step 7: Update balance
-	if err := UpdateBalance(purchaseRequest.UserID, planCost); err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to update balance: " + err.Error(),
-		})
-		return
+	if err := saveVASPurchase(purchaseRequest.UserID, transactionID, reference, fee.ProductData, selectedPlan.Plan, planCost, quote, purchase); err != nil {
+		fmt.Println("Failed to save purchase:", err)
 	}
 
-	c.JSON(http.StatusOK, Response{
+	purchaseResponse := DataPurchaseResponse{
 		Status:  "success",
 		Message: "Data purchase successful",
-		Result:  purchaseResponse.Result,
+	}
+	purchaseResponse.Result.Data = append(purchaseResponse.Result.Data, vasDestinationStatus{
+		Destination: purchase.Destination,
+		Status:      purchase.Status,
 	})
+	purchaseResponse.Result.ReferenceID = purchase.ProviderReference
+	purchaseResponse.Result.Reference = reference
+	purchaseResponse.Result.Status = purchase.Status
+	if purchase.Status == VASStatusPending {
+		purchaseResponse.Message = "Data purchase is processing"
+	}
+	purchaseResponse.Result.Provider = purchase.Provider
+	purchaseResponse.Result.Fee = quote.Fee
+	purchaseResponse.Result.TotalDebited = quote.Total
+
+	return &purchaseResponse, nil
 }
 
 func fetchDataPlans() (*DataPlansResponse, error) {
@@ -294,83 +209,3 @@ func fetchDataPlans() (*DataPlansResponse, error) {
 
 	return &dataPlansResponse, nil
 }
-
-func checkDojahBalance() (string, error) {
-	url := "https://api.dojah.io/api/v1/balance"
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return "", err
-	}
-
-	req.Header.Set("AppId", os.Getenv("DOJAH_APP_ID"))
-	req.Header.Set("Authorization", os.Getenv("DOJAH_SECRET_KEY"))
-	req.Header.Set("accept", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to retrieve balance: %s", string(body))
-	}
-
-	var balanceResponse Data2BalanceResponse
-	if err := json.Unmarshal(body, &balanceResponse); err != nil {
-		return "", err
-	}
-
-	if balanceResponse.Error != "" {
-		return "", fmt.Errorf("error from Dojah: %s", balanceResponse.Error)
-	}
-
-	return balanceResponse.Entity.WalletBalance, nil
-}
-
-func sendInsufficientBalanceEmail() {
-	from := os.Getenv("SMTP_FROM")
-	pass := os.Getenv("SMTP_PASS")
-	to := os.Getenv("ADMIN_EMAIL")
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
-
-	msg := "Subject: Insufficient Balance Alert\n\nThe balance in the Dojah wallet is insufficient to process transactions. Please top up the wallet."
-
-	auth := smtp.PlainAuth("", from, pass, smtpHost)
-	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{to}, []byte(msg))
-	if err != nil {
-		// Log the error
-		fmt.Println("Failed to send email:", err)
-	}
-}
-
-func SaveTransactionDataforData(userID int, referenceID string, planCost float64, transactionType string) error {
-	db, err := database.PostgreSQLConnect()
-	if err != nil {
-		return err
-	}
-	defer db.Close(context.Background())
-
-	query := `INSERT INTO user_transaction (user_id, reference_id, amount, transaction_type, narration) VALUES ($1, $2, $3, $4, $5)`
-	_, err = db.Exec(context.Background(), query, userID, referenceID, planCost, transactionType, "Data purchase")
-	return err
-}
-
-func UpdateBalance(userID int, planCost float64) error {
-	db, err := database.PostgreSQLConnect()
-	if err != nil {
-		return err
-	}
-	defer db.Close(context.Background())
-
-	query := `UPDATE wallet SET previous_balance = current_balance, current_balance = current_balance - $1 WHERE user_id = $2`
-	_, err = db.Exec(context.Background(), query, planCost, userID)
-	return err
-}
\ No newline at end of file
@@ -0,0 +1,227 @@
+package bill
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go_code/pkg/msisdn"
+)
+
+// aggregatorVASProvider buys airtime and data through the bills aggregator
+type aggregatorVASProvider struct{}
+
+// AggregatorRequeryResponse represents the response from the aggregator for a transaction requery
+type AggregatorRequeryResponse struct {
+	Code                string `json:"code"`
+	ResponseDescription string `json:"response_description"`
+	RequestID           string `json:"requestId"`
+	Content             struct {
+		Transactions struct {
+			Status        string `json:"status"`
+			UniqueElement string `json:"unique_element"`
+		} `json:"transactions"`
+	} `json:"content"`
+}
+
+func (p *aggregatorVASProvider) Name() string {
+	return "aggregator"
+}
+
+func (p *aggregatorVASProvider) Balance() (float64, error) {
+	body, err := aggregatorRequest("GET", "/balance", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var balanceResponse AggregatorBalanceResponse
+	if err := json.Unmarshal(body, &balanceResponse); err != nil {
+		return 0, fmt.Errorf("failed to parse balance: %w", err)
+	}
+
+	return balanceResponse.Contents.Balance, nil
+}
+
+func (p *aggregatorVASProvider) DataPlans() ([]VASPlan, error) {
+	var plans []VASPlan
+	for _, network := range []string{"mtn", "airtel", "glo", "etisalat"} {
+		networkPlans, err := p.networkPlans(network)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, networkPlans...)
+	}
+	return plans, nil
+}
+
+func (p *aggregatorVASProvider) PurchaseAirtime(destination string, amount float64, reference string) (*VASPurchase, error) {
+	network, err := aggregatorNetwork(destination)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.Name(), Retryable: true, Err: err}
+	}
+
+	return p.pay(map[string]interface{}{
+		"request_id": reference,
+		"serviceID":  network,
+		"amount":     amount,
+		"phone":      destination,
+	}, destination, reference)
+}
+
+// PurchaseData buys the aggregator's plan with the same code or, failing that, the same size and validity
+// on the destination's network. An equivalent plan is never bought for more than the chosen plan costs.
+func (p *aggregatorVASProvider) PurchaseData(destination string, plan VASPlan, reference string) (*VASPurchase, error) {
+	network, err := aggregatorNetwork(destination)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.Name(), Retryable: true, Err: err}
+	}
+
+	networkPlans, err := p.networkPlans(network)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.Name(), Retryable: true, Err: err}
+	}
+
+	match := equivalentPlan(plan, networkPlans)
+	if match == nil {
+		return nil, &ProviderError{Provider: p.Name(), Retryable: true, Err: fmt.Errorf("no equivalent of plan %s", plan.Plan)}
+	}
+
+	return p.pay(map[string]interface{}{
+		"request_id":     reference,
+		"serviceID":      network + "-data",
+		"billersCode":    destination,
+		"variation_code": match.Plan,
+		"amount":         match.Amount,
+		"phone":          destination,
+	}, destination, reference)
+}
+
+// QueryStatus requeries a purchase. The aggregator identifies purchases by our request ID.
+func (p *aggregatorVASProvider) QueryStatus(providerReference string) (*VASPurchase, error) {
+	body, err := aggregatorRequest("POST", "/requery", map[string]string{"request_id": providerReference})
+	if err != nil {
+		return nil, err
+	}
+
+	var requeryResponse AggregatorRequeryResponse
+	if err := json.Unmarshal(body, &requeryResponse); err != nil {
+		return nil, &ProviderError{Provider: p.Name(), Err: fmt.Errorf("failed to parse requery response: %w", err)}
+	}
+
+	return &VASPurchase{
+		Provider:          p.Name(),
+		ProviderReference: providerReference,
+		Destination:       requeryResponse.Content.Transactions.UniqueElement,
+		Status:            aggregatorStatus(requeryResponse.Code, requeryResponse.Content.Transactions.Status),
+	}, nil
+}
+
+// networkPlans returns the data plans the aggregator sells on a network
+func (p *aggregatorVASProvider) networkPlans(network string) ([]VASPlan, error) {
+	body, err := aggregatorRequest("GET", "/service-variations?serviceID="+network+"-data", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var variationsResponse AggregatorVariationsResponse
+	if err := json.Unmarshal(body, &variationsResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse variations: %w", err)
+	}
+
+	plans := make([]VASPlan, 0, len(variationsResponse.Content.Variations))
+	for _, v := range variationsResponse.Content.Variations {
+		amount, _ := strconv.ParseFloat(v.VariationAmount, 64)
+		plans = append(plans, VASPlan{
+			Plan:        v.VariationCode,
+			Description: v.Name,
+			Network:     network,
+			Amount:      amount,
+		})
+	}
+	return plans, nil
+}
+
+// pay sends a purchase to the aggregator
+func (p *aggregatorVASProvider) pay(payload map[string]interface{}, destination, reference string) (*VASPurchase, error) {
+	body, err := aggregatorRequest("POST", "/pay", payload)
+	if err != nil {
+		return pendingPurchase(err, destination, reference)
+	}
+
+	var payResponse AggregatorPayResponse
+	if err := json.Unmarshal(body, &payResponse); err != nil {
+		return pendingPurchase(&ProviderError{Provider: p.Name(), Err: fmt.Errorf("failed to parse payment response: %w", err)}, destination, reference)
+	}
+
+	status := aggregatorStatus(payResponse.Code, payResponse.Content.Transactions.Status)
+	if status == VASStatusFailed {
+		return nil, &ProviderError{Provider: p.Name(), Retryable: true, Err: errors.New(payResponse.ResponseDescription)}
+	}
+
+	return &VASPurchase{
+		ProviderReference: reference,
+		Destination:       destination,
+		Status:            status,
+	}, nil
+}
+
+// equivalentPlan returns the plan with the same code as the chosen one or, failing that, the cheapest plan
+// with the same size and validity that costs no more than the chosen plan
+func equivalentPlan(plan VASPlan, candidates []VASPlan) *VASPlan {
+	for i := range candidates {
+		if candidates[i].Plan == plan.Plan {
+			return &candidates[i]
+		}
+	}
+
+	chosen := normaliseDataPlan(plan)
+	if chosen.SizeMB <= 0 || chosen.ValidityDays <= 0 {
+		return nil
+	}
+
+	var match *VASPlan
+	for i := range candidates {
+		candidate := normaliseDataPlan(candidates[i])
+		if candidate.SizeMB != chosen.SizeMB || candidate.ValidityDays != chosen.ValidityDays {
+			continue
+		}
+		if chosen.Network != "" && candidate.Network != "" && candidate.Network != chosen.Network {
+			continue
+		}
+		if candidates[i].Amount > plan.Amount+0.005 {
+			continue
+		}
+		if match == nil || candidates[i].Amount < match.Amount {
+			match = &candidates[i]
+		}
+	}
+	return match
+}
+
+// aggregatorStatus maps the aggregator's response code and transaction status onto a VAS purchase status
+func aggregatorStatus(code, status string) string {
+	switch strings.ToLower(status) {
+	case "delivered":
+		return VASStatusSuccess
+	case "failed", "reversed":
+		return VASStatusFailed
+	}
+	if code != aggregatorSuccess && code != aggregatorProcessing {
+		return VASStatusFailed
+	}
+	return VASStatusPending
+}
+
+// aggregatorNetwork returns the aggregator's service ID for the destination's network
+func aggregatorNetwork(destination string) (string, error) {
+	lookup, err := msisdn.Detect(destination)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", destination, err)
+	}
+	if lookup.Network == msisdn.NineMobile {
+		return "etisalat", nil
+	}
+	return lookup.Network, nil
+}
@@ -0,0 +1,261 @@
+package bill
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"go_code/pkg/float"
+)
+
+// dojahProvider buys airtime and data through Dojah
+type dojahProvider struct{}
+
+// DojahStatusResponse represents the response from Dojah for a purchase status query
+type DojahStatusResponse struct {
+	Entity struct {
+		Status      string `json:"status"`
+		Destination string `json:"destination"`
+		ReferenceID string `json:"reference_id"`
+	} `json:"entity"`
+	Error string `json:"error"`
+}
+
+// DojahPurchase represents a purchase listed in Dojah's transaction history
+type DojahPurchase struct {
+	ReferenceID string  `json:"reference_id"`
+	Destination string  `json:"destination"`
+	Amount      float64 `json:"amount"`
+	Status      string  `json:"status"`
+}
+
+// DojahTransactionsResponse represents a page of Dojah's transaction history
+type DojahTransactionsResponse struct {
+	Entity struct {
+		Transactions []DojahPurchase `json:"transactions"`
+		TotalPages   int             `json:"total_pages"`
+	} `json:"entity"`
+	Error string `json:"error"`
+}
+
+func (p *dojahProvider) Name() string {
+	return "dojah"
+}
+
+func (p *dojahProvider) Balance() (float64, error) {
+	return float.DojahBalance()
+}
+
+func (p *dojahProvider) DataPlans() ([]VASPlan, error) {
+	dataPlans, err := fetchDataPlans()
+	if err != nil {
+		return nil, err
+	}
+
+	plans := make([]VASPlan, 0, len(dataPlans.Entity))
+	for _, plan := range dataPlans.Entity {
+		plans = append(plans, VASPlan{
+			Plan:        plan.Plan,
+			Description: plan.Description,
+			Amount:      float64(plan.Amount),
+		})
+	}
+	return plans, nil
+}
+
+func (p *dojahProvider) PurchaseAirtime(destination string, amount float64, reference string) (*VASPurchase, error) {
+	body, err := p.do("POST", "/api/v1/purchase/airtime", map[string]string{
+		"amount":      strconv.FormatFloat(amount, 'f', -1, 64),
+		"destination": destination,
+	}, func(body []byte) string {
+		var errorResponse ErrorResponse
+		json.Unmarshal(body, &errorResponse)
+		return errorResponse.Error
+	})
+	if err != nil {
+		return pendingPurchase(err, destination, "")
+	}
+
+	var purchaseResponse AirtimePurchaseResponse
+	if err := json.Unmarshal(body, &purchaseResponse); err != nil {
+		return pendingPurchase(&ProviderError{Provider: p.Name(), Err: fmt.Errorf("failed to parse success response: %w", err)}, destination, "")
+	}
+
+	purchase := &VASPurchase{
+		ProviderReference: purchaseResponse.Entity.ReferenceID,
+		Destination:       destination,
+		Status:            VASStatusSuccess,
+	}
+	if len(purchaseResponse.Entity.Data) > 0 {
+		purchase.Status = dojahStatus(purchaseResponse.Entity.Data[0].Status)
+	}
+	if purchase.Status == VASStatusFailed {
+		return nil, &ProviderError{Provider: p.Name(), Retryable: true, Err: errors.New("purchase declined for " + destination)}
+	}
+	return purchase, nil
+}
+
+func (p *dojahProvider) PurchaseData(destination string, plan VASPlan, reference string) (*VASPurchase, error) {
+	body, err := p.do("POST", "/api/v1/purchase/data", map[string]string{
+		"plan":        plan.Plan,
+		"destination": destination,
+	}, func(body []byte) string {
+		var errorResponse ErrorResponsee
+		json.Unmarshal(body, &errorResponse)
+		return errorResponse.Message
+	})
+	if err != nil {
+		return pendingPurchase(err, destination, "")
+	}
+
+	var purchaseResponse DataPurchaseResponse
+	if err := json.Unmarshal(body, &purchaseResponse); err != nil {
+		return pendingPurchase(&ProviderError{Provider: p.Name(), Err: fmt.Errorf("failed to parse success response: %w", err)}, destination, "")
+	}
+
+	purchase := &VASPurchase{
+		ProviderReference: purchaseResponse.Result.ReferenceID,
+		Destination:       destination,
+		Status:            VASStatusSuccess,
+	}
+	if len(purchaseResponse.Result.Data) > 0 {
+		purchase.Status = dojahStatus(purchaseResponse.Result.Data[0].Status)
+	}
+	if purchase.Status == VASStatusFailed {
+		return nil, &ProviderError{Provider: p.Name(), Retryable: true, Err: errors.New("purchase declined for " + destination)}
+	}
+	return purchase, nil
+}
+
+func (p *dojahProvider) QueryStatus(providerReference string) (*VASPurchase, error) {
+	body, err := p.do("GET", "/api/v1/purchase/status?reference_id="+providerReference, nil, func(body []byte) string {
+		var statusResponse DojahStatusResponse
+		json.Unmarshal(body, &statusResponse)
+		return statusResponse.Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var statusResponse DojahStatusResponse
+	if err := json.Unmarshal(body, &statusResponse); err != nil {
+		return nil, &ProviderError{Provider: p.Name(), Err: fmt.Errorf("failed to parse status response: %w", err)}
+	}
+
+	return &VASPurchase{
+		Provider:          p.Name(),
+		ProviderReference: providerReference,
+		Destination:       statusResponse.Entity.Destination,
+		Status:            dojahStatus(statusResponse.Entity.Status),
+	}, nil
+}
+
+// ListDojahPurchases pages through Dojah's transaction history for the period. Statuses are mapped
+// onto VAS purchase statuses.
+func ListDojahPurchases(from, to time.Time) ([]DojahPurchase, error) {
+	p := &dojahProvider{}
+	query := url.Values{}
+	query.Set("start_date", from.Format("2006-01-02"))
+	query.Set("end_date", to.Add(-time.Second).Format("2006-01-02"))
+
+	var purchases []DojahPurchase
+	for page := 1; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+		body, err := p.do("GET", "/api/v1/purchase/transactions?"+query.Encode(), nil, func(body []byte) string {
+			var transactionsResponse DojahTransactionsResponse
+			json.Unmarshal(body, &transactionsResponse)
+			return transactionsResponse.Error
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var transactionsResponse DojahTransactionsResponse
+		if err := json.Unmarshal(body, &transactionsResponse); err != nil {
+			return nil, &ProviderError{Provider: p.Name(), Err: fmt.Errorf("failed to parse transactions response: %w", err)}
+		}
+		for _, purchase := range transactionsResponse.Entity.Transactions {
+			purchase.Status = dojahStatus(purchase.Status)
+			purchases = append(purchases, purchase)
+		}
+
+		if len(transactionsResponse.Entity.Transactions) == 0 || transactionsResponse.Entity.TotalPages <= page {
+			return purchases, nil
+		}
+	}
+}
+
+// do sends a request to Dojah and returns the body of a successful response.
+// errorMessage extracts the error from the body of a failed response.
+func (p *dojahProvider) do(method, path string, payload interface{}, errorMessage func([]byte) string) ([]byte, error) {
+	requestBody := &bytes.Buffer{}
+	if payload != nil {
+		payloadJSON, err := json.Marshal(payload)
+		if err != nil {
+			return nil, &ProviderError{Provider: p.Name(), Retryable: true, Err: fmt.Errorf("failed to marshal JSON: %w", err)}
+		}
+		requestBody = bytes.NewBuffer(payloadJSON)
+	}
+
+	req, err := http.NewRequest(method, "https://api.dojah.io"+path, requestBody)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.Name(), Retryable: true, Err: fmt.Errorf("failed to create request: %w", err)}
+	}
+
+	req.Header.Set("AppId", os.Getenv("DOJAH_APP_ID"))
+	req.Header.Set("Authorization", os.Getenv("DOJAH_SECRET_KEY"))
+	req.Header.Set("accept", "application/json")
+	req.Header.Set("content-type", "application/json")
+
+	client := &http.Client{Timeout: providerTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.Name(), Retryable: notSent(err), Err: fmt.Errorf("failed to send request: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.Name(), Err: fmt.Errorf("failed to read response: %w", err)}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		message := errorMessage(body)
+		if message == "" {
+			message = string(body)
+		}
+		// Only a 4xx is a certain rejection; after a 5xx or gateway error Dojah may still deliver
+		return nil, &ProviderError{Provider: p.Name(), Retryable: resp.StatusCode >= 400 && resp.StatusCode < 500, Err: errors.New(message)}
+	}
+
+	return body, nil
+}
+
+// dojahStatus maps Dojah's per-destination status onto a VAS purchase status
+func dojahStatus(status string) string {
+	switch status {
+	case "Sent", "sent", "Successful", "successful", "success", "delivered":
+		return VASStatusSuccess
+	case "Failed", "failed", "Declined", "declined":
+		return VASStatusFailed
+	}
+	return VASStatusPending
+}
+
+// providerTimeout bounds every request to a VAS or bill provider so a hung provider cannot block a purchase
+const providerTimeout = 60 * time.Second
+
+// notSent reports whether a request failed before it reached the provider, in which case it is safe to
+// retry elsewhere. Any failure after the connection was made may still be processed by the provider.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,337 @@
+package bill
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go_code/pkg/float"
+)
+
+// VAS purchase statuses reported by providers
+const (
+	VASStatusPending = "pending"
+	VASStatusSuccess = "success"
+	VASStatusFailed  = "failed"
+)
+
+// VAS routing policies, selected with VAS_ROUTING_POLICY
+const (
+	RoutePriority = "priority"
+	RouteCost     = "cost"
+	RouteHealth   = "health"
+)
+
+// A provider is skipped for circuitCooldown after circuitThreshold consecutive failures
+const (
+	circuitThreshold = 3
+	circuitCooldown  = 5 * time.Minute
+)
+
+// VASProvider is implemented by the airtime and data vendors purchases can be routed to
+type VASProvider interface {
+	// Name identifies the provider in configuration and transaction records
+	Name() string
+	// Balance returns the float available with the provider
+	Balance() (float64, error)
+	// DataPlans returns the data plans the provider sells
+	DataPlans() ([]VASPlan, error)
+	// PurchaseAirtime tops up the destination with airtime
+	PurchaseAirtime(destination string, amount float64, reference string) (*VASPurchase, error)
+	// PurchaseData buys the provider's equivalent of the plan for the destination
+	PurchaseData(destination string, plan VASPlan, reference string) (*VASPurchase, error)
+	// QueryStatus looks up the status of an earlier purchase by the provider's reference
+	QueryStatus(providerReference string) (*VASPurchase, error)
+}
+
+// VASPlan represents a data plan sold by a provider
+type VASPlan struct {
+	Plan        string  `json:"plan"`
+	Description string  `json:"description"`
+	Network     string  `json:"network,omitempty"`
+	Amount      float64 `json:"amount"`
+}
+
+// VASPurchase represents the outcome of an airtime or data purchase with a provider
+type VASPurchase struct {
+	Provider          string `json:"provider"`
+	ProviderReference string `json:"reference_id"`
+	Destination       string `json:"destination"`
+	Status            string `json:"status"`
+}
+
+// vasDestinationStatus represents the status of a purchase for one destination
+type vasDestinationStatus struct {
+	Destination string `json:"destination"`
+	Status      string `json:"status"`
+}
+
+// ProviderError is returned by a provider for a failed call.
+// Retryable errors are those where the provider certainly did not process the purchase,
+// so it is safe to send it to another provider.
+type ProviderError struct {
+	Provider  string
+	Retryable bool
+	Err       error
+}
+
+func (e *ProviderError) Error() string {
+	return e.Provider + ": " + e.Err.Error()
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// pendingPurchase turns an error after which the provider may still have processed the purchase into
+// a pending purchase, so it is requeried rather than refunded or sent to another provider
+func pendingPurchase(err error, destination, providerReference string) (*VASPurchase, error) {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) && !providerErr.Retryable {
+		fmt.Printf("Purchase outcome unknown, marking as pending: %v\n", err)
+		return &VASPurchase{
+			ProviderReference: providerReference,
+			Destination:       destination,
+			Status:            VASStatusPending,
+		}, nil
+	}
+	return nil, err
+}
+
+// errNoProviderFloat is returned when no provider has enough float for a purchase
+var errNoProviderFloat = errors.New("no provider has enough float")
+
+// providerHealth tracks the recent results of calls to a provider
+type providerHealth struct {
+	consecutiveFailures int
+	successes           int
+	failures            int
+	openUntil           time.Time
+}
+
+// VASRouter orders providers by the routing policy and fails purchases over between them
+type VASRouter struct {
+	mu        sync.Mutex
+	providers []VASProvider
+	health    map[string]*providerHealth
+}
+
+// vasRouter routes airtime and data purchases. VAS_PROVIDERS lists the providers in priority order.
+var vasRouter = newVASRouter(configuredVASProviders())
+
+func newVASRouter(providers []VASProvider) *VASRouter {
+	router := &VASRouter{
+		providers: providers,
+		health:    make(map[string]*providerHealth),
+	}
+	for _, provider := range providers {
+		router.health[provider.Name()] = &providerHealth{}
+	}
+	return router
+}
+
+// configuredVASProviders returns the providers named in VAS_PROVIDERS, defaulting to Dojah then the bills aggregator
+func configuredVASProviders() []VASProvider {
+	available := map[string]VASProvider{
+		"dojah":      &dojahProvider{},
+		"aggregator": &aggregatorVASProvider{},
+	}
+
+	names := os.Getenv("VAS_PROVIDERS")
+	if names == "" {
+		names = "dojah,aggregator"
+	}
+
+	var providers []VASProvider
+	for _, name := range strings.Split(names, ",") {
+		if provider, ok := available[strings.TrimSpace(strings.ToLower(name))]; ok {
+			providers = append(providers, provider)
+		}
+	}
+	return providers
+}
+
+// Provider returns the provider with the given name
+func (r *VASRouter) Provider(name string) (VASProvider, bool) {
+	for _, provider := range r.providers {
+		if provider.Name() == name {
+			return provider, true
+		}
+	}
+	return nil, false
+}
+
+// FloatSources returns the provider wallets whose float should be monitored.
+// Bill payments share the aggregator wallet, which is monitored even when it is not a VAS provider.
+func FloatSources() []float.Source {
+	sources := make([]float.Source, 0, len(vasRouter.providers)+1)
+	for _, provider := range vasRouter.providers {
+		sources = append(sources, float.Source{Name: provider.Name(), Balance: provider.Balance})
+	}
+	if _, ok := vasRouter.Provider("aggregator"); !ok {
+		sources = append(sources, float.Source{Name: "aggregator", Balance: billers[CategoryElectricity].Balance})
+	}
+	return sources
+}
+
+// QueryPurchaseStatus looks up an earlier purchase with the provider that made it
+func QueryPurchaseStatus(providerName, providerReference string) (*VASPurchase, error) {
+	provider, ok := vasRouter.Provider(providerName)
+	if !ok {
+		return nil, fmt.Errorf("provider %s is no longer configured", providerName)
+	}
+	return provider.QueryStatus(providerReference)
+}
+
+// DataPlans returns the plan catalogue of the highest priority provider that responds
+func (r *VASRouter) DataPlans() ([]VASPlan, error) {
+	var lastErr error = fmt.Errorf("no VAS provider configured")
+	for _, provider := range r.providers {
+		plans, err := provider.DataPlans()
+		if err == nil {
+			return plans, nil
+		}
+		lastErr = err
+		fmt.Printf("Failed to fetch %s data plans: %v\n", provider.Name(), err)
+	}
+	return nil, lastErr
+}
+
+// candidates returns the providers in the order purchases should try them.
+// Providers whose circuit is open are moved to the end as a last resort.
+func (r *VASRouter) candidates() []VASProvider {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ordered := make([]VASProvider, len(r.providers))
+	copy(ordered, r.providers)
+
+	switch os.Getenv("VAS_ROUTING_POLICY") {
+	case RouteCost:
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return providerCost(ordered[i].Name()) < providerCost(ordered[j].Name())
+		})
+	case RouteHealth:
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return r.successRate(ordered[i].Name()) > r.successRate(ordered[j].Name())
+		})
+	}
+
+	now := time.Now()
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return !r.health[ordered[i].Name()].openUntil.After(now) && r.health[ordered[j].Name()].openUntil.After(now)
+	})
+
+	return ordered
+}
+
+// successRate returns the share of successful calls to a provider, treating an unused provider as healthy
+func (r *VASRouter) successRate(name string) float64 {
+	health := r.health[name]
+	total := health.successes + health.failures
+	if total == 0 {
+		return 1
+	}
+	return float64(health.successes) / float64(total)
+}
+
+// report records the result of a call to a provider and opens its circuit after repeated failures
+func (r *VASRouter) report(name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	health := r.health[name]
+	if err == nil {
+		health.successes++
+		health.consecutiveFailures = 0
+		return
+	}
+
+	health.failures++
+	health.consecutiveFailures++
+	if health.consecutiveFailures >= circuitThreshold {
+		health.openUntil = time.Now().Add(circuitCooldown)
+	}
+}
+
+// CheckFloat returns an error unless at least one provider has enough float for the amount.
+// Providers that are short of float raise a low balance alert.
+func (r *VASRouter) CheckFloat(amount float64) error {
+	for _, provider := range r.candidates() {
+		if r.hasFloat(provider, amount) {
+			return nil
+		}
+	}
+	return &PurchaseError{StatusCode: http.StatusServiceUnavailable, Message: "Insufficient balance in our vault, please try again later.", Err: errNoProviderFloat}
+}
+
+// hasFloat reports whether the provider can cover the amount, alerting when it cannot
+func (r *VASRouter) hasFloat(provider VASProvider, amount float64) bool {
+	balance, err := provider.Balance()
+	r.report(provider.Name(), err)
+	if err != nil {
+		fmt.Printf("Failed to check %s balance: %v\n", provider.Name(), err)
+		return false
+	}
+	if balance < amount {
+		float.ReportLow(provider.Name(), balance)
+		return false
+	}
+	return true
+}
+
+// PurchaseAirtime buys airtime from the first provider that accepts it
+func (r *VASRouter) PurchaseAirtime(destination string, amount float64, reference string) (*VASPurchase, error) {
+	return r.purchase(amount, func(provider VASProvider) (*VASPurchase, error) {
+		return provider.PurchaseAirtime(destination, amount, reference)
+	})
+}
+
+// PurchaseData buys the plan from the first provider that accepts it
+func (r *VASRouter) PurchaseData(destination string, plan VASPlan, reference string) (*VASPurchase, error) {
+	return r.purchase(plan.Amount, func(provider VASProvider) (*VASPurchase, error) {
+		return provider.PurchaseData(destination, plan, reference)
+	})
+}
+
+// purchase tries each provider in routing order. It only fails over when the previous provider
+// certainly did not process the purchase, so a customer is never served twice.
+func (r *VASRouter) purchase(amount float64, buy func(VASProvider) (*VASPurchase, error)) (*VASPurchase, error) {
+	var lastErr error = errNoProviderFloat
+	for _, provider := range r.candidates() {
+		if !r.hasFloat(provider, amount) {
+			continue
+		}
+
+		purchase, err := buy(provider)
+		r.report(provider.Name(), err)
+		if err == nil {
+			purchase.Provider = provider.Name()
+			return purchase, nil
+		}
+
+		lastErr = err
+		var providerErr *ProviderError
+		if !errors.As(err, &providerErr) || !providerErr.Retryable {
+			return nil, err
+		}
+		fmt.Printf("Failing over from %s: %v\n", provider.Name(), err)
+	}
+	return nil, lastErr
+}
+
+// providerCost returns the configured cost of buying through a provider, VAS_COST_<NAME>, as a
+// fraction of face value. Providers without a configured cost are tried last under the cost policy.
+func providerCost(name string) float64 {
+	cost, err := strconv.ParseFloat(os.Getenv("VAS_COST_"+strings.ToUpper(name)), 64)
+	if err != nil {
+		return 1
+	}
+	return cost
+}
@@ -0,0 +1,411 @@
+package bill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/fee"
+	"go_code/pkg/msisdn"
+	"go_code/pkg/notification"
+	"go_code/pkg/reversal"
+)
+
+// maxRequeryAttempts is the number of status checks after which a pending purchase is left for manual review
+const maxRequeryAttempts = 12
+
+// requeryBackoff is multiplied by the attempt number to space out status checks of a pending purchase
+const requeryBackoff = 2 * time.Minute
+
+// requeryClaimTimeout stops other instances from requerying a purchase while it is being checked
+const requeryClaimTimeout = "5 minutes"
+
+// VASPurchaseRecord represents an airtime or data purchase and its delivery status
+type VASPurchaseRecord struct {
+	PurchaseID        int64      `json:"purchase_id"`
+	UserID            int        `json:"user_id"`
+	TransactionID     int64      `json:"transaction_id"`
+	Reference         string     `json:"reference"`
+	Provider          string     `json:"provider"`
+	ProviderReference string     `json:"provider_reference"`
+	Product           string     `json:"product"`
+	Destination       string     `json:"destination"`
+	Plan              string     `json:"plan,omitempty"`
+	Amount            float64    `json:"amount"`
+	Fee               float64    `json:"fee"`
+	Status            string     `json:"status"`
+	Attempts          int        `json:"attempts"`
+	LastError         string     `json:"last_error,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	CompletedAt       *time.Time `json:"completed_at,omitempty"`
+}
+
+const vasPurchaseColumns = `purchase_id, user_id, COALESCE(transaction_id, 0), reference, provider, COALESCE(provider_reference, ''), product,
+	destination, COALESCE(plan, ''), amount, fee, status, attempts, COALESCE(last_error, ''), created_at, completed_at`
+
+// VASPurchaseStatusHandler returns the delivery status of an airtime or data purchase by its reference
+func VASPurchaseStatusHandler(c *gin.Context) {
+	record, err := FetchVASPurchase(c.Param("reference"))
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Purchase not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch purchase: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Purchase fetched successfully",
+		Result:  record,
+	})
+}
+
+// FetchVASPurchase returns the airtime or data purchase with the given reference
+func FetchVASPurchase(reference string) (*VASPurchaseRecord, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	row := db.QueryRow(context.Background(), "SELECT "+vasPurchaseColumns+" FROM vas_purchase WHERE reference = $1", reference)
+	return scanVASPurchase(row)
+}
+
+// StartVASRequery checks the status of pending airtime, data and bill purchases and resumes interrupted bulk
+// purchases every interval in a background goroutine
+func StartVASRequery(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := requeryPendingPurchases(); err != nil {
+				log.Printf("VAS requery failed: %v\n", err)
+			}
+		}
+	}()
+}
+
+// saveVASPurchase records a purchase against its wallet debit; bulk purchase lines have no debit of their own.
+// Pending purchases are queued for a status check. Those without a provider reference are matched against
+// the provider's history when they are requeried, and the admin is alerted straight away.
+func saveVASPurchase(userID int, transactionID int64, reference, product, plan string, amount float64, quote *fee.Quote, purchase *VASPurchase) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	var lastError string
+	var nextCheck *time.Time
+	if purchase.Status == VASStatusPending {
+		next := time.Now().Add(requeryBackoff)
+		nextCheck = &next
+		if purchase.ProviderReference == "" {
+			lastError = "No provider reference returned"
+			body := fmt.Sprintf("The %s purchase %s of NGN %.2f for %s with %s returned no provider reference. "+
+				"It will be matched against the provider's history and needs manual review if no match is found.",
+				product, reference, amount, purchase.Destination, purchase.Provider)
+			if err := notification.SendEmail(os.Getenv("ADMIN_EMAIL"), "VAS purchase without provider reference", body); err != nil {
+				fmt.Println("Failed to send email:", err)
+			}
+		}
+	}
+
+	_, err = db.Exec(context.Background(), `
+		INSERT INTO vas_purchase (user_id, transaction_id, reference, provider, provider_reference, product, destination, plan,
+			amount, fee, status, last_error, next_check_at, completed_at)
+		VALUES ($1, NULLIF($2, 0), $3, $4, NULLIF($5, ''), $6, $7, NULLIF($8, ''), $9, $10, $11, NULLIF($12, ''), $13,
+			CASE WHEN $11 = 'pending' THEN NULL ELSE NOW() END)
+	`, userID, transactionID, reference, purchase.Provider, purchase.ProviderReference, product, purchase.Destination, plan,
+		amount, quote.Fee, purchase.Status, lastError, nextCheck)
+	return err
+}
+
+// requeryPendingPurchases claims pending purchases and bill payments that are due a status check and checks them with their provider
+func requeryPendingPurchases() error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		UPDATE vas_purchase SET next_check_at = NOW() + INTERVAL '`+requeryClaimTimeout+`'
+		WHERE purchase_id IN (
+			SELECT purchase_id FROM vas_purchase
+			WHERE status = 'pending' AND next_check_at <= NOW()
+			ORDER BY next_check_at
+			LIMIT 50
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+vasPurchaseColumns)
+	if err != nil {
+		return fmt.Errorf("failed to claim pending purchases: %w", err)
+	}
+
+	var due []*VASPurchaseRecord
+	for rows.Next() {
+		record, err := scanVASPurchase(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, record)
+	}
+	rows.Close()
+
+	for _, record := range due {
+		requeryPurchase(record)
+	}
+
+	// Bulk purchase lines a restart left behind are resumed on the same schedule
+	if err := recoverBulkVASItems(); err != nil {
+		log.Printf("Bulk purchase recovery failed: %v\n", err)
+	}
+
+	// Bill payments the aggregator is still processing are settled the same way
+	return requeryPendingBillPayments()
+}
+
+// requeryPurchase checks a pending purchase with its provider. Delivered purchases are completed,
+// failed ones are refunded and purchases still pending are checked again later.
+func requeryPurchase(record *VASPurchaseRecord) {
+	provider, ok := vasRouter.Provider(record.Provider)
+	if !ok {
+		finishRequery(record, VASStatusPending, "Provider "+record.Provider+" is no longer configured")
+		return
+	}
+
+	if record.ProviderReference == "" {
+		providerReference, err := matchProviderReference(record)
+		if err != nil {
+			finishRequery(record, VASStatusPending, err.Error())
+			return
+		}
+		record.ProviderReference = providerReference
+	}
+
+	purchase, err := provider.QueryStatus(record.ProviderReference)
+	if err != nil {
+		finishRequery(record, VASStatusPending, err.Error())
+		return
+	}
+
+	// Lines of a bulk purchase have no debit of their own; they are settled through the batch
+	bulk := record.TransactionID == 0
+
+	switch purchase.Status {
+	case VASStatusSuccess:
+		if bulk {
+			if err := updateBulkVASItem(record.Reference, VASStatusSuccess, ""); err != nil {
+				finishRequery(record, VASStatusPending, "Failed to update bulk line: "+err.Error())
+				return
+			}
+		}
+		finishRequery(record, VASStatusSuccess, "")
+		if !bulk {
+			notifyVASPurchase(record, VASStatusSuccess)
+		}
+	case VASStatusFailed:
+		if bulk {
+			err = updateBulkVASItem(record.Reference, VASStatusFailed, "Provider reported purchase failed")
+		} else {
+			_, err = reversal.Reverse(record.TransactionID, "Provider reported "+record.Product+" purchase failed", reversal.InitiatedBySystem)
+		}
+		if err != nil && !errors.Is(err, reversal.ErrAlreadyReversed) {
+			finishRequery(record, VASStatusPending, "Failed to refund: "+err.Error())
+			return
+		}
+		finishRequery(record, VASStatusFailed, "Provider reported purchase failed")
+		if !bulk {
+			notifyVASPurchase(record, VASStatusFailed)
+		}
+	default:
+		finishRequery(record, VASStatusPending, "")
+	}
+}
+
+// matchProviderReference finds the provider's reference for a purchase the provider returned none for.
+// Dojah never sees our reference, so its history for the day is searched for a purchase to the same
+// destination that no other purchase has claimed; only a single candidate is accepted.
+func matchProviderReference(record *VASPurchaseRecord) (string, error) {
+	if record.Provider != "dojah" {
+		return "", fmt.Errorf("No provider reference returned, manual review required")
+	}
+
+	from := time.Date(record.CreatedAt.Year(), record.CreatedAt.Month(), record.CreatedAt.Day(), 0, 0, 0, 0, record.CreatedAt.Location())
+	purchases, err := ListDojahPurchases(from, from.AddDate(0, 0, 1))
+	if err != nil {
+		return "", fmt.Errorf("Failed to list Dojah purchases: %w", err)
+	}
+
+	var candidates []string
+	for _, purchase := range purchases {
+		if !sameDestination(purchase.Destination, record.Destination) {
+			continue
+		}
+		if record.Product == fee.ProductAirtime && math.Abs(purchase.Amount-record.Amount) >= 0.01 {
+			continue
+		}
+		candidates = append(candidates, purchase.ReferenceID)
+	}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close(context.Background())
+
+	// Drop the references already matched to other purchases
+	rows, err := db.Query(context.Background(),
+		"SELECT provider_reference FROM vas_purchase WHERE provider = $1 AND provider_reference = ANY($2)", record.Provider, candidates)
+	if err != nil {
+		return "", err
+	}
+	claimed := make(map[string]bool)
+	for rows.Next() {
+		var reference string
+		if err := rows.Scan(&reference); err != nil {
+			rows.Close()
+			return "", err
+		}
+		claimed[reference] = true
+	}
+	rows.Close()
+
+	var unclaimed []string
+	for _, candidate := range candidates {
+		if !claimed[candidate] {
+			unclaimed = append(unclaimed, candidate)
+		}
+	}
+	if len(unclaimed) != 1 {
+		return "", fmt.Errorf("Found %d matching Dojah purchases, manual review required", len(unclaimed))
+	}
+
+	tag, err := db.Exec(context.Background(), `
+		UPDATE vas_purchase SET provider_reference = $2, updated_at = NOW()
+		WHERE purchase_id = $1 AND provider_reference IS NULL
+			AND NOT EXISTS (SELECT 1 FROM vas_purchase WHERE provider = $3 AND provider_reference = $2)
+	`, record.PurchaseID, unclaimed[0], record.Provider)
+	if err != nil {
+		return "", err
+	}
+	if tag.RowsAffected() == 0 {
+		return "", fmt.Errorf("Dojah purchase %s was matched to another purchase", unclaimed[0])
+	}
+	return unclaimed[0], nil
+}
+
+// sameDestination compares phone numbers regardless of their format
+func sameDestination(a, b string) bool {
+	normalisedA, errA := msisdn.Normalise(a)
+	normalisedB, errB := msisdn.Normalise(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return normalisedA == normalisedB
+}
+
+// finishRequery records the outcome of a status check. A purchase that is still pending after
+// maxRequeryAttempts checks is no longer requeried and the admin is alerted to review it.
+func finishRequery(record *VASPurchaseRecord, status, lastError string) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		log.Printf("Failed to connect to database: %v\n", err)
+		return
+	}
+	defer db.Close(context.Background())
+
+	record.Attempts++
+	var nextCheck *time.Time
+	if status == VASStatusPending && record.Attempts < maxRequeryAttempts {
+		next := time.Now().Add(requeryBackoff * time.Duration(record.Attempts+1))
+		nextCheck = &next
+	}
+
+	_, err = db.Exec(context.Background(), `
+		UPDATE vas_purchase
+		SET status = $2, attempts = $3, last_error = NULLIF($4, ''), next_check_at = $5, updated_at = NOW(),
+			completed_at = CASE WHEN $2 = 'pending' THEN NULL ELSE NOW() END
+		WHERE purchase_id = $1
+	`, record.PurchaseID, status, record.Attempts, lastError, nextCheck)
+	if err != nil {
+		log.Printf("Failed to update purchase %s: %v\n", record.Reference, err)
+		return
+	}
+
+	if status == VASStatusPending && nextCheck == nil {
+		body := fmt.Sprintf("The %s purchase %s for %s with %s is still pending after %d status checks and needs manual review.",
+			record.Product, record.Reference, record.Destination, record.Provider, record.Attempts)
+		if err := notification.SendEmail(os.Getenv("ADMIN_EMAIL"), "Pending VAS purchase needs review", body); err != nil {
+			fmt.Println("Failed to send email:", err)
+		}
+	}
+}
+
+// notifyVASPurchase emails the user the final outcome of a purchase that was pending
+func notifyVASPurchase(record *VASPurchaseRecord, status string) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		log.Printf("Failed to connect to database: %v\n", err)
+		return
+	}
+	defer db.Close(context.Background())
+
+	var fullname, email string
+	err = db.QueryRow(context.Background(), "SELECT fullname, email FROM users WHERE user_id = $1", record.UserID).Scan(&fullname, &email)
+	if err != nil {
+		log.Printf("Failed to fetch user for purchase %s: %v\n", record.Reference, err)
+		return
+	}
+
+	subject := fmt.Sprintf("%s purchase successful", productLabel(record.Product))
+	outcome := fmt.Sprintf("Your %s purchase of NGN %.2f for %s has been delivered.", record.Product, record.Amount, record.Destination)
+	if status == VASStatusFailed {
+		subject = fmt.Sprintf("%s purchase failed", productLabel(record.Product))
+		outcome = fmt.Sprintf("Your %s purchase of NGN %.2f for %s failed and NGN %.2f has been refunded to your wallet.",
+			record.Product, record.Amount, record.Destination, record.Amount+record.Fee)
+	}
+
+	body := fmt.Sprintf("Dear %s,\n\n%s\nReference: %s", fullname, outcome, record.Reference)
+	if err := notification.SendEmail(email, subject, body); err != nil {
+		fmt.Println("Failed to send email:", err)
+	}
+}
+
+// productLabel returns the capitalised name of a VAS product
+func productLabel(product string) string {
+	if product == fee.ProductData {
+		return "Data"
+	}
+	return "Airtime"
+}
+
+func scanVASPurchase(row pgx.Row) (*VASPurchaseRecord, error) {
+	var record VASPurchaseRecord
+	err := row.Scan(&record.PurchaseID, &record.UserID, &record.TransactionID, &record.Reference, &record.Provider,
+		&record.ProviderReference, &record.Product, &record.Destination, &record.Plan, &record.Amount, &record.Fee,
+		&record.Status, &record.Attempts, &record.LastError, &record.CreatedAt, &record.CompletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
@@ -0,0 +1,83 @@
+package docstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// encryptedVersion prefixes every encrypted document so the format can change later
+const encryptedVersion byte = 1
+
+// EncryptedStore encrypts documents with AES-256-GCM before handing them to the underlying store
+type EncryptedStore struct {
+	store Store
+	aead  cipher.AEAD
+}
+
+// NewEncryptedStore wraps a store so documents are encrypted with a 32 byte key
+func NewEncryptedStore(store Store, key []byte) (*EncryptedStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedStore{store: store, aead: aead}, nil
+}
+
+// Put encrypts and stores a document. The key is bound to the ciphertext so documents cannot be swapped.
+func (s *EncryptedStore) Put(key string, data []byte) error {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	sealed := append([]byte{encryptedVersion}, nonce...)
+	sealed = s.aead.Seal(sealed, nonce, data, []byte(key))
+	return s.store.Put(key, sealed)
+}
+
+// Get fetches and decrypts a document
+func (s *EncryptedStore) Get(key string) ([]byte, error) {
+	sealed, err := s.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(sealed) < 1+nonceSize || sealed[0] != encryptedVersion {
+		return nil, errors.New("document is not in a supported encrypted format")
+	}
+	data, err := s.aead.Open(nil, sealed[1:1+nonceSize], sealed[1+nonceSize:], []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt document: %w", err)
+	}
+	return data, nil
+}
+
+// Delete removes a document
+func (s *EncryptedStore) Delete(key string) error {
+	return s.store.Delete(key)
+}
+
+// encryptionKey decodes a 32 byte key given in hex or base64
+func encryptionKey(value string) ([]byte, error) {
+	if value == "" {
+		return nil, errors.New("DOCSTORE_ENCRYPTION_KEY is not set")
+	}
+	if key, err := hex.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("DOCSTORE_ENCRYPTION_KEY must be 32 bytes in hex or base64")
+}
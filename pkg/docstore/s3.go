@@ -0,0 +1,147 @@
+package docstore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// S3Store keeps documents in a bucket of an S3-compatible object store, addressed path-style
+type S3Store struct {
+	Endpoint  string // e.g. https://s3.eu-west-1.amazonaws.com or a MinIO URL
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	client    *http.Client
+}
+
+// NewS3StoreFromEnv returns an S3 store configured by the DOCSTORE_S3_* variables
+func NewS3StoreFromEnv() (*S3Store, error) {
+	store := &S3Store{
+		Endpoint:  strings.TrimRight(os.Getenv("DOCSTORE_S3_ENDPOINT"), "/"),
+		Region:    os.Getenv("DOCSTORE_S3_REGION"),
+		Bucket:    os.Getenv("DOCSTORE_S3_BUCKET"),
+		AccessKey: os.Getenv("DOCSTORE_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("DOCSTORE_S3_SECRET_KEY"),
+		client:    &http.Client{Timeout: 60 * time.Second},
+	}
+	if store.Region == "" {
+		store.Region = "us-east-1"
+	}
+	if store.Endpoint == "" || store.Bucket == "" || store.AccessKey == "" || store.SecretKey == "" {
+		return nil, errors.New("DOCSTORE_S3_ENDPOINT, DOCSTORE_S3_BUCKET, DOCSTORE_S3_ACCESS_KEY and DOCSTORE_S3_SECRET_KEY are required")
+	}
+	return store, nil
+}
+
+// Put uploads a document
+func (s *S3Store) Put(key string, data []byte) error {
+	_, err := s.do("PUT", key, data)
+	return err
+}
+
+// Get downloads a document
+func (s *S3Store) Get(key string) ([]byte, error) {
+	return s.do("GET", key, nil)
+}
+
+// Delete removes a document
+func (s *S3Store) Delete(key string) error {
+	_, err := s.do("DELETE", key, nil)
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// do sends a request for an object signed with AWS Signature Version 4
+func (s *S3Store) do(method, key string, data []byte) ([]byte, error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := strings.Split(strings.Trim(key, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	path := "/" + url.PathEscape(s.Bucket) + "/" + strings.Join(segments, "/")
+
+	req, err := http.NewRequest(method, endpoint.Scheme+"://"+endpoint.Host+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, path, data, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("object store returned %d: %s", resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+// sign adds the AWS Signature Version 4 headers to a request without a query string
+func (s *S3Store) sign(req *http.Request, path string, data []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(data)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		"",
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, s.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
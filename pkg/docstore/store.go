@@ -0,0 +1,45 @@
+package docstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrNotFound is returned when a document does not exist in the store
+var ErrNotFound = errors.New("document not found")
+
+// Store keeps documents under a key
+type Store interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+// New returns the store selected by DOCSTORE_DRIVER ("local" or "s3"), encrypting documents
+// at rest with DOCSTORE_ENCRYPTION_KEY
+func New() (Store, error) {
+	var store Store
+	switch driver := os.Getenv("DOCSTORE_DRIVER"); driver {
+	case "", "local":
+		dir := os.Getenv("DOCSTORE_LOCAL_DIR")
+		if dir == "" {
+			dir = "documents"
+		}
+		store = NewLocalStore(dir)
+	case "s3":
+		s3, err := NewS3StoreFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		store = s3
+	default:
+		return nil, fmt.Errorf("unknown document store driver %q", driver)
+	}
+
+	key, err := encryptionKey(os.Getenv("DOCSTORE_ENCRYPTION_KEY"))
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryptedStore(store, key)
+}
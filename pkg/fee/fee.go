@@ -0,0 +1,344 @@
+package fee
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/ledger"
+)
+
+// Products a fee can be configured for
+const (
+	ProductTransfer = "transfer"
+	ProductP2P      = "p2p"
+	ProductAirtime  = "airtime"
+	ProductData     = "data"
+	// Bill categories are charged under their category name
+	ProductElectricity = "electricity"
+	ProductCableTV     = "cable_tv"
+	ProductBetting     = "betting"
+)
+
+var products = map[string]bool{
+	ProductTransfer:    true,
+	ProductP2P:         true,
+	ProductAirtime:     true,
+	ProductData:        true,
+	ProductElectricity: true,
+	ProductCableTV:     true,
+	ProductBetting:     true,
+}
+
+// Quote represents the fee charged on a transaction and the total the wallet is debited
+type Quote struct {
+	Product    string  `json:"product"`
+	Amount     float64 `json:"amount"`
+	Fee        float64 `json:"fee"`
+	Total      float64 `json:"total"`
+	RuleID     int64   `json:"rule_id,omitempty"`
+	Waived     bool    `json:"waived"`
+	WaiverID   int64   `json:"waiver_id,omitempty"`
+	WaiverNote string  `json:"waiver_note,omitempty"`
+}
+
+// Response represents the generic response structure
+type Response struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message"`
+	Result  interface{} `json:"result,omitempty"`
+}
+
+// QuoteHandler returns the fee for a product and amount before the user commits to the transaction
+func QuoteHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user ID",
+		})
+		return
+	}
+
+	amount, err := strconv.ParseFloat(c.Query("amount"), 64)
+	if err != nil || amount <= 0 {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid amount",
+		})
+		return
+	}
+
+	product := strings.ToLower(c.Query("product"))
+	if !products[product] {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Product must be one of transfer, p2p, airtime, data, electricity, cable_tv or betting",
+		})
+		return
+	}
+
+	quote, err := Calculate(userID, product, amount)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to calculate fee: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Fee calculated successfully",
+		Result:  quote,
+	})
+}
+
+// Calculate evaluates the fee schedule for a transaction.
+// The most specific active rule for the product, the user's KYC tier and the amount band applies:
+// a flat fee plus a percentage of the amount, bounded by the rule's minimum and maximum fee.
+// An active promotional waiver for the user brings the fee to zero.
+func Calculate(userID int, product string, amount float64) (*Quote, error) {
+	quote := &Quote{
+		Product: product,
+		Amount:  amount,
+		Total:   amount,
+	}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close(context.Background())
+
+	tier, err := userTier(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := fetchRules(db, product)
+	if err != nil {
+		return nil, err
+	}
+	rule := matchRule(rules, tier, amount)
+	if rule == nil {
+		return quote, nil
+	}
+	quote.RuleID = rule.ruleID
+
+	fee := rule.fee(amount)
+	if fee <= 0 {
+		return quote, nil
+	}
+
+	var waiver feeWaiver
+	err = db.QueryRow(context.Background(), `
+		SELECT w.waiver_id, COALESCE(w.description, '')
+		FROM fee_waiver w
+		WHERE w.active = true
+			AND (w.product IS NULL OR w.product = $1)
+			AND (w.user_id IS NULL OR w.user_id = $2)
+			AND (w.kyc_tier IS NULL OR w.kyc_tier = $3)
+			AND w.starts_at <= NOW() AND (w.ends_at IS NULL OR w.ends_at > NOW())
+			AND (w.max_uses_per_user IS NULL OR w.max_uses_per_user > (
+				SELECT COUNT(*) FROM fee_revenue r WHERE r.waiver_id = w.waiver_id AND r.user_id = $2
+			))
+		ORDER BY w.waiver_id
+		LIMIT 1
+	`, product, userID, tier).Scan(&waiver.waiverID, &waiver.note)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch fee waiver: %w", err)
+	}
+	if err == pgx.ErrNoRows {
+		return applyFee(quote, fee, nil), nil
+	}
+	return applyFee(quote, fee, &waiver), nil
+}
+
+// feeRule represents a row of the fee schedule
+type feeRule struct {
+	ruleID     int64
+	kycTier    *int
+	minAmount  *float64
+	maxAmount  *float64
+	flatFee    float64
+	percentage float64
+	minFee     *float64
+	maxFee     *float64
+}
+
+// feeWaiver represents a promotional waiver that applies to a transaction
+type feeWaiver struct {
+	waiverID int64
+	note     string
+}
+
+// fetchRules returns the active rules for a product, tier specific rules first and then by priority
+func fetchRules(db *pgx.Conn, product string) ([]feeRule, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT rule_id, kyc_tier, min_amount, max_amount, flat_fee, percentage, min_fee, max_fee
+		FROM fee_rule
+		WHERE active = true AND product = $1
+		ORDER BY kyc_tier IS NULL, priority DESC, rule_id DESC
+	`, product)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch fee rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []feeRule
+	for rows.Next() {
+		var rule feeRule
+		err := rows.Scan(&rule.ruleID, &rule.kycTier, &rule.minAmount, &rule.maxAmount, &rule.flatFee, &rule.percentage,
+			&rule.minFee, &rule.maxFee)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch fee rules: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// matchRule returns the first rule for the tier whose amount band contains the amount, or nil when none applies.
+// A missing bound leaves that side of the band open.
+func matchRule(rules []feeRule, tier int, amount float64) *feeRule {
+	for i := range rules {
+		rule := &rules[i]
+		if rule.kycTier != nil && *rule.kycTier != tier {
+			continue
+		}
+		if (rule.minAmount != nil && amount < *rule.minAmount) || (rule.maxAmount != nil && amount > *rule.maxAmount) {
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+// fee returns the flat fee plus the percentage of the amount, bounded by the rule's minimum and maximum fee
+func (r *feeRule) fee(amount float64) float64 {
+	fee := r.flatFee + amount*r.percentage/100
+	if r.minFee != nil && fee < *r.minFee {
+		fee = *r.minFee
+	}
+	if r.maxFee != nil && fee > *r.maxFee {
+		fee = *r.maxFee
+	}
+	return math.Round(fee*100) / 100
+}
+
+// applyFee adds the fee to the quote, or records the waiver that brings it to zero
+func applyFee(quote *Quote, fee float64, waiver *feeWaiver) *Quote {
+	if waiver != nil {
+		quote.Waived = true
+		quote.WaiverID = waiver.waiverID
+		quote.WaiverNote = waiver.note
+		return quote
+	}
+
+	quote.Fee = fee
+	quote.Total = math.Round((quote.Amount+fee)*100) / 100
+	return quote
+}
+
+// Charge debits the quoted fee from the user's wallet and posts it to the fee revenue account
+// within the given transaction. Waived fees are recorded against their waiver without a debit.
+// It returns the id of the user_transaction row created, or zero when nothing was debited.
+func Charge(tx pgx.Tx, userID int, quote *Quote, reference string) (int64, error) {
+	if quote == nil || (quote.Fee <= 0 && !quote.Waived) {
+		return 0, nil
+	}
+
+	var transactionID int64
+	if quote.Fee > 0 {
+		var err error
+		transactionID, err = ledger.Debit(tx, ledger.Entry{
+			UserID:    userID,
+			Amount:    quote.Fee,
+			Reference: reference,
+			Narration: productName(quote.Product) + " fee",
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err := tx.Exec(context.Background(), `
+		INSERT INTO fee_revenue (user_id, reference, product, amount, rule_id, waiver_id, transaction_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0), NULLIF($6, 0), NULLIF($7, 0))
+	`, userID, reference, quote.Product, quote.Fee, quote.RuleID, quote.WaiverID, transactionID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to post fee: %w", err)
+	}
+
+	return transactionID, nil
+}
+
+// Refund returns a fee charged on a failed transaction to the user's wallet and
+// reverses it out of the fee revenue account within the given transaction
+func Refund(tx pgx.Tx, userID int, product string, amount float64, reference string) error {
+	if amount <= 0 {
+		return nil
+	}
+
+	transactionID, err := ledger.Credit(tx, ledger.Entry{
+		UserID:    userID,
+		Amount:    amount,
+		Reference: reference,
+		Narration: "Refund of " + strings.ToLower(productName(product)) + " fee",
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.Background(), `
+		INSERT INTO fee_revenue (user_id, reference, product, amount, transaction_id)
+		VALUES ($1, $2, $3, $4, $5)
+	`, userID, reference, product, -amount, transactionID)
+	if err != nil {
+		return fmt.Errorf("failed to reverse fee: %w", err)
+	}
+
+	return nil
+}
+
+// userTier returns the user's KYC tier, as maintained by the kyc package
+func userTier(db *pgx.Conn, userID int) (int, error) {
+	var tier int
+	err := db.QueryRow(context.Background(),
+		"SELECT COALESCE(kyc_tier, 0) FROM users WHERE user_id = $1", userID).Scan(&tier)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch KYC tier: %w", err)
+	}
+	return tier, nil
+}
+
+// productName returns the label used for the product in narrations
+func productName(product string) string {
+	switch product {
+	case ProductTransfer:
+		return "Transfer"
+	case ProductP2P:
+		return "Wallet transfer"
+	case ProductAirtime:
+		return "Airtime"
+	case ProductData:
+		return "Data"
+	case ProductElectricity:
+		return "Electricity"
+	case ProductCableTV:
+		return "Cable TV"
+	case ProductBetting:
+		return "Betting wallet"
+	}
+	return "Transaction"
+}
@@ -0,0 +1,108 @@
+package fee
+
+import "testing"
+
+func float(v float64) *float64 { return &v }
+
+func tier(v int) *int { return &v }
+
+func TestMatchRule(t *testing.T) {
+	rules := []feeRule{
+		{ruleID: 1, kycTier: tier(3), minAmount: float(0), maxAmount: float(5000)},
+		{ruleID: 2, minAmount: float(0), maxAmount: float(5000)},
+		{ruleID: 3, minAmount: float(5000.01), maxAmount: float(50000)},
+		{ruleID: 4, minAmount: float(50000.01)},
+		{ruleID: 5},
+	}
+	bounded := rules[:4]
+
+	tests := []struct {
+		name   string
+		rules  []feeRule
+		tier   int
+		amount float64
+		want   int64
+	}{
+		{"tier specific rule wins", rules, 3, 1000, 1},
+		{"other tiers fall back to the default", rules, 1, 1000, 2},
+		{"upper bound is inclusive", rules, 1, 5000, 2},
+		{"next band", rules, 1, 5000.01, 3},
+		{"band without upper bound", rules, 1, 1000000, 4},
+		{"tier rule outside its band", rules, 3, 20000, 3},
+		{"rule without bounds catches the rest", rules, 1, -1, 5},
+		{"rule without bounds only", []feeRule{{ruleID: 6, flatFee: 10}}, 2, 250, 6},
+		{"rule without lower bound", []feeRule{{ruleID: 7, maxAmount: float(100)}}, 1, 50, 7},
+		{"below every band", bounded, 1, -1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got int64
+			if rule := matchRule(tt.rules, tt.tier, tt.amount); rule != nil {
+				got = rule.ruleID
+			}
+			if got != tt.want {
+				t.Errorf("matchRule(%d, %.2f) = rule %d, want rule %d", tt.tier, tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleFee(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   feeRule
+		amount float64
+		want   float64
+	}{
+		{"flat fee", feeRule{flatFee: 10}, 5000, 10},
+		{"percentage", feeRule{percentage: 1.5}, 2000, 30},
+		{"flat fee plus percentage", feeRule{flatFee: 10, percentage: 1}, 1000, 20},
+		{"minimum fee", feeRule{percentage: 1, minFee: float(25)}, 1000, 25},
+		{"maximum fee", feeRule{percentage: 1.5, maxFee: float(2000)}, 1000000, 2000},
+		{"within caps", feeRule{percentage: 1, minFee: float(5), maxFee: float(50)}, 2000, 20},
+		{"rounded to kobo", feeRule{percentage: 1.5}, 333.33, 5},
+		{"no fee", feeRule{}, 1000, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.fee(tt.amount); got != tt.want {
+				t.Errorf("fee(%.2f) = %.2f, want %.2f", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyFee(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount float64
+		fee    float64
+		waiver *feeWaiver
+		want   Quote
+	}{
+		{
+			name:   "fee added to total",
+			amount: 1000,
+			fee:    10.5,
+			want:   Quote{Amount: 1000, Fee: 10.5, Total: 1010.5},
+		},
+		{
+			name:   "waiver brings fee to zero",
+			amount: 1000,
+			fee:    10.5,
+			waiver: &feeWaiver{waiverID: 7, note: "Launch promo"},
+			want:   Quote{Amount: 1000, Total: 1000, Waived: true, WaiverID: 7, WaiverNote: "Launch promo"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := applyFee(&Quote{Amount: tt.amount, Total: tt.amount}, tt.fee, tt.waiver)
+			if *got != tt.want {
+				t.Errorf("applyFee() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package float
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/notification"
+)
+
+// Float levels, from healthy to exhausted
+const (
+	LevelOK       = "ok"
+	LevelWarning  = "warning"
+	LevelCritical = "critical"
+	LevelUnknown  = "unknown"
+)
+
+// defaultAlertRepeat is how long an alert at the same level is suppressed when FLOAT_ALERT_REPEAT_MINUTES is not set
+const defaultAlertRepeat = time.Hour
+
+// Source is a provider wallet whose float is monitored
+type Source struct {
+	Name    string
+	Balance func() (float64, error)
+}
+
+// Thresholds are the balances below which a provider's float raises a warning or critical alert
+type Thresholds struct {
+	Warning  float64 `json:"warning"`
+	Critical float64 `json:"critical"`
+}
+
+// Status represents the latest known float of a provider
+type Status struct {
+	Provider   string     `json:"provider"`
+	Balance    *float64   `json:"balance"`
+	Level      string     `json:"level"`
+	Error      string     `json:"error,omitempty"`
+	Thresholds Thresholds `json:"thresholds"`
+	CheckedAt  time.Time  `json:"checked_at"`
+}
+
+// Response represents the generic response structure
+type Response struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message"`
+	Result  interface{} `json:"result,omitempty"`
+}
+
+// FloatStatusHandler returns the latest float of every monitored provider
+func FloatStatusHandler(c *gin.Context) {
+	statuses, err := FetchStatuses()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch float status: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Float status fetched successfully",
+		Result:  statuses,
+	})
+}
+
+// FloatHistoryHandler returns the recorded balances of a provider, newest first
+func FloatHistoryHandler(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Limit must be between 1 and 1000",
+		})
+		return
+	}
+
+	history, err := FetchHistory(c.Param("provider"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch float history: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Float history fetched successfully",
+		Result:  history,
+	})
+}
+
+// StartMonitor polls the float of each source every interval in a background goroutine
+func StartMonitor(interval time.Duration, sources ...Source) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for _, source := range sources {
+				Check(source)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Check polls a source's balance, records it and raises an alert if it has crossed a threshold
+func Check(source Source) {
+	status := Status{
+		Provider:   source.Name,
+		Thresholds: thresholds(source.Name),
+		CheckedAt:  time.Now(),
+	}
+
+	balance, err := source.Balance()
+	if err != nil {
+		status.Level = LevelUnknown
+		status.Error = err.Error()
+	} else {
+		status.Balance = &balance
+		status.Level = level(balance, status.Thresholds)
+	}
+
+	if err := record(status); err != nil {
+		log.Printf("Failed to record %s float: %v\n", source.Name, err)
+	}
+	alert(status)
+}
+
+// ReportLow records that a provider's float was too low to cover a purchase and raises a critical alert
+func ReportLow(provider string, balance float64) {
+	status := Status{
+		Provider:   provider,
+		Balance:    &balance,
+		Level:      LevelCritical,
+		Error:      "insufficient float for a purchase",
+		Thresholds: thresholds(provider),
+		CheckedAt:  time.Now(),
+	}
+
+	if err := record(status); err != nil {
+		log.Printf("Failed to record %s float: %v\n", provider, err)
+	}
+	alert(status)
+}
+
+// FetchStatuses returns the latest recorded float of every provider
+func FetchStatuses() ([]Status, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		SELECT DISTINCT ON (provider) provider, balance, level, COALESCE(error, ''), checked_at
+		FROM float_balance
+		ORDER BY provider, checked_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := []Status{}
+	for rows.Next() {
+		status, err := scanStatus(rows)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, *status)
+	}
+	return statuses, rows.Err()
+}
+
+// FetchHistory returns up to limit recorded balances of a provider, newest first
+func FetchHistory(provider string, limit int) ([]Status, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		SELECT provider, balance, level, COALESCE(error, ''), checked_at
+		FROM float_balance
+		WHERE provider = $1
+		ORDER BY checked_at DESC
+		LIMIT $2
+	`, provider, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []Status{}
+	for rows.Next() {
+		status, err := scanStatus(rows)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, *status)
+	}
+	return history, rows.Err()
+}
+
+// record saves a balance check to the float history
+func record(status Status) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	_, err = db.Exec(context.Background(), `
+		INSERT INTO float_balance (provider, balance, level, error, checked_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+	`, status.Provider, status.Balance, status.Level, status.Error, status.CheckedAt)
+	return err
+}
+
+// alert notifies the admin when a provider's float level changes. An alert at the same level is
+// only repeated after FLOAT_ALERT_REPEAT_MINUTES, and a recovery is announced once.
+func alert(status Status) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		log.Printf("Failed to connect to database: %v\n", err)
+		return
+	}
+	defer db.Close(context.Background())
+
+	var lastLevel string
+	var alertedAt time.Time
+	err = db.QueryRow(context.Background(),
+		"SELECT level, alerted_at FROM float_alert WHERE provider = $1", status.Provider).Scan(&lastLevel, &alertedAt)
+	if err != nil && err != pgx.ErrNoRows {
+		log.Printf("Failed to fetch %s float alert: %v\n", status.Provider, err)
+		return
+	}
+	if err == pgx.ErrNoRows {
+		lastLevel = LevelOK
+	}
+
+	if status.Level == lastLevel && (status.Level == LevelOK || time.Since(alertedAt) < alertRepeat()) {
+		return
+	}
+
+	// Claim the alert so other instances polling the same provider do not send it too
+	result, err := db.Exec(context.Background(), `
+		INSERT INTO float_alert (provider, level, alerted_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (provider) DO UPDATE SET level = EXCLUDED.level, alerted_at = EXCLUDED.alerted_at
+		WHERE float_alert.level <> EXCLUDED.level OR float_alert.alerted_at < NOW() - $3 * INTERVAL '1 minute'
+	`, status.Provider, status.Level, alertRepeat().Minutes())
+	if err != nil {
+		log.Printf("Failed to save %s float alert: %v\n", status.Provider, err)
+		return
+	}
+	if result.RowsAffected() == 0 {
+		return
+	}
+
+	subject, body := alertMessage(status, lastLevel)
+	if err := notification.SendEmail(os.Getenv("ADMIN_EMAIL"), subject, body); err != nil {
+		fmt.Println("Failed to send email:", err)
+	}
+}
+
+// alertMessage builds the email sent for a float level change
+func alertMessage(status Status, lastLevel string) (string, string) {
+	provider := providerLabel(status.Provider)
+	balance := "unavailable"
+	if status.Balance != nil {
+		balance = fmt.Sprintf("NGN %.2f", *status.Balance)
+	}
+
+	switch status.Level {
+	case LevelOK:
+		return provider + " float recovered", fmt.Sprintf(
+			"The %s wallet balance is back above the warning threshold of NGN %.2f after being %s.\nBalance: %s",
+			provider, status.Thresholds.Warning, lastLevel, balance)
+	case LevelUnknown:
+		return provider + " float check failed", fmt.Sprintf(
+			"The %s wallet balance could not be checked.\nError: %s", provider, status.Error)
+	case LevelWarning:
+		return "Low balance warning: " + provider, fmt.Sprintf(
+			"The %s wallet balance is below the warning threshold of NGN %.2f. Please top up the wallet.\nBalance: %s",
+			provider, status.Thresholds.Warning, balance)
+	}
+	return "Insufficient Balance Alert: " + provider, fmt.Sprintf(
+		"The balance in the %s wallet is insufficient to process transactions. Please top up the wallet.\nBalance: %s",
+		provider, balance)
+}
+
+// level grades a balance against the thresholds
+func level(balance float64, thresholds Thresholds) string {
+	switch {
+	case balance < thresholds.Critical:
+		return LevelCritical
+	case balance < thresholds.Warning:
+		return LevelWarning
+	}
+	return LevelOK
+}
+
+// thresholds returns the provider's thresholds, FLOAT_WARNING_<PROVIDER> and FLOAT_CRITICAL_<PROVIDER>,
+// falling back to FLOAT_WARNING_THRESHOLD and FLOAT_CRITICAL_THRESHOLD
+func thresholds(provider string) Thresholds {
+	name := strings.ToUpper(provider)
+	return Thresholds{
+		Warning:  envAmount("FLOAT_WARNING_"+name, envAmount("FLOAT_WARNING_THRESHOLD", 50000)),
+		Critical: envAmount("FLOAT_CRITICAL_"+name, envAmount("FLOAT_CRITICAL_THRESHOLD", 10000)),
+	}
+}
+
+// alertRepeat returns how long an alert at the same level is suppressed
+func alertRepeat() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("FLOAT_ALERT_REPEAT_MINUTES"))
+	if err != nil || minutes <= 0 {
+		return defaultAlertRepeat
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// providerLabel capitalises a provider name for alert emails
+func providerLabel(provider string) string {
+	if provider == "" {
+		return provider
+	}
+	return strings.ToUpper(provider[:1]) + provider[1:]
+}
+
+func envAmount(key string, fallback float64) float64 {
+	amount, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+	return amount
+}
+
+func scanStatus(row pgx.Row) (*Status, error) {
+	var status Status
+	if err := row.Scan(&status.Provider, &status.Balance, &status.Level, &status.Error, &status.CheckedAt); err != nil {
+		return nil, err
+	}
+	status.Thresholds = thresholds(status.Provider)
+	return &status, nil
+}
@@ -0,0 +1,111 @@
+package float
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// DojahBalanceResponse represents the response payload for the Dojah balance
+type DojahBalanceResponse struct {
+	Entity struct {
+		WalletBalance string `json:"wallet_balance"`
+	} `json:"entity"`
+	Error string `json:"error"`
+}
+
+// PaystackBalanceResponse represents the response payload for the Paystack balance
+type PaystackBalanceResponse struct {
+	Status  bool   `json:"status"`
+	Message string `json:"message"`
+	Data    []struct {
+		Currency string `json:"currency"`
+		Balance  int64  `json:"balance"`
+	} `json:"data"`
+}
+
+// Paystack returns the Paystack transfer balance as a monitored source
+func Paystack() Source {
+	return Source{Name: "paystack", Balance: PaystackBalance}
+}
+
+// DojahBalance returns the balance of the Dojah wallet used for airtime and data
+func DojahBalance() (float64, error) {
+	req, err := http.NewRequest("GET", "https://api.dojah.io/api/v1/balance", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("AppId", os.Getenv("DOJAH_APP_ID"))
+	req.Header.Set("Authorization", os.Getenv("DOJAH_SECRET_KEY"))
+	req.Header.Set("accept", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to retrieve balance: %s", string(body))
+	}
+
+	var balanceResponse DojahBalanceResponse
+	if err := json.Unmarshal(body, &balanceResponse); err != nil {
+		return 0, err
+	}
+
+	if balanceResponse.Error != "" {
+		return 0, fmt.Errorf("error from Dojah: %s", balanceResponse.Error)
+	}
+
+	return strconv.ParseFloat(balanceResponse.Entity.WalletBalance, 64)
+}
+
+// PaystackBalance returns the naira balance available for Paystack transfers
+func PaystackBalance() (float64, error) {
+	req, err := http.NewRequest("GET", "https://api.paystack.co/balance", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("PAYSTACK_SECRET_KEY"))
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	var balanceResponse PaystackBalanceResponse
+	if err := json.Unmarshal(body, &balanceResponse); err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode != http.StatusOK || !balanceResponse.Status {
+		return 0, fmt.Errorf("failed to retrieve balance: %s", balanceResponse.Message)
+	}
+
+	for _, balance := range balanceResponse.Data {
+		if balance.Currency == "NGN" {
+			// Paystack reports balances in kobo
+			return float64(balance.Balance) / 100, nil
+		}
+	}
+	return 0, fmt.Errorf("no NGN balance returned")
+}
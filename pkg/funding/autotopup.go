@@ -0,0 +1,366 @@
+package funding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/notification"
+)
+
+// Auto top-up rule statuses
+const (
+	RuleActive = "active"
+	// RulePaused marks a rule stopped after repeated failed charges until the user saves it again
+	RulePaused   = "paused"
+	RuleDisabled = "disabled"
+)
+
+// maxAutoTopUpFailures is the number of consecutive failed charges after which a rule is paused
+const maxAutoTopUpFailures = 3
+
+// autoTopUpCooldown is the minimum time between two charges of the same rule, so a charge that is still
+// being processed is not repeated on the next run
+const autoTopUpCooldown = time.Hour
+
+// AutoTopUpRule charges a saved card whenever the wallet balance drops below a threshold
+type AutoTopUpRule struct {
+	RuleID              int64      `json:"rule_id"`
+	UserID              int        `json:"user_id"`
+	CardID              int64      `json:"card_id"`
+	Threshold           float64    `json:"threshold"`
+	Amount              float64    `json:"amount"`
+	DailyCap            float64    `json:"daily_cap"`
+	Status              string     `json:"status"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastAttemptAt       *time.Time `json:"last_attempt_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// AutoTopUpRequest represents the request payload for saving an auto top-up rule
+type AutoTopUpRequest struct {
+	UserID    int     `json:"user_id"`
+	CardID    int64   `json:"card_id"`
+	Threshold float64 `json:"threshold"` // top up when the balance drops below this
+	Amount    float64 `json:"amount"`    // amount charged per top-up
+	DailyCap  float64 `json:"daily_cap"` // most that can be charged automatically in a day
+}
+
+const autoTopUpColumns = `rule_id, user_id, card_id, threshold, amount, daily_cap, status, consecutive_failures,
+	COALESCE(last_error, ''), last_attempt_at, created_at`
+
+// SaveAutoTopUpHandler creates or replaces the user's auto top-up rule. Saving a paused rule resumes it.
+func SaveAutoTopUpHandler(c *gin.Context) {
+	var request AutoTopUpRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Malformed JSON request: " + err.Error(),
+		})
+		return
+	}
+
+	if request.UserID <= 0 || request.Amount <= 0 || request.Threshold < 0 {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "user_id, a positive amount and a threshold of zero or more are required",
+		})
+		return
+	}
+	if request.DailyCap < request.Amount {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "daily_cap must be at least the top-up amount",
+		})
+		return
+	}
+
+	if _, err := fetchCard(request.UserID, request.CardID); err != nil {
+		respondFundingError(c, err)
+		return
+	}
+
+	rule, err := saveAutoTopUpRule(request)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to save auto top-up: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Auto top-up saved successfully",
+		Result:  rule,
+	})
+}
+
+// GetAutoTopUpHandler returns the user's auto top-up rule
+func GetAutoTopUpHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user_id parameter",
+		})
+		return
+	}
+
+	rule, err := fetchAutoTopUpRule(userID)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Auto top-up is not set up",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch auto top-up: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Auto top-up retrieved successfully",
+		Result:  rule,
+	})
+}
+
+// DisableAutoTopUpHandler turns off the user's auto top-up
+func DisableAutoTopUpHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user_id parameter",
+		})
+		return
+	}
+
+	rule, err := setAutoTopUpStatus(userID, RuleDisabled)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Auto top-up is not set up",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to disable auto top-up: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Auto top-up disabled successfully",
+		Result:  rule,
+	})
+}
+
+// StartAutoTopUp charges the saved cards of wallets below their auto top-up threshold every interval
+// in a background goroutine
+func StartAutoTopUp(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := runAutoTopUps(); err != nil {
+				log.Printf("Auto top-up run failed: %v\n", err)
+			}
+		}
+	}()
+}
+
+// runAutoTopUps claims the active rules whose wallet is below the threshold and charges each of them
+func runAutoTopUps() error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		UPDATE auto_topup_rule SET last_attempt_at = NOW(), updated_at = NOW()
+		WHERE rule_id IN (
+			SELECT r.rule_id
+			FROM auto_topup_rule r
+			JOIN wallet w ON w.user_id = r.user_id AND w.deleted = false
+			WHERE r.status = $1 AND w.current_balance < r.threshold
+				AND (r.last_attempt_at IS NULL OR r.last_attempt_at <= NOW() - $2 * INTERVAL '1 second')
+			LIMIT 50
+			FOR UPDATE OF r SKIP LOCKED
+		)
+		RETURNING `+autoTopUpColumns, RuleActive, autoTopUpCooldown.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to claim auto top-ups: %w", err)
+	}
+
+	var due []*AutoTopUpRule
+	for rows.Next() {
+		rule, err := scanAutoTopUpRule(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, rule)
+	}
+	rows.Close()
+
+	for _, rule := range due {
+		if err := runAutoTopUp(rule); err != nil {
+			log.Printf("Auto top-up %d failed: %v\n", rule.RuleID, err)
+		}
+	}
+	return nil
+}
+
+// runAutoTopUp charges the rule's card, unless that would take today's automatic charges over the daily cap,
+// and records the outcome
+func runAutoTopUp(rule *AutoTopUpRule) error {
+	charged, err := autoChargedToday(rule.UserID)
+	if err != nil {
+		return err
+	}
+	if charged+rule.Amount > rule.DailyCap {
+		log.Printf("Auto top-up %d skipped, NGN %.2f of NGN %.2f daily cap already charged\n", rule.RuleID, charged, rule.DailyCap)
+		return nil
+	}
+
+	funding, err := chargeCard(rule.UserID, rule.CardID, rule.Amount, ChannelAutoTopUp)
+	if err == nil && funding.Status == StatusFailed {
+		err = errors.New(funding.FailureReason)
+	}
+	if err != nil {
+		return recordAutoTopUpFailure(rule, err)
+	}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	_, err = db.Exec(context.Background(),
+		"UPDATE auto_topup_rule SET consecutive_failures = 0, last_error = NULL, updated_at = NOW() WHERE rule_id = $1", rule.RuleID)
+	return err
+}
+
+// recordAutoTopUpFailure counts a failed charge, pausing the rule after too many in a row, and tells the user
+func recordAutoTopUpFailure(rule *AutoTopUpRule, chargeErr error) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	var failures int
+	var status, email string
+	err = db.QueryRow(context.Background(), `
+		UPDATE auto_topup_rule r
+		SET consecutive_failures = r.consecutive_failures + 1, last_error = $2, updated_at = NOW(),
+			status = CASE WHEN r.consecutive_failures + 1 >= $3 THEN $4 ELSE r.status END
+		FROM users u
+		WHERE r.rule_id = $1 AND u.user_id = r.user_id
+		RETURNING r.consecutive_failures, r.status, u.email
+	`, rule.RuleID, chargeErr.Error(), maxAutoTopUpFailures, RulePaused).Scan(&failures, &status, &email)
+	if err != nil {
+		return fmt.Errorf("failed to record auto top-up failure: %w", err)
+	}
+
+	body := fmt.Sprintf("We could not top up your wallet with NGN %.2f from your saved card.\nReason: %s\n", rule.Amount, chargeErr)
+	if status == RulePaused {
+		body += fmt.Sprintf("Auto top-up has been paused after %d failed attempts. Update your card or save the rule again to resume it.\n", failures)
+	}
+	if err := notification.SendEmail(email, "Auto top-up failed", body); err != nil {
+		fmt.Println("Failed to send email:", err)
+	}
+	return chargeErr
+}
+
+// autoChargedToday returns how much has been charged automatically for the user today, counting charges
+// still being processed
+func autoChargedToday(userID int) (float64, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close(context.Background())
+
+	var charged float64
+	err = db.QueryRow(context.Background(), `
+		SELECT COALESCE(SUM(amount), 0)
+		FROM card_funding
+		WHERE user_id = $1 AND channel = $2 AND status <> $3 AND created_at >= date_trunc('day', NOW())
+	`, userID, ChannelAutoTopUp, StatusFailed).Scan(&charged)
+	return charged, err
+}
+
+// saveAutoTopUpRule creates or replaces the user's rule and makes it active
+func saveAutoTopUpRule(request AutoTopUpRequest) (*AutoTopUpRule, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	return scanAutoTopUpRule(db.QueryRow(context.Background(), `
+		INSERT INTO auto_topup_rule (user_id, card_id, threshold, amount, daily_cap, status, consecutive_failures, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, 0, NOW(), NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET card_id = EXCLUDED.card_id, threshold = EXCLUDED.threshold, amount = EXCLUDED.amount,
+			daily_cap = EXCLUDED.daily_cap, status = EXCLUDED.status, consecutive_failures = 0, last_error = NULL,
+			updated_at = NOW()
+		RETURNING `+autoTopUpColumns,
+		request.UserID, request.CardID, request.Threshold, request.Amount, request.DailyCap, RuleActive))
+}
+
+// fetchAutoTopUpRule returns the user's rule
+func fetchAutoTopUpRule(userID int) (*AutoTopUpRule, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	return scanAutoTopUpRule(db.QueryRow(context.Background(),
+		"SELECT "+autoTopUpColumns+" FROM auto_topup_rule WHERE user_id = $1", userID))
+}
+
+// setAutoTopUpStatus updates the status of the user's rule
+func setAutoTopUpStatus(userID int, status string) (*AutoTopUpRule, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	return scanAutoTopUpRule(db.QueryRow(context.Background(), `
+		UPDATE auto_topup_rule SET status = $2, updated_at = NOW()
+		WHERE user_id = $1
+		RETURNING `+autoTopUpColumns, userID, status))
+}
+
+func scanAutoTopUpRule(row pgx.Row) (*AutoTopUpRule, error) {
+	var rule AutoTopUpRule
+	err := row.Scan(&rule.RuleID, &rule.UserID, &rule.CardID, &rule.Threshold, &rule.Amount, &rule.DailyCap, &rule.Status,
+		&rule.ConsecutiveFailures, &rule.LastError, &rule.LastAttemptAt, &rule.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
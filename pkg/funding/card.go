@@ -0,0 +1,799 @@
+package funding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/kyc"
+	"go_code/pkg/ledger"
+	"go_code/pkg/transaction"
+)
+
+// Card funding statuses
+const (
+	StatusPending = "pending"
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+	// StatusHeld marks a charge that would exceed the user's KYC balance limit and is held until they upgrade
+	StatusHeld = "held"
+)
+
+// Card funding channels
+const (
+	ChannelCheckout  = "checkout"
+	ChannelSavedCard = "saved_card"
+	ChannelAutoTopUp = "auto_topup"
+)
+
+// chargeRequeryDelay gives Paystack time to record a saved card charge before a charge it has no record of is failed
+const chargeRequeryDelay = 30 * time.Minute
+
+// checkoutTimeout is how long a customer has to pay a checkout before the top-up is expired. A checkout
+// paid after it expired is still credited when Paystack reports the charge.
+const checkoutTimeout = time.Hour
+
+// ErrCardNotFound is returned when a saved card does not exist or belongs to another user
+var ErrCardNotFound = errors.New("card not found")
+
+// CardFunding represents a top-up of a wallet by card
+type CardFunding struct {
+	FundingID        int64      `json:"funding_id"`
+	UserID           int        `json:"user_id"`
+	Reference        string     `json:"reference"`
+	Amount           float64    `json:"amount"`
+	Status           string     `json:"status"`
+	Channel          string     `json:"channel"`
+	CardID           int64      `json:"card_id,omitempty"`
+	AuthorizationURL string     `json:"authorization_url,omitempty"`
+	AccessCode       string     `json:"access_code,omitempty"`
+	FailureReason    string     `json:"failure_reason,omitempty"`
+	TransactionID    int64      `json:"transaction_id,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	CompletedAt      *time.Time `json:"completed_at,omitempty"`
+}
+
+// Card represents a reusable card authorization saved from a successful charge
+type Card struct {
+	CardID            int64     `json:"card_id"`
+	UserID            int       `json:"user_id"`
+	AuthorizationCode string    `json:"-"`
+	Bin               string    `json:"bin"`
+	Last4             string    `json:"last4"`
+	ExpMonth          string    `json:"exp_month"`
+	ExpYear           string    `json:"exp_year"`
+	CardType          string    `json:"card_type"`
+	Bank              string    `json:"bank"`
+	Brand             string    `json:"brand"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// FundCardRequest represents the request payload for funding a wallet by card
+type FundCardRequest struct {
+	UserID int     `json:"user_id"`
+	Amount float64 `json:"amount"`
+	CardID int64   `json:"card_id"` // optional saved card to charge without checkout
+}
+
+// Response represents the generic response structure
+type Response struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message"`
+	Result  interface{} `json:"result,omitempty"`
+}
+
+const cardFundingColumns = `funding_id, user_id, reference, amount, status, channel, COALESCE(card_id, 0),
+	COALESCE(authorization_url, ''), COALESCE(access_code, ''), COALESCE(failure_reason, ''), COALESCE(transaction_id, 0),
+	created_at, completed_at`
+
+const cardColumns = `card_id, user_id, authorization_code, COALESCE(bin, ''), last4, exp_month, exp_year,
+	COALESCE(card_type, ''), COALESCE(bank, ''), COALESCE(brand, ''), created_at`
+
+// FundWalletHandler starts a card top-up. New cards are sent to Paystack checkout through the returned
+// authorization_url; saved cards are charged straight away.
+func FundWalletHandler(c *gin.Context) {
+	var request FundCardRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Malformed JSON request: " + err.Error(),
+		})
+		return
+	}
+	if request.UserID <= 0 || request.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "user_id and a positive amount are required",
+		})
+		return
+	}
+
+	var funding *CardFunding
+	var err error
+	if request.CardID != 0 {
+		funding, err = ChargeSavedCard(request.UserID, request.CardID, request.Amount)
+	} else {
+		funding, err = StartCheckout(request.UserID, request.Amount)
+	}
+	if err != nil {
+		respondFundingError(c, err)
+		return
+	}
+
+	switch funding.Status {
+	case StatusSuccess:
+		c.JSON(http.StatusOK, Response{
+			Status:  "success",
+			Message: "Wallet funded successfully",
+			Result:  funding,
+		})
+	case StatusFailed:
+		c.JSON(http.StatusPaymentRequired, Response{
+			Status:  "error",
+			Message: "Card charge failed: " + funding.FailureReason,
+			Result:  funding,
+		})
+	case StatusHeld:
+		c.JSON(http.StatusAccepted, Response{
+			Status:  "success",
+			Message: "Card charged, the deposit is held until your KYC tier allows it",
+			Result:  funding,
+		})
+	default:
+		message := "Complete the payment at the authorization URL"
+		if funding.Channel != ChannelCheckout {
+			message = "Card charge is being processed"
+		}
+		c.JSON(http.StatusAccepted, Response{
+			Status:  "success",
+			Message: message,
+			Result:  funding,
+		})
+	}
+}
+
+// VerifyCardFundingHandler is the Paystack checkout callback. It verifies the charge and credits the wallet
+// if that has not already happened through the webhook.
+func VerifyCardFundingHandler(c *gin.Context) {
+	reference := c.Query("reference")
+	if reference == "" {
+		reference = c.Query("trxref")
+	}
+	if reference == "" {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "reference is required",
+		})
+		return
+	}
+
+	funding, err := CompleteCardFunding(reference)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Card funding not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, Response{
+			Status:  "error",
+			Message: "Failed to verify card funding: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Card funding is " + funding.Status,
+		Result:  funding,
+	})
+}
+
+// SavedCardsHandler lists the cards the user can top up with in one click
+func SavedCardsHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user_id parameter",
+		})
+		return
+	}
+
+	cards, err := FetchCards(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch saved cards: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Saved cards retrieved successfully",
+		Result:  cards,
+	})
+}
+
+// DeleteCardHandler removes a saved card so it can no longer be charged
+func DeleteCardHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user_id parameter",
+		})
+		return
+	}
+	cardID, err := strconv.ParseInt(c.Param("card_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid card_id parameter",
+		})
+		return
+	}
+
+	if err := deleteCard(userID, cardID); err != nil {
+		respondFundingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Card removed successfully",
+	})
+}
+
+// SyncCardsHandler saves the reusable cards Paystack already holds for the user's customer, such as cards
+// used before card funding was available
+func SyncCardsHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user_id parameter",
+		})
+		return
+	}
+
+	cards, err := syncCards(userID)
+	if err != nil {
+		respondFundingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Saved cards synced successfully",
+		Result:  cards,
+	})
+}
+
+// respondFundingError writes a card funding failure as a JSON error response
+func respondFundingError(c *gin.Context, err error) {
+	var limitErr *kyc.LimitError
+	if errors.As(err, &limitErr) {
+		c.JSON(http.StatusForbidden, Response{
+			Status:  "error",
+			Message: err.Error(),
+			Result:  limitErr,
+		})
+		return
+	}
+
+	statusCode := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrCardNotFound), errors.Is(err, ledger.ErrWalletNotFound):
+		statusCode = http.StatusNotFound
+	}
+	c.JSON(statusCode, Response{
+		Status:  "error",
+		Message: err.Error(),
+	})
+}
+
+// StartCheckout records a pending card top-up and initialises its Paystack checkout
+func StartCheckout(userID int, amount float64) (*CardFunding, error) {
+	email, err := prepareFunding(userID, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	funding, err := createFunding(userID, amount, ChannelCheckout, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	checkout, err := initializeTransaction(email, funding.Reference, amount)
+	if err != nil {
+		failFunding(funding.Reference, err.Error())
+		return nil, fmt.Errorf("failed to initialise card payment: %w", err)
+	}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	return scanCardFunding(db.QueryRow(context.Background(), `
+		UPDATE card_funding SET authorization_url = $2, access_code = $3
+		WHERE reference = $1
+		RETURNING `+cardFundingColumns, funding.Reference, checkout.Data.AuthorizationURL, checkout.Data.AccessCode))
+}
+
+// ChargeSavedCard tops up the wallet by charging one of the user's saved cards
+func ChargeSavedCard(userID int, cardID int64, amount float64) (*CardFunding, error) {
+	return chargeCard(userID, cardID, amount, ChannelSavedCard)
+}
+
+// chargeCard charges a saved card under the given funding channel and completes the top-up
+func chargeCard(userID int, cardID int64, amount float64, channel string) (*CardFunding, error) {
+	email, err := prepareFunding(userID, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	card, err := fetchCard(userID, cardID)
+	if err != nil {
+		return nil, err
+	}
+
+	funding, err := createFunding(userID, amount, channel, card.CardID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = chargeAuthorization(email, card.AuthorizationCode, funding.Reference, amount)
+	var refused *paystackError
+	if errors.As(err, &refused) {
+		return failFunding(funding.Reference, err.Error())
+	}
+	if err != nil {
+		// Paystack may still have charged the card, so the top-up is settled by verifying it
+		log.Printf("Card charge %s outcome unknown: %v\n", funding.Reference, err)
+	}
+
+	completed, err := CompleteCardFunding(funding.Reference)
+	if err != nil {
+		// Left pending for the webhook or the charge requery
+		log.Printf("Failed to verify card charge %s: %v\n", funding.Reference, err)
+		return funding, nil
+	}
+	return completed, nil
+}
+
+// StartCardFundingRequery settles saved card charges whose outcome is still unknown every interval in a
+// background goroutine
+func StartCardFundingRequery(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := requeryPendingCharges(); err != nil {
+				log.Printf("Card funding requery failed: %v\n", err)
+			}
+		}
+	}()
+}
+
+// requeryPendingCharges verifies card top-ups that are still pending. Saved card charges Paystack has no
+// record of are failed once chargeRequeryDelay has passed, and checkouts the customer has not paid are
+// expired after checkoutTimeout.
+func requeryPendingCharges() error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		SELECT reference, channel, created_at FROM card_funding
+		WHERE status = $1 AND created_at > NOW() - INTERVAL '1 day'
+			AND created_at <= NOW() - CASE WHEN channel = $2 THEN $3 * INTERVAL '1 second' ELSE INTERVAL '2 minutes' END
+		ORDER BY created_at
+		LIMIT 50
+	`, StatusPending, ChannelCheckout, checkoutTimeout.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to fetch pending card charges: %w", err)
+	}
+
+	type pendingCharge struct {
+		reference string
+		channel   string
+		createdAt time.Time
+	}
+	var pending []pendingCharge
+	for rows.Next() {
+		var charge pendingCharge
+		if err := rows.Scan(&charge.reference, &charge.channel, &charge.createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, charge)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, charge := range pending {
+		funding, err := CompleteCardFunding(charge.reference)
+		var refused *paystackError
+		switch {
+		case charge.channel == ChannelCheckout:
+			if err == nil && funding.Status == StatusPending {
+				_, err = failFunding(charge.reference, "Checkout was not completed")
+			}
+		case errors.As(err, &refused) && time.Since(charge.createdAt) >= chargeRequeryDelay:
+			_, err = failFunding(charge.reference, "Paystack has no record of the charge")
+		}
+		if err != nil && err != pgx.ErrNoRows {
+			log.Printf("Failed to requery card charge %s: %v\n", charge.reference, err)
+		}
+	}
+	return nil
+}
+
+// IsCardFunding reports whether a Paystack reference belongs to a card top-up
+func IsCardFunding(reference string) (bool, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return false, err
+	}
+	defer db.Close(context.Background())
+
+	var exists bool
+	err = db.QueryRow(context.Background(),
+		"SELECT EXISTS (SELECT 1 FROM card_funding WHERE reference = $1)", reference).Scan(&exists)
+	return exists, err
+}
+
+// CompleteCardFunding verifies a card top-up with Paystack and credits the wallet. It is called from both the
+// checkout callback and the charge.success webhook, and the funding row lock makes sure the wallet is
+// credited once. A failed top-up that was never credited is reopened when Paystack later reports it paid,
+// since a customer can retry or finish a checkout after it expired. Reusable cards are saved for one-click top-ups.
+func CompleteCardFunding(reference string) (*CardFunding, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	existing, err := scanCardFunding(db.QueryRow(context.Background(),
+		"SELECT "+cardFundingColumns+" FROM card_funding WHERE reference = $1", reference))
+	if err != nil {
+		return nil, err
+	}
+	if !settleable(existing) {
+		return existing, nil
+	}
+
+	charge, err := verifyTransaction(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	funding, err := scanCardFunding(tx.QueryRow(context.Background(),
+		"SELECT "+cardFundingColumns+" FROM card_funding WHERE reference = $1 FOR UPDATE", reference))
+	if err != nil {
+		return nil, err
+	}
+	if !settleable(funding) || (funding.Status == StatusFailed && charge.Status != "success") {
+		return funding, nil
+	}
+
+	status, failureReason := StatusPending, ""
+	var transactionID int64
+	switch charge.Status {
+	case "success":
+		if charge.Currency != "NGN" || int64(charge.Amount) != toKobo(funding.Amount) {
+			status = StatusFailed
+			failureReason = fmt.Sprintf("Paystack charged %s %.2f, expected NGN %.2f", charge.Currency, charge.Amount/100, funding.Amount)
+			log.Printf("Card funding %s amount mismatch: %s\n", reference, failureReason)
+			break
+		}
+
+		err = kyc.CheckCredit(funding.UserID, funding.Amount)
+		var limitErr *kyc.LimitError
+		if errors.As(err, &limitErr) {
+			if err := kyc.HoldCredit(tx, funding.UserID, reference, funding.Amount, limitErr.Error()); err != nil {
+				return nil, err
+			}
+			status = StatusHeld
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		transactionID, err = ledger.Credit(tx, ledger.Entry{
+			UserID:      funding.UserID,
+			Amount:      funding.Amount,
+			Reference:   reference,
+			Narration:   "Wallet top-up by card",
+			AccountName: charge.Authorization.AccountName,
+			BankName:    charge.Authorization.Bank,
+		})
+		if err != nil {
+			return nil, err
+		}
+		status = StatusSuccess
+	case "failed", "reversed":
+		status = StatusFailed
+		failureReason = charge.GatewayResponse
+		if failureReason == "" {
+			failureReason = "Payment " + charge.Status
+		}
+	default:
+		// Abandoned and ongoing checkouts have not been paid yet and may still be
+		return funding, nil
+	}
+
+	if status != StatusFailed && charge.Authorization.Reusable && charge.Authorization.Channel == "card" {
+		if err := saveCard(tx, funding.UserID, charge.Authorization); err != nil {
+			return nil, err
+		}
+	}
+
+	funding, err = scanCardFunding(tx.QueryRow(context.Background(), `
+		UPDATE card_funding
+		SET status = $2, failure_reason = NULLIF($3, ''), transaction_id = NULLIF($4, 0), completed_at = NOW()
+		WHERE reference = $1
+		RETURNING `+cardFundingColumns, reference, status, failureReason, transactionID))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return nil, err
+	}
+	return funding, nil
+}
+
+// settleable reports whether a top-up can still be settled by a verified charge
+func settleable(funding *CardFunding) bool {
+	return funding.Status == StatusPending || (funding.Status == StatusFailed && funding.TransactionID == 0)
+}
+
+// FetchCards returns the user's saved cards, most recent first
+func FetchCards(userID int) ([]Card, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(),
+		"SELECT "+cardColumns+" FROM card_authorization WHERE user_id = $1 AND deleted = false ORDER BY created_at DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cards := []Card{}
+	for rows.Next() {
+		card, err := scanCard(rows)
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, *card)
+	}
+	return cards, rows.Err()
+}
+
+// syncCards saves the reusable card authorizations on the user's Paystack customer and returns their saved cards
+func syncCards(userID int) ([]Card, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	var customerCode string
+	err = db.QueryRow(context.Background(),
+		"SELECT customer_code FROM wallet WHERE user_id = $1 AND deleted = false ORDER BY wallet_id LIMIT 1", userID).Scan(&customerCode)
+	if err == pgx.ErrNoRows {
+		return nil, ledger.ErrWalletNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	customer, err := transaction.FetchCustomer(customerCode)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	for _, authorization := range customer.Data.Authorizations {
+		if !authorization.Reusable || authorization.Channel != "card" {
+			continue
+		}
+		err := saveCard(tx, userID, PaystackAuthorization{
+			AuthorizationCode: authorization.AuthorizationCode,
+			Bin:               authorization.Bin,
+			Last4:             authorization.Last4,
+			ExpMonth:          authorization.ExpMonth,
+			ExpYear:           authorization.ExpYear,
+			Channel:           authorization.Channel,
+			CardType:          authorization.CardType,
+			Bank:              authorization.Bank,
+			CountryCode:       authorization.CountryCode,
+			Brand:             authorization.Brand,
+			Reusable:          authorization.Reusable,
+			Signature:         authorization.Signature,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return nil, err
+	}
+	return FetchCards(userID)
+}
+
+// prepareFunding checks the user can be credited with amount and returns the email Paystack charges them under
+func prepareFunding(userID int, amount float64) (string, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close(context.Background())
+
+	var email string
+	var hasWallet bool
+	err = db.QueryRow(context.Background(), `
+		SELECT u.email, EXISTS (SELECT 1 FROM wallet w WHERE w.user_id = u.user_id AND w.deleted = false)
+		FROM users u
+		WHERE u.user_id = $1 AND u.deleted = false
+	`, userID).Scan(&email, &hasWallet)
+	if err == pgx.ErrNoRows || (err == nil && !hasWallet) {
+		return "", ledger.ErrWalletNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := kyc.CheckCredit(userID, amount); err != nil {
+		return "", err
+	}
+	return email, nil
+}
+
+// createFunding records a pending card top-up under a new reference
+func createFunding(userID int, amount float64, channel string, cardID int64) (*CardFunding, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	return scanCardFunding(db.QueryRow(context.Background(), `
+		INSERT INTO card_funding (user_id, reference, amount, status, channel, card_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), NOW())
+		RETURNING `+cardFundingColumns, userID, ledger.NewReference("FUND"), amount, StatusPending, channel, cardID))
+}
+
+// failFunding marks a card top-up that could not be started or charged as failed
+func failFunding(reference, reason string) (*CardFunding, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	return scanCardFunding(db.QueryRow(context.Background(), `
+		UPDATE card_funding SET status = $2, failure_reason = $3, completed_at = NOW()
+		WHERE reference = $1 AND status = $4
+		RETURNING `+cardFundingColumns, reference, StatusFailed, reason, StatusPending))
+}
+
+// saveCard saves a reusable card authorization, refreshing it if the user has paid with the card before
+func saveCard(tx pgx.Tx, userID int, authorization PaystackAuthorization) error {
+	_, err := tx.Exec(context.Background(), `
+		INSERT INTO card_authorization (user_id, authorization_code, signature, bin, last4, exp_month, exp_year,
+			card_type, bank, brand, deleted, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, false, NOW())
+		ON CONFLICT (user_id, signature) DO UPDATE
+		SET authorization_code = EXCLUDED.authorization_code, exp_month = EXCLUDED.exp_month,
+			exp_year = EXCLUDED.exp_year, bank = EXCLUDED.bank, deleted = false
+	`, userID, authorization.AuthorizationCode, authorization.Signature, authorization.Bin, authorization.Last4,
+		authorization.ExpMonth, authorization.ExpYear, authorization.CardType, authorization.Bank, authorization.Brand)
+	if err != nil {
+		return fmt.Errorf("failed to save card: %w", err)
+	}
+	return nil
+}
+
+// fetchCard returns one of the user's saved cards
+func fetchCard(userID int, cardID int64) (*Card, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	card, err := scanCard(db.QueryRow(context.Background(),
+		"SELECT "+cardColumns+" FROM card_authorization WHERE card_id = $1 AND user_id = $2 AND deleted = false", cardID, userID))
+	if err == pgx.ErrNoRows {
+		return nil, ErrCardNotFound
+	}
+	return card, err
+}
+
+// deleteCard removes one of the user's saved cards and disables any auto top-up charging it
+func deleteCard(userID int, cardID int64) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	result, err := tx.Exec(context.Background(),
+		"UPDATE card_authorization SET deleted = true WHERE card_id = $1 AND user_id = $2 AND deleted = false", cardID, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrCardNotFound
+	}
+
+	_, err = tx.Exec(context.Background(),
+		"UPDATE auto_topup_rule SET status = $2, updated_at = NOW() WHERE card_id = $1 AND status <> $2", cardID, RuleDisabled)
+	if err != nil {
+		return fmt.Errorf("failed to disable auto top-up: %w", err)
+	}
+
+	return tx.Commit(context.Background())
+}
+
+func scanCardFunding(row pgx.Row) (*CardFunding, error) {
+	var funding CardFunding
+	err := row.Scan(&funding.FundingID, &funding.UserID, &funding.Reference, &funding.Amount, &funding.Status, &funding.Channel,
+		&funding.CardID, &funding.AuthorizationURL, &funding.AccessCode, &funding.FailureReason, &funding.TransactionID,
+		&funding.CreatedAt, &funding.CompletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &funding, nil
+}
+
+func scanCard(row pgx.Row) (*Card, error) {
+	var card Card
+	err := row.Scan(&card.CardID, &card.UserID, &card.AuthorizationCode, &card.Bin, &card.Last4, &card.ExpMonth,
+		&card.ExpYear, &card.CardType, &card.Bank, &card.Brand, &card.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
@@ -0,0 +1,169 @@
+package funding
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// PaystackAuthorization represents the card details Paystack returns with a charge
+type PaystackAuthorization struct {
+	AuthorizationCode string `json:"authorization_code"`
+	Bin               string `json:"bin"`
+	Last4             string `json:"last4"`
+	ExpMonth          string `json:"exp_month"`
+	ExpYear           string `json:"exp_year"`
+	Channel           string `json:"channel"`
+	CardType          string `json:"card_type"`
+	Bank              string `json:"bank"`
+	CountryCode       string `json:"country_code"`
+	Brand             string `json:"brand"`
+	Reusable          bool   `json:"reusable"`
+	Signature         string `json:"signature"`
+	AccountName       string `json:"account_name"`
+}
+
+// PaystackCharge represents a Paystack transaction as returned by verify and charge_authorization
+type PaystackCharge struct {
+	Status          string                `json:"status"`
+	Reference       string                `json:"reference"`
+	Amount          float64               `json:"amount"`
+	Currency        string                `json:"currency"`
+	Channel         string                `json:"channel"`
+	GatewayResponse string                `json:"gateway_response"`
+	PaidAt          string                `json:"paid_at"`
+	Authorization   PaystackAuthorization `json:"authorization"`
+}
+
+// initializeResponse represents the response payload of a Paystack transaction initialisation
+type initializeResponse struct {
+	Status  bool   `json:"status"`
+	Message string `json:"message"`
+	Data    struct {
+		AuthorizationURL string `json:"authorization_url"`
+		AccessCode       string `json:"access_code"`
+		Reference        string `json:"reference"`
+	} `json:"data"`
+}
+
+// chargeResponse represents the response payload of a Paystack verify or charge_authorization call
+type chargeResponse struct {
+	Status  bool           `json:"status"`
+	Message string         `json:"message"`
+	Data    PaystackCharge `json:"data"`
+}
+
+// paystackError is returned when Paystack answers with an error, so the request was certainly not carried out
+type paystackError struct {
+	message string
+}
+
+func (e *paystackError) Error() string {
+	return "Error from Paystack: " + e.message
+}
+
+// initializeTransaction starts a Paystack checkout for a card payment of amount naira
+func initializeTransaction(email, reference string, amount float64) (*initializeResponse, error) {
+	data := map[string]interface{}{
+		"email":     email,
+		"amount":    toKobo(amount),
+		"reference": reference,
+		"channels":  []string{"card"},
+	}
+	if callbackURL := os.Getenv("PAYSTACK_CALLBACK_URL"); callbackURL != "" {
+		data["callback_url"] = callbackURL
+	}
+
+	var result initializeResponse
+	if err := paystackRequest("POST", "/transaction/initialize", data, &result); err != nil {
+		return nil, err
+	}
+	if !result.Status {
+		return nil, &paystackError{message: "initialising transaction: " + result.Message}
+	}
+	return &result, nil
+}
+
+// chargeAuthorization charges a saved card without the customer going through checkout again
+func chargeAuthorization(email, authorizationCode, reference string, amount float64) (*PaystackCharge, error) {
+	data := map[string]interface{}{
+		"email":              email,
+		"authorization_code": authorizationCode,
+		"amount":             toKobo(amount),
+		"reference":          reference,
+	}
+
+	var result chargeResponse
+	if err := paystackRequest("POST", "/transaction/charge_authorization", data, &result); err != nil {
+		return nil, err
+	}
+	if !result.Status {
+		return nil, &paystackError{message: "charging card: " + result.Message}
+	}
+	return &result.Data, nil
+}
+
+// verifyTransaction fetches the outcome of a Paystack transaction
+func verifyTransaction(reference string) (*PaystackCharge, error) {
+	var result chargeResponse
+	if err := paystackRequest("GET", "/transaction/verify/"+url.PathEscape(reference), nil, &result); err != nil {
+		return nil, err
+	}
+	if !result.Status {
+		return nil, &paystackError{message: "verifying transaction: " + result.Message}
+	}
+	return &result.Data, nil
+}
+
+// paystackRequest calls a Paystack endpoint and decodes the response into result. A *paystackError is returned
+// when Paystack refuses the request.
+func paystackRequest(method, path string, payload interface{}, result interface{}) error {
+	requestBody := bytes.NewBuffer(nil)
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		requestBody = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequest(method, "https://api.paystack.co"+path, requestBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("PAYSTACK_SECRET_KEY"))
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// A 4xx is an explicit refusal; after a 5xx or gateway error the request may still have been carried out
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return &paystackError{message: string(body)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Paystack returned %d: %s", resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, result)
+}
+
+// toKobo converts a naira amount to the kobo Paystack expects
+func toKobo(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
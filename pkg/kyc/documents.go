@@ -0,0 +1,352 @@
+package kyc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/auth"
+	"go_code/pkg/docstore"
+)
+
+// KYC document kinds
+const (
+	DocumentPhotoID = "photo_id"
+	DocumentSelfie  = "selfie"
+)
+
+// Default retention periods when KYC_DOCUMENT_RETENTION_DAYS and KYC_REJECTED_RETENTION_DAYS are not set
+const (
+	defaultDocumentRetentionDays = 5 * 365
+	defaultRejectedRetentionDays = 90
+)
+
+// Document represents a stored KYC artefact. The content itself is only returned by DocumentHandler.
+type Document struct {
+	DocumentID     int64     `json:"document_id"`
+	UserID         int       `json:"user_id"`
+	VerificationID int64     `json:"verification_id"`
+	Kind           string    `json:"kind"`
+	ContentType    string    `json:"content_type"`
+	Size           int       `json:"size"`
+	SHA256         string    `json:"sha256"`
+	CreatedAt      time.Time `json:"created_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+var (
+	documentStoreOnce sync.Once
+	documentStore     docstore.Store
+	documentStoreErr  error
+)
+
+// VerificationDocumentsHandler lists the documents stored for a photo ID verification
+func VerificationDocumentsHandler(c *gin.Context) {
+	verificationID, err := strconv.ParseInt(c.Param("verification_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid verification ID",
+		})
+		return
+	}
+
+	documents, err := fetchDocuments(verificationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch documents: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Documents fetched successfully",
+		Result:  documents,
+	})
+}
+
+// DocumentHandler returns the decrypted content of a KYC document and records who accessed it
+func DocumentHandler(c *gin.Context) {
+	documentID, err := strconv.ParseInt(c.Param("document_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid document ID",
+		})
+		return
+	}
+
+	staff, _ := auth.StaffFromContext(c)
+	document, data, err := readDocument(documentID, staff.Name)
+	if err == pgx.ErrNoRows || err == docstore.ErrNotFound {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Document not found or past its retention period",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to read document: " + err.Error(),
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, document.ContentType, data)
+}
+
+// StartDocumentRetention deletes KYC documents past their retention period every interval in a background goroutine
+func StartDocumentRetention(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := purgeExpiredDocuments(); err != nil {
+				log.Printf("KYC document retention failed: %v\n", err)
+			}
+		}
+	}()
+}
+
+// storeVerificationDocuments encrypts and stores the photo ID and selfie of a verification attempt
+func storeVerificationDocuments(verification *PhotoVerification, photoIDImage, selfieImage string) error {
+	images := map[string]string{DocumentPhotoID: photoIDImage, DocumentSelfie: selfieImage}
+	for kind, image := range images {
+		if image == "" {
+			continue
+		}
+		data, err := decodeImage(image)
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %w", kind, err)
+		}
+		if err := saveDocument(verification, kind, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveDocument stores a document and records it against the verification with an expiry based on its decision
+func saveDocument(verification *PhotoVerification, kind string, data []byte) error {
+	store, err := getDocumentStore()
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	key := fmt.Sprintf("kyc/%d/%d/%s-%s", verification.UserID, verification.VerificationID, kind, hex.EncodeToString(suffix))
+	if err := store.Put(key, data); err != nil {
+		return fmt.Errorf("failed to store %s: %w", kind, err)
+	}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	sum := sha256.Sum256(data)
+	_, err = db.Exec(context.Background(), `
+		INSERT INTO kyc_document (user_id, verification_id, kind, storage_key, content_type, size, sha256, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), $8)
+	`, verification.UserID, verification.VerificationID, kind, key, http.DetectContentType(data), len(data),
+		hex.EncodeToString(sum[:]), time.Now().Add(retention(verification.Decision)))
+	if err != nil {
+		if deleteErr := store.Delete(key); deleteErr != nil {
+			log.Printf("Failed to delete orphaned document %s: %v\n", key, deleteErr)
+		}
+		return fmt.Errorf("failed to save document: %w", err)
+	}
+	return nil
+}
+
+// updateDocumentRetention recalculates the expiry of a verification's documents after its decision changes
+func updateDocumentRetention(verificationID int64, decision string) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	_, err = db.Exec(context.Background(),
+		"UPDATE kyc_document SET expires_at = created_at + $2 * INTERVAL '1 second' WHERE verification_id = $1 AND deleted_at IS NULL",
+		verificationID, retention(decision).Seconds())
+	return err
+}
+
+// readDocument returns a document and its decrypted content, logging the access
+func readDocument(documentID int64, accessedBy string) (*Document, []byte, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close(context.Background())
+
+	var document Document
+	var key string
+	err = db.QueryRow(context.Background(), `
+		SELECT document_id, user_id, verification_id, kind, content_type, size, sha256, created_at, expires_at, storage_key
+		FROM kyc_document
+		WHERE document_id = $1 AND deleted_at IS NULL
+	`, documentID).Scan(&document.DocumentID, &document.UserID, &document.VerificationID, &document.Kind, &document.ContentType,
+		&document.Size, &document.SHA256, &document.CreatedAt, &document.ExpiresAt, &key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = db.Exec(context.Background(),
+		"INSERT INTO kyc_document_access (document_id, accessed_by, accessed_at) VALUES ($1, $2, NOW())", documentID, accessedBy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to log document access: %w", err)
+	}
+
+	store, err := getDocumentStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := store.Get(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &document, data, nil
+}
+
+func fetchDocuments(verificationID int64) ([]Document, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		SELECT document_id, user_id, verification_id, kind, content_type, size, sha256, created_at, expires_at
+		FROM kyc_document
+		WHERE verification_id = $1 AND deleted_at IS NULL
+		ORDER BY document_id
+	`, verificationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	documents := []Document{}
+	for rows.Next() {
+		var document Document
+		err := rows.Scan(&document.DocumentID, &document.UserID, &document.VerificationID, &document.Kind, &document.ContentType,
+			&document.Size, &document.SHA256, &document.CreatedAt, &document.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	return documents, rows.Err()
+}
+
+// purgeExpiredDocuments deletes documents past their retention period from the store and marks them deleted
+func purgeExpiredDocuments() error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		SELECT document_id, storage_key FROM kyc_document
+		WHERE deleted_at IS NULL AND expires_at <= NOW()
+		ORDER BY expires_at
+		LIMIT 500
+	`)
+	if err != nil {
+		return err
+	}
+
+	expired := map[int64]string{}
+	for rows.Next() {
+		var documentID int64
+		var key string
+		if err := rows.Scan(&documentID, &key); err != nil {
+			rows.Close()
+			return err
+		}
+		expired[documentID] = key
+	}
+	rows.Close()
+
+	if len(expired) == 0 {
+		return nil
+	}
+
+	store, err := getDocumentStore()
+	if err != nil {
+		return err
+	}
+
+	for documentID, key := range expired {
+		if err := store.Delete(key); err != nil {
+			log.Printf("Failed to delete KYC document %d: %v\n", documentID, err)
+			continue
+		}
+		if _, err := db.Exec(context.Background(),
+			"UPDATE kyc_document SET deleted_at = NOW() WHERE document_id = $1", documentID); err != nil {
+			log.Printf("Failed to mark KYC document %d deleted: %v\n", documentID, err)
+		}
+	}
+	return nil
+}
+
+// getDocumentStore returns the configured document store, creating it on first use
+func getDocumentStore() (docstore.Store, error) {
+	documentStoreOnce.Do(func() {
+		documentStore, documentStoreErr = docstore.New()
+	})
+	return documentStore, documentStoreErr
+}
+
+// decodeImage decodes a base64 image, with or without a data URI prefix
+func decodeImage(image string) ([]byte, error) {
+	if strings.HasPrefix(image, "data:") {
+		if comma := strings.Index(image, ","); comma >= 0 {
+			image = image[comma+1:]
+		}
+	}
+	image = strings.TrimSpace(image)
+	if data, err := base64.StdEncoding.DecodeString(image); err == nil {
+		return data, nil
+	}
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(image, "="))
+}
+
+// retention returns how long documents are kept for a verification decision. Documents of rejected
+// attempts are kept for KYC_REJECTED_RETENTION_DAYS, all others for KYC_DOCUMENT_RETENTION_DAYS.
+func retention(decision string) time.Duration {
+	key, fallback := "KYC_DOCUMENT_RETENTION_DAYS", defaultDocumentRetentionDays
+	if decision == DecisionRejected {
+		key, fallback = "KYC_REJECTED_RETENTION_DAYS", defaultRejectedRetentionDays
+	}
+
+	days, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || days <= 0 {
+		days = fallback
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
@@ -0,0 +1,524 @@
+package kyc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/wallet"
+)
+
+// dojahKYCURL is the base URL of the Dojah KYC lookups
+const dojahKYCURL = "https://api.dojah.io/api/v1/kyc"
+
+// defaultNameMatchThreshold is the share of the user's names that must match the identity when
+// KYC_NAME_MATCH_THRESHOLD is not set
+const defaultNameMatchThreshold = 0.66
+
+var (
+	identityNumberRegex = regexp.MustCompile(`^[0-9]{11}$`)
+	nonLetterRegex      = regexp.MustCompile(`[^a-z\s]+`)
+)
+
+// dateLayouts are the date of birth formats returned by Dojah and accepted from users
+var dateLayouts = []string{"2006-01-02", "02-Jan-2006", "02/01/2006", "2006/01/02", "02-01-2006"}
+
+// IdentityVerificationRequest represents the request payload for a BVN or NIN lookup
+type IdentityVerificationRequest struct {
+	UserID      int    `json:"user_id"`
+	BVN         string `json:"bvn"`
+	NIN         string `json:"nin"`
+	DateOfBirth string `json:"date_of_birth"` // Used when no date of birth is on record
+}
+
+// DojahIdentityResponse represents the response payload of a Dojah BVN or NIN lookup
+type DojahIdentityResponse struct {
+	Entity struct {
+		FirstName   string `json:"first_name"`
+		MiddleName  string `json:"middle_name"`
+		LastName    string `json:"last_name"`
+		DateOfBirth string `json:"date_of_birth"`
+	} `json:"entity"`
+	Error string `json:"error"`
+}
+
+// Identity represents a verified identity snapshot. The identity number is only ever stored masked.
+type Identity struct {
+	UserID      int       `json:"user_id"`
+	IDType      string    `json:"id_type"`
+	IDNumber    string    `json:"id_number"`
+	FirstName   string    `json:"first_name"`
+	MiddleName  string    `json:"middle_name,omitempty"`
+	LastName    string    `json:"last_name"`
+	DateOfBirth string    `json:"date_of_birth"`
+	NameScore   float64   `json:"name_score"`
+	DOBMatch    bool      `json:"dob_match"`
+	Verified    bool      `json:"verified"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// IdentityVerificationResult is returned to the client after a BVN or NIN lookup
+type IdentityVerificationResult struct {
+	Identity         *Identity `json:"identity"`
+	Tier             int       `json:"tier"`
+	DedicatedAccount string    `json:"dedicated_account,omitempty"`
+}
+
+// identityUser holds the fields of a user compared against an identity
+type identityUser struct {
+	fullname    string
+	phone       string
+	dateOfBirth *time.Time
+}
+
+// BVNVerificationHandler looks up a BVN, matches it against the user and promotes their KYC tier
+func BVNVerificationHandler(c *gin.Context) {
+	identityVerificationHandler(c, CheckBVN)
+}
+
+// NINVerificationHandler looks up a NIN, matches it against the user and promotes their KYC tier
+func NINVerificationHandler(c *gin.Context) {
+	identityVerificationHandler(c, CheckNIN)
+}
+
+// IdentityHandler returns the user's verified identities with their numbers masked
+func IdentityHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user ID",
+		})
+		return
+	}
+
+	identities, err := FetchIdentities(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch identities: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Identities fetched successfully",
+		Result:  identities,
+	})
+}
+
+func identityVerificationHandler(c *gin.Context, idType string) {
+	var request IdentityVerificationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON input: " + err.Error(),
+		})
+		return
+	}
+
+	number := strings.TrimSpace(request.BVN)
+	if idType == CheckNIN {
+		number = strings.TrimSpace(request.NIN)
+	}
+	if !identityNumberRegex.MatchString(number) {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("A valid 11 digit %s is required", strings.ToUpper(idType)),
+		})
+		return
+	}
+
+	user, err := fetchIdentityUser(request.UserID)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "User not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch user: " + err.Error(),
+		})
+		return
+	}
+
+	if user.dateOfBirth == nil {
+		dateOfBirth, err := parseDate(request.DateOfBirth)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: "A date_of_birth in YYYY-MM-DD format is required",
+			})
+			return
+		}
+		user.dateOfBirth = &dateOfBirth
+	}
+
+	if owner, err := identityOwner(idType, number); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to check identity: " + err.Error(),
+		})
+		return
+	} else if owner != 0 && owner != request.UserID {
+		c.JSON(http.StatusConflict, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("This %s has already been verified on another account", strings.ToUpper(idType)),
+		})
+		return
+	}
+
+	lookup, err := lookupIdentity(idType, number)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Failed to look up %s: %s", strings.ToUpper(idType), err.Error()),
+		})
+		return
+	}
+
+	identity := matchIdentity(request.UserID, idType, number, user, lookup)
+	if err := saveIdentity(identity, number); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to save identity: " + err.Error(),
+		})
+		return
+	}
+
+	if !identity.Verified {
+		c.JSON(http.StatusUnprocessableEntity, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("The %s details do not match your profile", strings.ToUpper(idType)),
+			Result:  identity,
+		})
+		return
+	}
+
+	if err := saveDateOfBirth(request.UserID, *user.dateOfBirth); err != nil {
+		log.Printf("Failed to save date of birth for user %d: %v\n", request.UserID, err)
+	}
+
+	tier, err := RecordVerification(request.UserID, idType, "dojah")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to update KYC tier: " + err.Error(),
+		})
+		return
+	}
+
+	result := IdentityVerificationResult{Identity: identity, Tier: tier}
+	result.DedicatedAccount = assignDedicatedAccount(identity, lookup, number, user.phone)
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: fmt.Sprintf("%s verified successfully", strings.ToUpper(idType)),
+		Result:  result,
+	})
+}
+
+// assignDedicatedAccount requests a dedicated account with the verified identity for users without a wallet
+// and returns the outcome for the client
+func assignDedicatedAccount(identity *Identity, lookup *DojahIdentityResponse, number, phone string) string {
+	hasWallet, err := wallet.HasWallet(int64(identity.UserID))
+	if err != nil {
+		log.Printf("Failed to check wallet for user %d: %v\n", identity.UserID, err)
+		return ""
+	}
+	if hasWallet {
+		return ""
+	}
+
+	walletIdentity := wallet.Identity{
+		UserID:     int64(identity.UserID),
+		FirstName:  lookup.Entity.FirstName,
+		MiddleName: lookup.Entity.MiddleName,
+		LastName:   lookup.Entity.LastName,
+		Phone:      phone,
+	}
+	if identity.IDType == CheckBVN {
+		walletIdentity.BVN = number
+	}
+
+	if _, err := wallet.AssignDVAWithIdentity(walletIdentity); err != nil {
+		log.Printf("Failed to assign dedicated account for user %d: %v\n", identity.UserID, err)
+		return "failed"
+	}
+	return "pending"
+}
+
+// FetchIdentities returns the user's stored identity snapshots, newest first
+func FetchIdentities(userID int) ([]Identity, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		SELECT user_id, id_type, id_number_masked, first_name, COALESCE(middle_name, ''), last_name,
+			COALESCE(TO_CHAR(date_of_birth, 'YYYY-MM-DD'), ''), name_score, dob_match, verified, created_at
+		FROM kyc_identity
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		var identity Identity
+		err := rows.Scan(&identity.UserID, &identity.IDType, &identity.IDNumber, &identity.FirstName, &identity.MiddleName,
+			&identity.LastName, &identity.DateOfBirth, &identity.NameScore, &identity.DOBMatch, &identity.Verified, &identity.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// lookupIdentity fetches the identity behind a BVN or NIN from Dojah
+func lookupIdentity(idType, number string) (*DojahIdentityResponse, error) {
+	endpoint := dojahKYCURL + "/bvn/full?bvn=" + url.QueryEscape(number)
+	if idType == CheckNIN {
+		endpoint = dojahKYCURL + "/nin?nin=" + url.QueryEscape(number)
+	}
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("AppId", os.Getenv("DOJAH_APP_ID"))
+	req.Header.Set("Authorization", os.Getenv("DOJAH_SECRET_KEY"))
+	req.Header.Set("accept", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var lookup DojahIdentityResponse
+	if err := json.Unmarshal(body, &lookup); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %s", string(body))
+	}
+	if lookup.Error != "" {
+		return nil, fmt.Errorf("%s", lookup.Error)
+	}
+	if resp.StatusCode != http.StatusOK || lookup.Entity.LastName == "" {
+		return nil, fmt.Errorf("no identity found")
+	}
+
+	return &lookup, nil
+}
+
+// matchIdentity compares the identity returned by Dojah with the user's record
+func matchIdentity(userID int, idType, number string, user *identityUser, lookup *DojahIdentityResponse) *Identity {
+	identity := &Identity{
+		UserID:     userID,
+		IDType:     idType,
+		IDNumber:   maskIdentityNumber(number),
+		FirstName:  lookup.Entity.FirstName,
+		MiddleName: lookup.Entity.MiddleName,
+		LastName:   lookup.Entity.LastName,
+		CreatedAt:  time.Now(),
+	}
+
+	if dateOfBirth, err := parseDate(lookup.Entity.DateOfBirth); err == nil {
+		identity.DateOfBirth = dateOfBirth.Format("2006-01-02")
+		identity.DOBMatch = user.dateOfBirth != nil && dateOfBirth.Equal(*user.dateOfBirth)
+	}
+
+	identity.NameScore = nameScore(user.fullname, lookup.Entity.FirstName+" "+lookup.Entity.MiddleName+" "+lookup.Entity.LastName)
+	identity.Verified = identity.DOBMatch && identity.NameScore >= nameMatchThreshold()
+	return identity
+}
+
+// nameScore returns the share of the user's names found in the identity's names. Names match
+// regardless of order and allow for one typo in every five letters.
+func nameScore(fullname, identityName string) float64 {
+	userNames := nameTokens(fullname)
+	identityNames := nameTokens(identityName)
+	if len(userNames) == 0 {
+		return 0
+	}
+
+	used := make([]bool, len(identityNames))
+	matched := 0
+	for _, name := range userNames {
+		for i, candidate := range identityNames {
+			if used[i] {
+				continue
+			}
+			maxEdits := len(candidate) / 5
+			if levenshtein(name, candidate) <= maxEdits {
+				used[i] = true
+				matched++
+				break
+			}
+		}
+	}
+
+	score := float64(matched) / float64(len(userNames))
+	return float64(int(score*100+0.5)) / 100
+}
+
+// nameTokens lowercases a name and splits it into its parts, ignoring punctuation and initials
+func nameTokens(name string) []string {
+	var tokens []string
+	for _, token := range strings.Fields(nonLetterRegex.ReplaceAllString(strings.ToLower(name), " ")) {
+		if len(token) > 1 {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// nameMatchThreshold returns the minimum name score for an identity to be verified
+func nameMatchThreshold() float64 {
+	threshold, err := strconv.ParseFloat(os.Getenv("KYC_NAME_MATCH_THRESHOLD"), 64)
+	if err != nil || threshold <= 0 || threshold > 1 {
+		return defaultNameMatchThreshold
+	}
+	return threshold
+}
+
+// maskIdentityNumber keeps the first three and last two digits of an identity number
+func maskIdentityNumber(number string) string {
+	if len(number) <= 5 {
+		return strings.Repeat("*", len(number))
+	}
+	return number[:3] + strings.Repeat("*", len(number)-5) + number[len(number)-2:]
+}
+
+// hashIdentityNumber returns a keyed hash of an identity number so reuse across accounts can be detected
+// without storing the number
+func hashIdentityNumber(idType, number string) string {
+	sum := sha256.Sum256([]byte(os.Getenv("KYC_HASH_SECRET") + ":" + idType + ":" + number))
+	return hex.EncodeToString(sum[:])
+}
+
+func parseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+// fetchIdentityUser returns the user fields an identity is matched against
+func fetchIdentityUser(userID int) (*identityUser, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	var user identityUser
+	err = db.QueryRow(context.Background(),
+		"SELECT fullname, COALESCE(phone, ''), date_of_birth FROM users WHERE user_id = $1 AND deleted = false", userID).
+		Scan(&user.fullname, &user.phone, &user.dateOfBirth)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// identityOwner returns the user who has verified the identity number, or zero if nobody has
+func identityOwner(idType, number string) (int, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close(context.Background())
+
+	var userID int
+	err = db.QueryRow(context.Background(),
+		"SELECT user_id FROM kyc_identity WHERE id_hash = $1 AND verified = true LIMIT 1", hashIdentityNumber(idType, number)).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	return userID, err
+}
+
+// saveIdentity stores the identity snapshot of a lookup, whether or not it matched
+func saveIdentity(identity *Identity, number string) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	_, err = db.Exec(context.Background(), `
+		INSERT INTO kyc_identity (user_id, id_type, id_number_masked, id_hash, first_name, middle_name, last_name,
+			date_of_birth, name_score, dob_match, verified, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, NULLIF($8, '')::date, $9, $10, $11, $12)
+	`, identity.UserID, identity.IDType, identity.IDNumber, hashIdentityNumber(identity.IDType, number), identity.FirstName,
+		identity.MiddleName, identity.LastName, identity.DateOfBirth, identity.NameScore, identity.DOBMatch, identity.Verified,
+		identity.CreatedAt)
+	return err
+}
+
+// saveDateOfBirth records a matched date of birth on the user if none is on record
+func saveDateOfBirth(userID int, dateOfBirth time.Time) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	_, err = db.Exec(context.Background(),
+		"UPDATE users SET date_of_birth = $2 WHERE user_id = $1 AND date_of_birth IS NULL", userID, dateOfBirth)
+	return err
+}
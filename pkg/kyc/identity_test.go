@@ -0,0 +1,73 @@
+package kyc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNameScore(t *testing.T) {
+	tests := []struct {
+		name         string
+		fullname     string
+		identityName string
+		want         float64
+	}{
+		{"exact match", "Adaeze Okafor", "Adaeze  Okafor", 1},
+		{"different case and order", "OKAFOR adaeze", "Adaeze Chioma Okafor", 1},
+		{"middle name on the identity only", "Adaeze Okafor", "Adaeze Chioma Okafor", 1},
+		{"one typo in a long name", "Oluwaseun Adeyemi", "Oluwasegun Adeyemi", 1},
+		{"typo in a short name", "Tobi Bello", "Toby Bello", 0.5},
+		{"punctuation and initials ignored", "Ngozi C. Eze-Nwosu", "Ngozi Eze Nwosu", 1},
+		{"two of three names", "Ibrahim Musa Bello", "Ibrahim Bello", 0.67},
+		{"repeated name matched once", "Musa Musa", "Musa Bello", 0.5},
+		{"no match", "John Smith", "Adaeze Okafor", 0},
+		{"empty user name", "", "Adaeze Okafor", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nameScore(tt.fullname, tt.identityName); got != tt.want {
+				t.Errorf("nameScore(%q, %q) = %.2f, want %.2f", tt.fullname, tt.identityName, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchIdentity(t *testing.T) {
+	dateOfBirth := time.Date(1990, time.March, 14, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		user         identityUser
+		identityDOB  string
+		wantDOBMatch bool
+		wantVerified bool
+	}{
+		{"names and date of birth match", identityUser{fullname: "Adaeze Okafor", dateOfBirth: &dateOfBirth}, "1990-03-14", true, true},
+		{"date of birth in Dojah's long format", identityUser{fullname: "Adaeze Okafor", dateOfBirth: &dateOfBirth}, "14-Mar-1990", true, true},
+		{"date of birth differs", identityUser{fullname: "Adaeze Okafor", dateOfBirth: &dateOfBirth}, "1990-04-14", false, false},
+		{"user has no date of birth", identityUser{fullname: "Adaeze Okafor"}, "1990-03-14", false, false},
+		{"names below the threshold", identityUser{fullname: "John Smith", dateOfBirth: &dateOfBirth}, "1990-03-14", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lookup DojahIdentityResponse
+			lookup.Entity.FirstName = "Adaeze"
+			lookup.Entity.MiddleName = "Chioma"
+			lookup.Entity.LastName = "Okafor"
+			lookup.Entity.DateOfBirth = tt.identityDOB
+
+			identity := matchIdentity(1, "bvn", "22212345678", &tt.user, &lookup)
+			if identity.DOBMatch != tt.wantDOBMatch {
+				t.Errorf("DOBMatch = %v, want %v", identity.DOBMatch, tt.wantDOBMatch)
+			}
+			if identity.Verified != tt.wantVerified {
+				t.Errorf("Verified = %v, want %v (name score %.2f)", identity.Verified, tt.wantVerified, identity.NameScore)
+			}
+			if identity.IDNumber != "222******78" {
+				t.Errorf("IDNumber = %q, want the masked number", identity.IDNumber)
+			}
+		})
+	}
+}
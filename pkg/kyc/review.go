@@ -0,0 +1,370 @@
+package kyc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/auth"
+	"go_code/pkg/notification"
+)
+
+// Photo ID verification decisions
+const (
+	DecisionApproved = "approved"
+	DecisionRejected = "rejected"
+	DecisionReview   = "review"
+)
+
+// Who decided a photo ID verification
+const (
+	DecidedByAuto  = "auto"
+	DecidedByStaff = "staff"
+)
+
+// PhotoVerification represents a single photo ID and selfie verification attempt
+type PhotoVerification struct {
+	VerificationID     int64           `json:"verification_id"`
+	UserID             int             `json:"user_id"`
+	Provider           string          `json:"provider"`
+	ConfidenceValue    float64         `json:"confidence_value"`
+	Match              bool            `json:"match"`
+	PhotoIDImageBlurry bool            `json:"photoid_image_blurry"`
+	SelfieImageBlurry  bool            `json:"selfie_image_blurry"`
+	SelfieGlare        bool            `json:"selfie_glare"`
+	PhotoIDGlare       bool            `json:"photoid_glare"`
+	Sunglasses         bool            `json:"sunglasses"`
+	CardType           string          `json:"card_type,omitempty"`
+	AgeRange           string          `json:"age_range,omitempty"`
+	ProviderError      string          `json:"provider_error,omitempty"`
+	ProviderResponse   json.RawMessage `json:"provider_response,omitempty"`
+	Decision           string          `json:"decision"`
+	DecisionReason     string          `json:"decision_reason"`
+	DecidedBy          string          `json:"decided_by"`
+	ReviewedBy         string          `json:"reviewed_by,omitempty"`
+	ReviewNotes        string          `json:"review_notes,omitempty"`
+	ReviewedAt         *time.Time      `json:"reviewed_at,omitempty"`
+	CreatedAt          time.Time       `json:"created_at"`
+}
+
+// ReviewDecisionRequest represents the request payload for deciding a verification under review
+type ReviewDecisionRequest struct {
+	Decision string `json:"decision"` // approved or rejected
+	Notes    string `json:"notes"`
+}
+
+const photoVerificationColumns = `verification_id, user_id, provider, confidence_value, match, photoid_image_blurry,
+	selfie_image_blurry, selfie_glare, photoid_glare, sunglasses, COALESCE(card_type, ''), COALESCE(age_range, ''),
+	COALESCE(provider_error, ''), provider_response, decision, decision_reason, decided_by, COALESCE(reviewed_by, ''),
+	COALESCE(review_notes, ''), reviewed_at, created_at`
+
+// VerificationHistoryHandler returns every photo ID verification attempt of a user, newest first
+func VerificationHistoryHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user ID",
+		})
+		return
+	}
+
+	verifications, err := fetchPhotoVerifications("WHERE user_id = $1 ORDER BY created_at DESC", userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch verification history: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Verification history fetched successfully",
+		Result:  verifications,
+	})
+}
+
+// ReviewQueueHandler returns the photo ID verifications waiting for a manual decision, oldest first
+func ReviewQueueHandler(c *gin.Context) {
+	verifications, err := fetchPhotoVerifications("WHERE decision = $1 ORDER BY created_at LIMIT 100", DecisionReview)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch review queue: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Review queue fetched successfully",
+		Result:  verifications,
+	})
+}
+
+// ReviewDecisionHandler lets support approve or reject a photo ID verification under review
+func ReviewDecisionHandler(c *gin.Context) {
+	verificationID, err := strconv.ParseInt(c.Param("verification_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid verification ID",
+		})
+		return
+	}
+
+	var request ReviewDecisionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON input: " + err.Error(),
+		})
+		return
+	}
+	if request.Decision != DecisionApproved && request.Decision != DecisionRejected {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Decision must be approved or rejected",
+		})
+		return
+	}
+	if strings.TrimSpace(request.Notes) == "" {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Review notes are required",
+		})
+		return
+	}
+
+	staff, _ := auth.StaffFromContext(c)
+	verification, err := decideReview(verificationID, request.Decision, staff.Name, request.Notes)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusConflict, Response{
+			Status:  "error",
+			Message: "Verification not found or no longer under review",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to record decision: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Verification " + verification.Decision,
+		Result:  verification,
+	})
+}
+
+// recordPhotoVerification decides a photo ID verification from the provider's response and stores the attempt
+func recordPhotoVerification(userID int, response PhotoIDVerificationResponse, body []byte) (*PhotoVerification, error) {
+	selfie := response.Entity.Selfie
+	verification := &PhotoVerification{
+		UserID:             userID,
+		Provider:           "dojah",
+		ConfidenceValue:    selfie.ConfidenceValue,
+		Match:              selfie.Match,
+		PhotoIDImageBlurry: selfie.PhotoIDImageBlurry,
+		SelfieImageBlurry:  selfie.SelfieImageBlurry,
+		SelfieGlare:        selfie.SelfieGlare,
+		PhotoIDGlare:       selfie.PhotoIDGlare,
+		Sunglasses:         selfie.Sunglasses,
+		CardType:           selfie.CardType,
+		AgeRange:           selfie.AgeRange,
+		ProviderError:      response.Error,
+		DecidedBy:          DecidedByAuto,
+		CreatedAt:          time.Now(),
+	}
+	if json.Valid(body) {
+		verification.ProviderResponse = body
+	}
+	verification.Decision, verification.DecisionReason = decidePhotoVerification(verification)
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	err = db.QueryRow(context.Background(), `
+		INSERT INTO kyc_photo_verification (user_id, provider, confidence_value, match, photoid_image_blurry,
+			selfie_image_blurry, selfie_glare, photoid_glare, sunglasses, card_type, age_range, provider_error,
+			provider_response, decision, decision_reason, decided_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, ''), $13, $14, $15, $16, $17)
+		RETURNING verification_id
+	`, userID, verification.Provider, verification.ConfidenceValue, verification.Match, verification.PhotoIDImageBlurry,
+		verification.SelfieImageBlurry, verification.SelfieGlare, verification.PhotoIDGlare, verification.Sunglasses,
+		verification.CardType, verification.AgeRange, verification.ProviderError, verification.ProviderResponse,
+		verification.Decision, verification.DecisionReason, verification.DecidedBy, verification.CreatedAt).
+		Scan(&verification.VerificationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save verification: %w", err)
+	}
+
+	return verification, nil
+}
+
+// decidePhotoVerification approves clear matches above KYC_AUTO_APPROVE_CONFIDENCE, rejects provider errors,
+// mismatches and confidence below KYC_AUTO_REJECT_CONFIDENCE, and sends everything in between to review
+func decidePhotoVerification(verification *PhotoVerification) (string, string) {
+	if verification.ProviderError != "" {
+		return DecisionRejected, "Provider error: " + verification.ProviderError
+	}
+	if verification.ConfidenceValue < envConfidence("KYC_AUTO_REJECT_CONFIDENCE", 50) {
+		return DecisionRejected, fmt.Sprintf("Confidence %.2f is below the auto-reject threshold", verification.ConfidenceValue)
+	}
+	if !verification.Match {
+		return DecisionRejected, "Selfie does not match the photo ID"
+	}
+
+	var flags []string
+	if verification.PhotoIDImageBlurry {
+		flags = append(flags, "blurry photo ID")
+	}
+	if verification.SelfieImageBlurry {
+		flags = append(flags, "blurry selfie")
+	}
+	if verification.PhotoIDGlare {
+		flags = append(flags, "photo ID glare")
+	}
+	if verification.SelfieGlare {
+		flags = append(flags, "selfie glare")
+	}
+	if verification.Sunglasses {
+		flags = append(flags, "sunglasses")
+	}
+	if len(flags) > 0 {
+		return DecisionReview, "Image quality flags: " + strings.Join(flags, ", ")
+	}
+
+	if verification.ConfidenceValue < envConfidence("KYC_AUTO_APPROVE_CONFIDENCE", 90) {
+		return DecisionReview, fmt.Sprintf("Confidence %.2f is below the auto-approve threshold", verification.ConfidenceValue)
+	}
+	return DecisionApproved, "Selfie matches the photo ID"
+}
+
+// decideReview records a staff decision on a verification under review and, if approved, completes the photo ID check
+func decideReview(verificationID int64, decision, reviewer, notes string) (*PhotoVerification, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	row := db.QueryRow(context.Background(), `
+		UPDATE kyc_photo_verification
+		SET decision = $2, decided_by = $3, reviewed_by = $4, review_notes = $5, reviewed_at = NOW()
+		WHERE verification_id = $1 AND decision = 'review'
+		RETURNING `+photoVerificationColumns, verificationID, decision, DecidedByStaff, reviewer, notes)
+	verification, err := scanPhotoVerification(row)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := updateDocumentRetention(verificationID, decision); err != nil {
+		log.Printf("Failed to update document retention for verification %d: %v\n", verificationID, err)
+	}
+
+	if decision == DecisionApproved {
+		if err := updateUserBiometricKYC(verification.UserID); err != nil {
+			return nil, err
+		}
+		if _, err := RecordVerification(verification.UserID, CheckPhotoID, "staff:"+reviewer); err != nil {
+			return nil, err
+		}
+	}
+
+	notifyReviewDecision(verification)
+	return verification, nil
+}
+
+// notifyReviewDecision emails the user the outcome of their photo ID review
+func notifyReviewDecision(verification *PhotoVerification) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		log.Printf("Failed to connect to database: %v\n", err)
+		return
+	}
+	defer db.Close(context.Background())
+
+	var fullname, email string
+	err = db.QueryRow(context.Background(), "SELECT fullname, email FROM users WHERE user_id = $1", verification.UserID).Scan(&fullname, &email)
+	if err != nil {
+		log.Printf("Failed to fetch user %d: %v\n", verification.UserID, err)
+		return
+	}
+
+	subject := "Identity verification approved"
+	outcome := "Your photo ID verification has been approved and your account limits have been updated."
+	if verification.Decision == DecisionRejected {
+		subject = "Identity verification unsuccessful"
+		outcome = "We could not verify your photo ID. Please try again with a clear photo of your ID and a well lit selfie."
+	}
+
+	body := fmt.Sprintf("Dear %s,\n\n%s", fullname, outcome)
+	if err := notification.SendEmail(email, subject, body); err != nil {
+		fmt.Println("Failed to send email:", err)
+	}
+}
+
+func fetchPhotoVerifications(condition string, args ...interface{}) ([]PhotoVerification, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), "SELECT "+photoVerificationColumns+" FROM kyc_photo_verification "+condition, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	verifications := []PhotoVerification{}
+	for rows.Next() {
+		verification, err := scanPhotoVerification(rows)
+		if err != nil {
+			return nil, err
+		}
+		verifications = append(verifications, *verification)
+	}
+	return verifications, rows.Err()
+}
+
+// envConfidence reads a confidence threshold between 0 and 100
+func envConfidence(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || value < 0 || value > 100 {
+		return fallback
+	}
+	return value
+}
+
+func scanPhotoVerification(row pgx.Row) (*PhotoVerification, error) {
+	var verification PhotoVerification
+	var response []byte
+	err := row.Scan(&verification.VerificationID, &verification.UserID, &verification.Provider, &verification.ConfidenceValue,
+		&verification.Match, &verification.PhotoIDImageBlurry, &verification.SelfieImageBlurry, &verification.SelfieGlare,
+		&verification.PhotoIDGlare, &verification.Sunglasses, &verification.CardType, &verification.AgeRange,
+		&verification.ProviderError, &response, &verification.Decision, &verification.DecisionReason, &verification.DecidedBy,
+		&verification.ReviewedBy, &verification.ReviewNotes, &verification.ReviewedAt, &verification.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	verification.ProviderResponse = response
+	return &verification, nil
+}
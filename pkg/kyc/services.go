@@ -6,7 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	// "log"
+	"log"
 	"net/http"
 	"os"
 
@@ -131,17 +131,23 @@ func PhotoIDVerificationHandler(c *gin.Context) {
 		return
 	}
 
-	// Check for errors in the API response
-	if verificationResponse.Error != "" {
-		c.JSON(http.StatusBadRequest, Response{
+	// Record the attempt and decide it against the auto-approve and auto-reject thresholds
+	verification, err := recordPhotoVerification(verificationRequest.UserID, verificationResponse, body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
 			Status:  "error",
-			Message: verificationResponse.Error,
+			Message: "Failed to record verification: " + err.Error(),
 		})
 		return
 	}
 
-	// Check the match status
-	if verificationResponse.Entity.Selfie.Match {
+	// Keep the images encrypted against the attempt for review and audit
+	if err := storeVerificationDocuments(verification, verificationRequest.PhotoIDImage, verificationRequest.SelfieImage); err != nil {
+		log.Printf("Failed to store documents for verification %d: %v\n", verification.VerificationID, err)
+	}
+
+	switch verification.Decision {
+	case DecisionApproved:
 		// Update the users table to set biometric_kyc to true
 		if err := updateUserBiometricKYC(verificationRequest.UserID); err != nil {
 			c.JSON(http.StatusInternalServerError, Response{
@@ -150,16 +156,37 @@ func PhotoIDVerificationHandler(c *gin.Context) {
 			})
 			return
 		}
+		if _, err := RecordVerification(verificationRequest.UserID, CheckPhotoID, "dojah"); err != nil {
+			c.JSON(http.StatusInternalServerError, Response{
+				Status:  "error",
+				Message: "Failed to update KYC tier: " + err.Error(),
+			})
+			return
+		}
 		c.JSON(http.StatusOK, Response{
 			Status:  "success",
 			Message: "Liveness verification successful",
-			Result:  verificationResponse.Entity,
+			Result:  verification,
 		})
-	} else {
+	case DecisionReview:
+		c.JSON(http.StatusAccepted, Response{
+			Status:  "pending",
+			Message: "Liveness verification is under review",
+			Result:  verification,
+		})
+	default:
+		if verificationResponse.Error != "" {
+			c.JSON(http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: verificationResponse.Error,
+				Result:  verification,
+			})
+			return
+		}
 		c.JSON(http.StatusOK, Response{
 			Status:  "error",
 			Message: "Liveness verification failed",
-			Result:  verificationResponse.Entity,
+			Result:  verification,
 		})
 	}
 }
@@ -0,0 +1,518 @@
+package kyc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/auth"
+	"go_code/pkg/ledger"
+)
+
+// KYC tiers. Every registered user with an email and phone number is tier 0.
+const (
+	Tier0 = 0 // email and phone
+	Tier1 = 1 // BVN or NIN
+	Tier2 = 2 // photo ID and selfie
+	Tier3 = 3 // proof of address
+)
+
+// Verification checks that count towards a tier
+const (
+	CheckBVN     = "bvn"
+	CheckNIN     = "nin"
+	CheckPhotoID = "photo_id"
+	CheckAddress = "address"
+)
+
+// Limits that can be exceeded
+const (
+	LimitSingleTransaction = "single_transaction"
+	LimitDaily             = "daily"
+	LimitBalance           = "balance"
+)
+
+// ErrLimitExceeded is wrapped by every LimitError
+var ErrLimitExceeded = errors.New("KYC limit exceeded")
+
+// Limits are the transaction limits of a tier. A zero limit means unlimited.
+type Limits struct {
+	Tier              int     `json:"tier"`
+	SingleTransaction float64 `json:"single_transaction_limit"`
+	Daily             float64 `json:"daily_limit"`
+	MaxBalance        float64 `json:"max_balance"`
+}
+
+// defaultLimits apply to tiers without a row in kyc_tier_limit
+var defaultLimits = map[int]Limits{
+	Tier0: {Tier: Tier0, SingleTransaction: 5000, Daily: 20000, MaxBalance: 50000},
+	Tier1: {Tier: Tier1, SingleTransaction: 50000, Daily: 200000, MaxBalance: 300000},
+	Tier2: {Tier: Tier2, SingleTransaction: 200000, Daily: 1000000, MaxBalance: 2000000},
+	Tier3: {Tier: Tier3, SingleTransaction: 5000000, Daily: 25000000},
+}
+
+// LimitError is returned when a transaction would exceed the user's tier limits
+type LimitError struct {
+	Limit     string  `json:"limit"`
+	Tier      int     `json:"tier"`
+	Allowed   float64 `json:"allowed"`
+	Remaining float64 `json:"remaining"`
+}
+
+func (e *LimitError) Error() string {
+	switch e.Limit {
+	case LimitSingleTransaction:
+		return fmt.Sprintf("Amount exceeds your tier %d single transaction limit of NGN %.2f. Upgrade your KYC tier to send more.", e.Tier, e.Allowed)
+	case LimitDaily:
+		return fmt.Sprintf("Amount exceeds your tier %d daily limit of NGN %.2f. You can send NGN %.2f more today.", e.Tier, e.Allowed, e.Remaining)
+	}
+	return fmt.Sprintf("Amount would take your balance over your tier %d limit of NGN %.2f. Upgrade your KYC tier to hold more.", e.Tier, e.Allowed)
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// TierStatus represents a user's KYC tier, completed checks, limits and usage today
+type TierStatus struct {
+	UserID     int             `json:"user_id"`
+	Tier       int             `json:"tier"`
+	Checks     map[string]bool `json:"checks"`
+	Limits     Limits          `json:"limits"`
+	SpentToday float64         `json:"spent_today"`
+	Balance    float64         `json:"balance"`
+}
+
+// VerifyAddressRequest represents the request payload for recording a verified proof of address
+type VerifyAddressRequest struct {
+	UserID int `json:"user_id"`
+}
+
+// TierHandler returns the user's KYC tier and the limits that apply to it
+func TierHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user ID",
+		})
+		return
+	}
+
+	status, err := FetchTierStatus(userID)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "User not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch KYC tier: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "KYC tier fetched successfully",
+		Result:  status,
+	})
+}
+
+// VerifyAddressHandler lets support record a user's proof of address once it has been checked
+func VerifyAddressHandler(c *gin.Context) {
+	var request VerifyAddressRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.UserID == 0 {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "A user_id is required",
+		})
+		return
+	}
+
+	staff, _ := auth.StaffFromContext(c)
+	tier, err := RecordVerification(request.UserID, CheckAddress, "staff:"+staff.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to record address verification: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Address verification recorded",
+		Result:  map[string]int{"user_id": request.UserID, "tier": tier},
+	})
+}
+
+// RecordVerification records a completed check, recalculates the user's tier and returns it.
+// Deposits held because of the balance limit are released when the tier goes up.
+func RecordVerification(userID int, check, source string) (int, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close(context.Background())
+
+	_, err = db.Exec(context.Background(), `
+		INSERT INTO kyc_verification (user_id, check_type, source, verified_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (user_id, check_type) DO UPDATE SET source = EXCLUDED.source, verified_at = EXCLUDED.verified_at
+	`, userID, check, source)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save verification: %w", err)
+	}
+
+	var previousTier, tier int
+	err = db.QueryRow(context.Background(), `
+		WITH checks AS (
+			SELECT
+				EXISTS (SELECT 1 FROM kyc_verification WHERE user_id = $1 AND check_type IN ('bvn', 'nin')) AS identity,
+				EXISTS (SELECT 1 FROM kyc_verification WHERE user_id = $1 AND check_type = 'photo_id') AS photo_id,
+				EXISTS (SELECT 1 FROM kyc_verification WHERE user_id = $1 AND check_type = 'address') AS address
+		), old AS (
+			SELECT COALESCE(kyc_tier, 0) AS tier FROM users WHERE user_id = $1
+		)
+		UPDATE users SET kyc_tier = CASE
+				WHEN c.identity AND c.photo_id AND c.address THEN 3
+				WHEN c.identity AND c.photo_id THEN 2
+				WHEN c.identity THEN 1
+				ELSE 0
+			END
+		FROM checks c, old o
+		WHERE user_id = $1
+		RETURNING o.tier, users.kyc_tier
+	`, userID).Scan(&previousTier, &tier)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update KYC tier: %w", err)
+	}
+
+	if tier > previousTier {
+		if err := ReleaseHeldCredits(userID); err != nil {
+			log.Printf("Failed to release held deposits for user %d: %v\n", userID, err)
+		}
+	}
+
+	return tier, nil
+}
+
+// UserTier returns the user's KYC tier
+func UserTier(userID int) (int, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close(context.Background())
+
+	return userTier(db, userID)
+}
+
+// TierLimits returns the limits of a tier, preferring the configured kyc_tier_limit row over the defaults
+func TierLimits(tier int) (Limits, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return Limits{}, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close(context.Background())
+
+	return tierLimits(db, tier)
+}
+
+// querier is a database connection or transaction the limit lookups can run on
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// CheckDebit returns a LimitError if any amount exceeds the single transaction limit of the user's tier,
+// or if together they take the user's spending today over the daily limit. It is an early check before
+// any money moves; the debit itself is checked again with CheckDebitTx.
+func CheckDebit(userID int, amounts ...float64) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close(context.Background())
+
+	return checkDebit(db, userID, amounts...)
+}
+
+// CheckDebitTx is CheckDebit within the debit's transaction. It locks the user's wallet first, so
+// concurrent debits are checked one after the other and cannot together exceed the daily limit.
+func CheckDebitTx(tx pgx.Tx, userID int, amounts ...float64) error {
+	if _, err := ledger.LockWallet(tx, userID); err != nil {
+		return err
+	}
+	return checkDebit(tx, userID, amounts...)
+}
+
+// checkDebit checks the amounts against the user's tier limits
+func checkDebit(db querier, userID int, amounts ...float64) error {
+	tier, err := userTier(db, userID)
+	if err != nil {
+		return err
+	}
+	limits, err := tierLimits(db, tier)
+	if err != nil {
+		return err
+	}
+
+	var total float64
+	for _, amount := range amounts {
+		if limits.SingleTransaction > 0 && amount > limits.SingleTransaction {
+			return &LimitError{Limit: LimitSingleTransaction, Tier: tier, Allowed: limits.SingleTransaction, Remaining: limits.SingleTransaction}
+		}
+		total += amount
+	}
+
+	if limits.Daily > 0 {
+		spent, err := spentToday(db, userID)
+		if err != nil {
+			return err
+		}
+		if spent+total > limits.Daily {
+			remaining := math.Max(0, math.Round((limits.Daily-spent)*100)/100)
+			return &LimitError{Limit: LimitDaily, Tier: tier, Allowed: limits.Daily, Remaining: remaining}
+		}
+	}
+
+	return nil
+}
+
+// CheckCredit returns a LimitError if crediting the amount would take the user's balance over their tier's limit
+func CheckCredit(userID int, amount float64) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close(context.Background())
+
+	tier, err := userTier(db, userID)
+	if err != nil {
+		return err
+	}
+	limits, err := tierLimits(db, tier)
+	if err != nil {
+		return err
+	}
+	if limits.MaxBalance <= 0 {
+		return nil
+	}
+
+	balance, err := walletBalance(db, userID)
+	if err != nil {
+		return err
+	}
+	if balance+amount > limits.MaxBalance {
+		remaining := math.Max(0, math.Round((limits.MaxBalance-balance)*100)/100)
+		return &LimitError{Limit: LimitBalance, Tier: tier, Allowed: limits.MaxBalance, Remaining: remaining}
+	}
+	return nil
+}
+
+// HoldCredit records a deposit that could not be credited because of the balance limit within the given
+// transaction, so the hold is only kept if the deposit is recorded with it
+func HoldCredit(tx pgx.Tx, userID int, reference string, amount float64, reason string) error {
+	_, err := tx.Exec(context.Background(), `
+		INSERT INTO held_credit (user_id, reference, amount, reason, created_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (reference) DO NOTHING
+	`, userID, reference, amount, reason)
+	if err != nil {
+		return fmt.Errorf("failed to hold deposit: %w", err)
+	}
+	return nil
+}
+
+// ReleaseHeldCredits credits held deposits, oldest first, for as long as they fit within the user's balance limit
+func ReleaseHeldCredits(userID int) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(),
+		"SELECT held_id, reference, amount FROM held_credit WHERE user_id = $1 AND released_at IS NULL ORDER BY created_at", userID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch held deposits: %w", err)
+	}
+
+	type heldCredit struct {
+		heldID    int64
+		reference string
+		amount    float64
+	}
+	var held []heldCredit
+	for rows.Next() {
+		var credit heldCredit
+		if err := rows.Scan(&credit.heldID, &credit.reference, &credit.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		held = append(held, credit)
+	}
+	rows.Close()
+
+	for _, credit := range held {
+		if err := CheckCredit(userID, credit.amount); err != nil {
+			if errors.Is(err, ErrLimitExceeded) {
+				return nil
+			}
+			return err
+		}
+
+		tx, err := db.Begin(context.Background())
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(context.Background(),
+			"UPDATE held_credit SET released_at = NOW() WHERE held_id = $1 AND released_at IS NULL", credit.heldID)
+		if err != nil || result.RowsAffected() == 0 {
+			tx.Rollback(context.Background())
+			if err != nil {
+				return fmt.Errorf("failed to release held deposit: %w", err)
+			}
+			continue
+		}
+
+		_, err = ledger.Credit(tx, ledger.Entry{
+			UserID:    userID,
+			Amount:    credit.amount,
+			Reference: credit.reference,
+			Narration: "Release of deposit held for KYC balance limit",
+		})
+		if err != nil {
+			tx.Rollback(context.Background())
+			return err
+		}
+
+		if err := tx.Commit(context.Background()); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// FetchTierStatus returns the user's tier, completed checks, limits and usage today
+func FetchTierStatus(userID int) (*TierStatus, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close(context.Background())
+
+	status := &TierStatus{
+		UserID: userID,
+		Checks: map[string]bool{CheckBVN: false, CheckNIN: false, CheckPhotoID: false, CheckAddress: false},
+	}
+
+	err = db.QueryRow(context.Background(), "SELECT COALESCE(kyc_tier, 0) FROM users WHERE user_id = $1", userID).Scan(&status.Tier)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(context.Background(), "SELECT check_type FROM kyc_verification WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch verifications: %w", err)
+	}
+	for rows.Next() {
+		var check string
+		if err := rows.Scan(&check); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		status.Checks[check] = true
+	}
+	rows.Close()
+
+	if status.Limits, err = tierLimits(db, status.Tier); err != nil {
+		return nil, err
+	}
+	if status.SpentToday, err = spentToday(db, userID); err != nil {
+		return nil, err
+	}
+	if status.Balance, err = walletBalance(db, userID); err != nil && err != ledger.ErrWalletNotFound {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// userTier returns the user's stored KYC tier, treating unknown users as tier 0
+func userTier(db querier, userID int) (int, error) {
+	var tier int
+	err := db.QueryRow(context.Background(), "SELECT COALESCE(kyc_tier, 0) FROM users WHERE user_id = $1", userID).Scan(&tier)
+	if err == pgx.ErrNoRows {
+		return Tier0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch KYC tier: %w", err)
+	}
+	return tier, nil
+}
+
+// tierLimits returns the configured limits of a tier, falling back to the defaults
+func tierLimits(db querier, tier int) (Limits, error) {
+	limits := Limits{Tier: tier}
+	err := db.QueryRow(context.Background(), `
+		SELECT COALESCE(single_transaction_limit, 0), COALESCE(daily_limit, 0), COALESCE(max_balance, 0)
+		FROM kyc_tier_limit WHERE tier = $1
+	`, tier).Scan(&limits.SingleTransaction, &limits.Daily, &limits.MaxBalance)
+	if err == pgx.ErrNoRows {
+		return defaultLimits[tier], nil
+	}
+	if err != nil {
+		return Limits{}, fmt.Errorf("failed to fetch tier limits: %w", err)
+	}
+	return limits, nil
+}
+
+// spentToday totals the user's debits since midnight, leaving out fees and debits that were reversed
+func spentToday(db querier, userID int) (float64, error) {
+	var spent float64
+	err := db.QueryRow(context.Background(), `
+		SELECT COALESCE(SUM(t.amount), 0)
+		FROM user_transaction t
+		WHERE t.user_id = $1 AND t.transaction_type = 'debit' AND t.created_at >= $2
+			AND NOT EXISTS (SELECT 1 FROM fee_revenue f WHERE f.transaction_id = t.transaction_id)
+			AND NOT EXISTS (SELECT 1 FROM transaction_reversal r WHERE r.original_transaction_id = t.transaction_id)
+	`, userID, startOfDay(time.Now())).Scan(&spent)
+	if err != nil {
+		return 0, fmt.Errorf("failed to total today's debits: %w", err)
+	}
+	return spent, nil
+}
+
+// walletBalance returns the user's current wallet balance
+func walletBalance(db querier, userID int) (float64, error) {
+	var balance float64
+	err := db.QueryRow(context.Background(),
+		"SELECT current_balance FROM wallet WHERE user_id = $1 AND deleted = false LIMIT 1", userID).Scan(&balance)
+	if err == pgx.ErrNoRows {
+		return 0, ledger.ErrWalletNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve balance: %w", err)
+	}
+	return balance, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
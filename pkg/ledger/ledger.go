@@ -0,0 +1,112 @@
+package ledger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ErrInsufficientBalance is returned when a wallet cannot cover a debit
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
+// ErrWalletNotFound is returned when the user has no active wallet
+var ErrWalletNotFound = errors.New("wallet not found")
+
+// Entry represents a single movement on a user's wallet
+type Entry struct {
+	UserID      int
+	Amount      float64
+	Reference   string
+	Narration   string
+	AccountName string
+	BankName    string
+}
+
+// Debit locks the user's wallet, checks the balance and records a debit within the given transaction.
+// It returns the id of the user_transaction row created.
+func Debit(tx pgx.Tx, entry Entry) (int64, error) {
+	if entry.Amount <= 0 {
+		return 0, fmt.Errorf("debit amount must be greater than zero")
+	}
+
+	currentBalance, err := LockWallet(tx, entry.UserID)
+	if err != nil {
+		return 0, err
+	}
+	if currentBalance < entry.Amount {
+		return 0, ErrInsufficientBalance
+	}
+
+	_, err = tx.Exec(context.Background(),
+		"UPDATE wallet SET previous_balance = current_balance, current_balance = current_balance - $1 WHERE user_id = $2 AND deleted = false",
+		entry.Amount, entry.UserID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update balance: %w", err)
+	}
+
+	return insertEntry(tx, entry, "debit")
+}
+
+// Credit locks the user's wallet and records a credit within the given transaction.
+// It returns the id of the user_transaction row created.
+func Credit(tx pgx.Tx, entry Entry) (int64, error) {
+	if entry.Amount <= 0 {
+		return 0, fmt.Errorf("credit amount must be greater than zero")
+	}
+
+	if _, err := LockWallet(tx, entry.UserID); err != nil {
+		return 0, err
+	}
+
+	_, err := tx.Exec(context.Background(),
+		"UPDATE wallet SET previous_balance = current_balance, current_balance = current_balance + $1 WHERE user_id = $2 AND deleted = false",
+		entry.Amount, entry.UserID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update balance: %w", err)
+	}
+
+	return insertEntry(tx, entry, "credit")
+}
+
+// NewReference generates a unique transaction reference with the given prefix
+func NewReference(prefix string) string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%s-%d", strings.ToUpper(prefix), time.Now().UnixNano())
+	}
+	return strings.ToUpper(prefix) + "-" + hex.EncodeToString(b)
+}
+
+// LockWallet locks the user's wallet row for the rest of the transaction and returns its balance
+func LockWallet(tx pgx.Tx, userID int) (float64, error) {
+	var currentBalance float64
+	err := tx.QueryRow(context.Background(),
+		"SELECT current_balance FROM wallet WHERE user_id = $1 AND deleted = false LIMIT 1 FOR UPDATE", userID).Scan(&currentBalance)
+	if err == pgx.ErrNoRows {
+		return 0, ErrWalletNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve balance: %w", err)
+	}
+	return currentBalance, nil
+}
+
+// insertEntry records the wallet movement in user_transaction
+func insertEntry(tx pgx.Tx, entry Entry, transactionType string) (int64, error) {
+	var transactionID int64
+	err := tx.QueryRow(context.Background(), `
+		INSERT INTO user_transaction (user_id, reference, amount, transaction_type, narration, account_name, bank_name)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING transaction_id
+	`, entry.UserID, entry.Reference, entry.Amount, transactionType, entry.Narration, entry.AccountName, entry.BankName).Scan(&transactionID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save transaction data: %w", err)
+	}
+	return transactionID, nil
+}
@@ -0,0 +1,300 @@
+package msisdn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/auth"
+)
+
+// Nigerian mobile networks
+const (
+	MTN        = "mtn"
+	Airtel     = "airtel"
+	Glo        = "glo"
+	NineMobile = "9mobile"
+)
+
+// ErrInvalidNumber is returned for numbers that are not Nigerian mobile numbers
+var ErrInvalidNumber = errors.New("invalid phone number")
+
+// ErrUnknownNetwork is returned when a number's prefix is not allocated to a known network
+var ErrUnknownNetwork = errors.New("unknown mobile network")
+
+// prefixes maps number prefixes in local format to the network they are allocated to.
+// Five digit prefixes take precedence over four digit ones.
+var prefixes = map[string]string{
+	"0703": MTN, "0704": MTN, "0706": MTN, "0803": MTN, "0806": MTN, "0810": MTN,
+	"0813": MTN, "0814": MTN, "0816": MTN, "0903": MTN, "0906": MTN, "0913": MTN,
+	"0916": MTN, "07025": MTN, "07026": MTN,
+	"0701": Airtel, "0708": Airtel, "0802": Airtel, "0808": Airtel, "0812": Airtel,
+	"0901": Airtel, "0902": Airtel, "0904": Airtel, "0907": Airtel, "0911": Airtel, "0912": Airtel,
+	"0705": Glo, "0805": Glo, "0807": Glo, "0811": Glo, "0815": Glo, "0905": Glo, "0915": Glo,
+	"0809": NineMobile, "0817": NineMobile, "0818": NineMobile, "0908": NineMobile, "0909": NineMobile,
+}
+
+var nonDigits = regexp.MustCompile(`[^0-9]`)
+
+// lookupPorted returns the network a number has been ported to; tests replace it to avoid the database
+var lookupPorted = portedNetwork
+
+// Lookup represents the network a number belongs to
+type Lookup struct {
+	MSISDN  string `json:"msisdn"`
+	Local   string `json:"local"`
+	Network string `json:"network"`
+	Ported  bool   `json:"ported"`
+}
+
+// PortedNumber represents a number that has moved to a different network than its prefix suggests
+type PortedNumber struct {
+	MSISDN    string    `json:"msisdn"`
+	Network   string    `json:"network"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PortedNumberRequest represents the request payload for recording a ported number
+type PortedNumberRequest struct {
+	Number  string `json:"number"`
+	Network string `json:"network"`
+}
+
+// Response represents the generic response structure
+type Response struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message"`
+	Result  interface{} `json:"result,omitempty"`
+}
+
+// LookupHandler returns the network of a phone number
+func LookupHandler(c *gin.Context) {
+	lookup, err := Detect(c.Query("number"))
+	if err == ErrInvalidNumber || err == ErrUnknownNetwork {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to look up number: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Network detected successfully",
+		Result:  lookup,
+	})
+}
+
+// SavePortedNumberHandler lets support record the network a ported number has moved to
+func SavePortedNumberHandler(c *gin.Context) {
+	var request PortedNumberRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON input: " + err.Error(),
+		})
+		return
+	}
+
+	number, err := Normalise(request.Number)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	network := ParseNetwork(request.Network)
+	if network == "" {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Network must be one of mtn, airtel, glo or 9mobile",
+		})
+		return
+	}
+
+	staff, _ := auth.StaffFromContext(c)
+	ported := PortedNumber{MSISDN: number, Network: network, CreatedBy: "staff:" + staff.Name}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to connect to database: " + err.Error(),
+		})
+		return
+	}
+	defer db.Close(context.Background())
+
+	err = db.QueryRow(context.Background(), `
+		INSERT INTO ported_number (msisdn, network, created_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (msisdn) DO UPDATE SET network = EXCLUDED.network, created_by = EXCLUDED.created_by, created_at = NOW()
+		RETURNING created_at
+	`, ported.MSISDN, ported.Network, ported.CreatedBy).Scan(&ported.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to save ported number: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Ported number saved successfully",
+		Result:  ported,
+	})
+}
+
+// DeletePortedNumberHandler removes a ported number override
+func DeletePortedNumberHandler(c *gin.Context) {
+	number, err := Normalise(c.Param("number"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to connect to database: " + err.Error(),
+		})
+		return
+	}
+	defer db.Close(context.Background())
+
+	result, err := db.Exec(context.Background(), "DELETE FROM ported_number WHERE msisdn = $1", number)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to delete ported number: " + err.Error(),
+		})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Number is not recorded as ported",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Ported number deleted successfully",
+	})
+}
+
+// Normalise returns a Nigerian mobile number in international format without the plus, e.g. 2348031234567.
+// It accepts local numbers, numbers with a +234 or 234 prefix and numbers missing the leading zero.
+func Normalise(number string) (string, error) {
+	digits := nonDigits.ReplaceAllString(number, "")
+	switch {
+	case len(digits) == 13 && strings.HasPrefix(digits, "234"):
+	case len(digits) == 11 && strings.HasPrefix(digits, "0"):
+		digits = "234" + digits[1:]
+	case len(digits) == 10 && !strings.HasPrefix(digits, "0"):
+		digits = "234" + digits
+	default:
+		return "", ErrInvalidNumber
+	}
+
+	if digits[3] != '7' && digits[3] != '8' && digits[3] != '9' {
+		return "", ErrInvalidNumber
+	}
+	return digits, nil
+}
+
+// Local returns a normalised number in local format, e.g. 08031234567
+func Local(msisdn string) string {
+	return "0" + strings.TrimPrefix(msisdn, "234")
+}
+
+// Detect returns the network of a number, preferring a recorded port over the prefix allocation
+func Detect(number string) (*Lookup, error) {
+	msisdn, err := Normalise(number)
+	if err != nil {
+		return nil, err
+	}
+	lookup := &Lookup{MSISDN: msisdn, Local: Local(msisdn)}
+
+	network, err := lookupPorted(msisdn)
+	if err != nil {
+		return nil, err
+	}
+	if network != "" {
+		lookup.Network = network
+		lookup.Ported = true
+		return lookup, nil
+	}
+
+	lookup.Network = prefixNetwork(lookup.Local)
+	if lookup.Network == "" {
+		return nil, ErrUnknownNetwork
+	}
+	return lookup, nil
+}
+
+// ParseNetwork maps the network names used by customers and providers onto our network names.
+// It returns an empty string for unknown networks.
+func ParseNetwork(name string) string {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "mtn":
+		return MTN
+	case "airtel":
+		return Airtel
+	case "glo", "globacom":
+		return Glo
+	case "9mobile", "etisalat":
+		return NineMobile
+	}
+	return ""
+}
+
+// prefixNetwork returns the network a local number's prefix is allocated to
+func prefixNetwork(local string) string {
+	if network, ok := prefixes[local[:5]]; ok {
+		return network
+	}
+	return prefixes[local[:4]]
+}
+
+// portedNetwork returns the network a number has been ported to, or an empty string if it has not been ported
+func portedNetwork(msisdn string) (string, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close(context.Background())
+
+	var network string
+	err = db.QueryRow(context.Background(), "SELECT network FROM ported_number WHERE msisdn = $1", msisdn).Scan(&network)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch ported number: %w", err)
+	}
+	return network, nil
+}
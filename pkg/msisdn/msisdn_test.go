@@ -0,0 +1,70 @@
+package msisdn
+
+import "testing"
+
+func TestNormalise(t *testing.T) {
+	tests := []struct {
+		name    string
+		number  string
+		want    string
+		wantErr error
+	}{
+		{"local", "08031234567", "2348031234567", nil},
+		{"international", "2348031234567", "2348031234567", nil},
+		{"international with plus", "+2348031234567", "2348031234567", nil},
+		{"missing leading zero", "8031234567", "2348031234567", nil},
+		{"spaces and dashes", "+234 803-123-4567", "2348031234567", nil},
+		{"too short", "0803123456", "", ErrInvalidNumber},
+		{"too long", "080312345678", "", ErrInvalidNumber},
+		{"landline", "01234567890", "", ErrInvalidNumber},
+		{"foreign country code", "+2448031234567", "", ErrInvalidNumber},
+		{"empty", "", "", ErrInvalidNumber},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Normalise(tt.number)
+			if err != tt.wantErr {
+				t.Fatalf("Normalise(%q) error = %v, want %v", tt.number, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Normalise(%q) = %q, want %q", tt.number, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	ported := map[string]string{"2348031234567": Airtel}
+	lookupPorted = func(msisdn string) (string, error) { return ported[msisdn], nil }
+	defer func() { lookupPorted = portedNetwork }()
+
+	tests := []struct {
+		name    string
+		number  string
+		want    Lookup
+		wantErr error
+	}{
+		{"local MTN", "08061234567", Lookup{MSISDN: "2348061234567", Local: "08061234567", Network: MTN}, nil},
+		{"international Glo", "2348051234567", Lookup{MSISDN: "2348051234567", Local: "08051234567", Network: Glo}, nil},
+		{"plus prefixed 9mobile", "+2349091234567", Lookup{MSISDN: "2349091234567", Local: "09091234567", Network: NineMobile}, nil},
+		{"five digit prefix", "07025123456", Lookup{MSISDN: "2347025123456", Local: "07025123456", Network: MTN}, nil},
+		{"four digit prefix", "07021234567", Lookup{}, ErrUnknownNetwork},
+		{"ported number", "+2348031234567", Lookup{MSISDN: "2348031234567", Local: "08031234567", Network: Airtel, Ported: true}, nil},
+		{"ported number in local form", "08031234567", Lookup{MSISDN: "2348031234567", Local: "08031234567", Network: Airtel, Ported: true}, nil},
+		{"unallocated prefix", "07991234567", Lookup{}, ErrUnknownNetwork},
+		{"invalid number", "12345", Lookup{}, ErrInvalidNumber},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Detect(tt.number)
+			if err != tt.wantErr {
+				t.Fatalf("Detect(%q) error = %v, want %v", tt.number, err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("Detect(%q) = %+v, want %+v", tt.number, *got, tt.want)
+			}
+		})
+	}
+}
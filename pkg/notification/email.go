@@ -0,0 +1,95 @@
+package notification
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// Attachment represents a file attached to an outgoing email
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// SendEmail sends an email with the specified subject, body and optional attachments
+func SendEmail(to string, subject string, body string, attachments ...Attachment) error {
+	from := os.Getenv("SMTP_FROM")
+	pass := os.Getenv("SMTP_PASS")
+	smtpHost := os.Getenv("SMTP_HOST")
+	smtpPort := os.Getenv("SMTP_PORT")
+
+	msg, err := buildMessage(from, to, subject, body, attachments)
+	if err != nil {
+		return err
+	}
+
+	auth := smtp.PlainAuth("", from, pass, smtpHost)
+	return smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{to}, msg)
+}
+
+// buildMessage builds a MIME message, using multipart/mixed only when there are attachments
+func buildMessage(from, to, subject, body string, attachments []Attachment) ([]byte, error) {
+	var msg bytes.Buffer
+	msg.WriteString("From: " + from + "\r\n")
+	msg.WriteString("To: " + to + "\r\n")
+	msg.WriteString("Subject: " + subject + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+
+	if len(attachments) == 0 {
+		msg.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+		msg.WriteString(body)
+		return msg.Bytes(), nil
+	}
+
+	boundary, err := randomBoundary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate MIME boundary: %w", err)
+	}
+
+	msg.WriteString("Content-Type: multipart/mixed; boundary=\"" + boundary + "\"\r\n\r\n")
+	msg.WriteString("--" + boundary + "\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+	msg.WriteString(body + "\r\n")
+
+	for _, attachment := range attachments {
+		contentType := attachment.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		msg.WriteString("--" + boundary + "\r\n")
+		msg.WriteString("Content-Type: " + contentType + "; name=\"" + attachment.Filename + "\"\r\n")
+		msg.WriteString("Content-Transfer-Encoding: base64\r\n")
+		msg.WriteString("Content-Disposition: attachment; filename=\"" + attachment.Filename + "\"\r\n\r\n")
+		msg.WriteString(wrapBase64(base64.StdEncoding.EncodeToString(attachment.Data)))
+	}
+	msg.WriteString("--" + boundary + "--\r\n")
+
+	return msg.Bytes(), nil
+}
+
+// randomBoundary generates a random MIME boundary
+func randomBoundary() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "gollet-" + hex.EncodeToString(b), nil
+}
+
+// wrapBase64 splits base64 content into 76 character lines as required by RFC 2045
+func wrapBase64(encoded string) string {
+	var sb strings.Builder
+	for len(encoded) > 76 {
+		sb.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	sb.WriteString(encoded + "\r\n")
+	return sb.String()
+}
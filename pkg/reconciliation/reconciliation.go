@@ -0,0 +1,520 @@
+package reconciliation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/auth"
+	"go_code/pkg/notification"
+)
+
+// Reconciliation sources
+const (
+	SourcePaystackTransaction = "paystack_transaction"
+	SourcePaystackTransfer    = "paystack_transfer"
+	SourceVASPurchase         = "vas_purchase"
+)
+
+// Reconciliation issues
+const (
+	// IssueMissingInternal is a provider record we have no record of
+	IssueMissingInternal = "missing_internal"
+	// IssueMissingProvider is a record of ours the provider does not have
+	IssueMissingProvider = "missing_provider"
+	IssueDuplicate       = "duplicate"
+	IssueAmountMismatch  = "amount_mismatch"
+	IssueStatusMismatch  = "status_mismatch"
+)
+
+// Run and item statuses
+const (
+	RunRunning   = "running"
+	RunCompleted = "completed"
+	RunFailed    = "failed"
+
+	ItemOpen     = "open"
+	ItemResolved = "resolved"
+	ItemIgnored  = "ignored"
+)
+
+// runTimeout is how long a run may stay running before another run of the same day may take over
+const runTimeout = time.Hour
+
+// ErrRunInProgress is returned when the day is already being reconciled
+var ErrRunInProgress = errors.New("reconciliation of this day is already running")
+
+// Run represents the reconciliation of one day
+type Run struct {
+	RunID       int64      `json:"run_id"`
+	RunDate     string     `json:"run_date"`
+	Status      string     `json:"status"`
+	OpenItems   int        `json:"open_items"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// Item represents a difference between our records and a provider's
+type Item struct {
+	ItemID         int64      `json:"item_id"`
+	RunID          int64      `json:"run_id"`
+	Source         string     `json:"source"`
+	Reference      string     `json:"reference"`
+	Issue          string     `json:"issue"`
+	InternalAmount *float64   `json:"internal_amount,omitempty"`
+	ProviderAmount *float64   `json:"provider_amount,omitempty"`
+	InternalStatus string     `json:"internal_status,omitempty"`
+	ProviderStatus string     `json:"provider_status,omitempty"`
+	Details        string     `json:"details"`
+	Status         string     `json:"status"`
+	ResolutionNote string     `json:"resolution_note,omitempty"`
+	ResolvedBy     string     `json:"resolved_by,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// RunRequest represents the request payload for reconciling a day
+type RunRequest struct {
+	Date string `json:"date"` // YYYY-MM-DD, defaults to yesterday
+}
+
+// ResolveRequest represents the request payload for closing a reconciliation item
+type ResolveRequest struct {
+	Status string `json:"status"` // resolved or ignored
+	Note   string `json:"note"`
+}
+
+// Response represents the generic response structure
+type Response struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message"`
+	Result  interface{} `json:"result,omitempty"`
+}
+
+const runColumns = `run_id, to_char(run_date, 'YYYY-MM-DD'), status, open_items, COALESCE(error, ''), started_at, completed_at`
+
+const itemColumns = `item_id, run_id, source, reference, issue, internal_amount, provider_amount, COALESCE(internal_status, ''),
+	COALESCE(provider_status, ''), details, status, COALESCE(resolution_note, ''), COALESCE(resolved_by, ''), resolved_at, created_at`
+
+// RunReconciliationHandler reconciles a day on demand. Items already resolved or ignored are kept and the
+// open ones are replaced.
+func RunReconciliationHandler(c *gin.Context) {
+	// The body is optional, an empty one reconciles yesterday
+	var request RunRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Malformed JSON request: " + err.Error(),
+		})
+		return
+	}
+
+	day := yesterday()
+	if request.Date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", request.Date, time.Local)
+		if err != nil || !parsed.Before(startOfDay(time.Now())) {
+			c.JSON(http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: "date must be a past day in YYYY-MM-DD format",
+			})
+			return
+		}
+		day = parsed
+	}
+
+	run, err := Reconcile(day)
+	if errors.Is(err, ErrRunInProgress) {
+		c.JSON(http.StatusConflict, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, Response{
+			Status:  "error",
+			Message: "Reconciliation failed: " + err.Error(),
+			Result:  run,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Reconciliation completed",
+		Result:  run,
+	})
+}
+
+// ListRunsHandler lists the most recent reconciliation runs
+func ListRunsHandler(c *gin.Context) {
+	runs, err := fetchRuns()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch reconciliation runs: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Reconciliation runs retrieved successfully",
+		Result:  runs,
+	})
+}
+
+// RunItemsHandler returns a run's report. The status query parameter filters items by open, resolved or ignored.
+func RunItemsHandler(c *gin.Context) {
+	runID, err := strconv.ParseInt(c.Param("run_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid run_id parameter",
+		})
+		return
+	}
+
+	status := c.Query("status")
+	if status != "" && status != ItemOpen && status != ItemResolved && status != ItemIgnored {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "status must be open, resolved or ignored",
+		})
+		return
+	}
+
+	items, err := fetchItems(runID, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch reconciliation items: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Reconciliation items retrieved successfully",
+		Result:  items,
+	})
+}
+
+// ResolveItemHandler closes a reconciliation item as resolved or ignored with a note
+func ResolveItemHandler(c *gin.Context) {
+	itemID, err := strconv.ParseInt(c.Param("item_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid item_id parameter",
+		})
+		return
+	}
+
+	var request ResolveRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Malformed JSON request: " + err.Error(),
+		})
+		return
+	}
+	request.Note = strings.TrimSpace(request.Note)
+	if (request.Status != ItemResolved && request.Status != ItemIgnored) || request.Note == "" {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "status must be resolved or ignored, and a note is required",
+		})
+		return
+	}
+
+	staff, _ := auth.StaffFromContext(c)
+	item, err := resolveItem(itemID, request.Status, request.Note, staff.Name)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Open reconciliation item not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to resolve reconciliation item: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Reconciliation item " + item.Status,
+		Result:  item,
+	})
+}
+
+// StartReconciliation reconciles the previous day, once it has not been reconciled yet, every interval
+// in a background goroutine
+func StartReconciliation(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			day := yesterday()
+			done, err := reconciled(day)
+			if err != nil {
+				log.Printf("Failed to check reconciliation: %v\n", err)
+				continue
+			}
+			if done {
+				continue
+			}
+			if _, err := Reconcile(day); err != nil && !errors.Is(err, ErrRunInProgress) {
+				log.Printf("Reconciliation of %s failed: %v\n", day.Format("2006-01-02"), err)
+			}
+		}
+	}()
+}
+
+// Reconcile matches a day of Paystack collections and transfers and VAS purchases against our records and
+// saves the differences found. The admin is emailed when there are open items.
+func Reconcile(day time.Time) (*Run, error) {
+	from := startOfDay(day)
+	to := from.AddDate(0, 0, 1)
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	run, err := scanRun(db.QueryRow(context.Background(), `
+		INSERT INTO reconciliation_run (run_date, status, open_items, started_at)
+		VALUES ($1, $2, 0, NOW())
+		ON CONFLICT (run_date) DO UPDATE
+		SET status = EXCLUDED.status, error = NULL, started_at = NOW(), completed_at = NULL
+		WHERE reconciliation_run.status <> $2 OR reconciliation_run.started_at < NOW() - $3 * INTERVAL '1 second'
+		RETURNING `+runColumns, from.Format("2006-01-02"), RunRunning, runTimeout.Seconds()))
+	if err == pgx.ErrNoRows {
+		return nil, ErrRunInProgress
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to start reconciliation: %w", err)
+	}
+
+	var items []Item
+	for _, reconcileSource := range []func(*pgx.Conn, time.Time, time.Time) ([]Item, error){
+		reconcileCollections,
+		reconcileTransfers,
+		reconcileVASPurchases,
+	} {
+		found, err := reconcileSource(db, from, to)
+		if err != nil {
+			return failRun(db, run, err)
+		}
+		items = append(items, found...)
+	}
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return failRun(db, run, err)
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(context.Background(), "DELETE FROM reconciliation_item WHERE run_id = $1 AND status = $2", run.RunID, ItemOpen); err != nil {
+		return failRun(db, run, err)
+	}
+	for _, item := range items {
+		_, err := tx.Exec(context.Background(), `
+			INSERT INTO reconciliation_item (run_id, source, reference, issue, internal_amount, provider_amount, internal_status,
+				provider_status, details, status, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), $9, $10, NOW())
+			ON CONFLICT (run_id, source, reference, issue) DO NOTHING
+		`, run.RunID, item.Source, item.Reference, item.Issue, item.InternalAmount, item.ProviderAmount, item.InternalStatus,
+			item.ProviderStatus, item.Details, ItemOpen)
+		if err != nil {
+			return failRun(db, run, fmt.Errorf("failed to save reconciliation item: %w", err))
+		}
+	}
+
+	completed, err := scanRun(tx.QueryRow(context.Background(), `
+		UPDATE reconciliation_run
+		SET status = $2, completed_at = NOW(),
+			open_items = (SELECT COUNT(*) FROM reconciliation_item WHERE run_id = $1 AND status = $3)
+		WHERE run_id = $1
+		RETURNING `+runColumns, run.RunID, RunCompleted, ItemOpen))
+	if err != nil {
+		return failRun(db, run, err)
+	}
+	if err := tx.Commit(context.Background()); err != nil {
+		return failRun(db, run, err)
+	}
+
+	if completed.OpenItems > 0 {
+		body := fmt.Sprintf("Reconciliation of %s found %d open items that need review (run %d).",
+			completed.RunDate, completed.OpenItems, completed.RunID)
+		if err := notification.SendEmail(os.Getenv("ADMIN_EMAIL"), "Reconciliation needs review", body); err != nil {
+			fmt.Println("Failed to send email:", err)
+		}
+	}
+	return completed, nil
+}
+
+// failRun records why a run failed and returns the error
+func failRun(db *pgx.Conn, run *Run, runErr error) (*Run, error) {
+	failed, err := scanRun(db.QueryRow(context.Background(), `
+		UPDATE reconciliation_run SET status = $2, error = $3, completed_at = NOW()
+		WHERE run_id = $1
+		RETURNING `+runColumns, run.RunID, RunFailed, runErr.Error()))
+	if err != nil {
+		log.Printf("Failed to record failed reconciliation %d: %v\n", run.RunID, err)
+		return run, runErr
+	}
+	return failed, runErr
+}
+
+// reconciled reports whether the day has a completed run
+func reconciled(day time.Time) (bool, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return false, err
+	}
+	defer db.Close(context.Background())
+
+	var done bool
+	err = db.QueryRow(context.Background(),
+		"SELECT EXISTS (SELECT 1 FROM reconciliation_run WHERE run_date = $1 AND status = $2)",
+		startOfDay(day).Format("2006-01-02"), RunCompleted).Scan(&done)
+	return done, err
+}
+
+// fetchRuns returns the 30 most recent runs
+func fetchRuns() ([]Run, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), "SELECT "+runColumns+" FROM reconciliation_run ORDER BY run_date DESC LIMIT 30")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, *run)
+	}
+	return runs, rows.Err()
+}
+
+// fetchItems returns a run's items, optionally only those with the given status
+func fetchItems(runID int64, status string) ([]Item, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		SELECT `+itemColumns+`
+		FROM reconciliation_item
+		WHERE run_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY source, issue, reference
+	`, runID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []Item{}
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *item)
+	}
+	return items, rows.Err()
+}
+
+// resolveItem closes an open item and updates its run's open item count
+func resolveItem(itemID int64, status, note, resolvedBy string) (*Item, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	item, err := scanItem(tx.QueryRow(context.Background(), `
+		UPDATE reconciliation_item
+		SET status = $2, resolution_note = $3, resolved_by = $4, resolved_at = NOW()
+		WHERE item_id = $1 AND status = $5
+		RETURNING `+itemColumns, itemID, status, note, resolvedBy, ItemOpen))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(context.Background(),
+		"UPDATE reconciliation_run SET open_items = GREATEST(open_items - 1, 0) WHERE run_id = $1", item.RunID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// yesterday returns the start of the previous day
+func yesterday() time.Time {
+	return startOfDay(time.Now()).AddDate(0, 0, -1)
+}
+
+// startOfDay returns midnight at the start of t's day
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+func scanRun(row pgx.Row) (*Run, error) {
+	var run Run
+	err := row.Scan(&run.RunID, &run.RunDate, &run.Status, &run.OpenItems, &run.Error, &run.StartedAt, &run.CompletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func scanItem(row pgx.Row) (*Item, error) {
+	var item Item
+	err := row.Scan(&item.ItemID, &item.RunID, &item.Source, &item.Reference, &item.Issue, &item.InternalAmount,
+		&item.ProviderAmount, &item.InternalStatus, &item.ProviderStatus, &item.Details, &item.Status, &item.ResolutionNote,
+		&item.ResolvedBy, &item.ResolvedAt, &item.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
@@ -0,0 +1,441 @@
+package reconciliation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"go_code/pkg/bill"
+)
+
+// paystackPageSize is the number of records requested per page from Paystack's list endpoints
+const paystackPageSize = 100
+
+// paystackTransaction represents a collection listed by Paystack
+type paystackTransaction struct {
+	Reference string  `json:"reference"`
+	Status    string  `json:"status"`
+	Amount    float64 `json:"amount"`
+	Channel   string  `json:"channel"`
+	FeesSplit *struct {
+		Subaccount float64 `json:"subaccount"`
+	} `json:"fees_split"`
+}
+
+// paystackTransfer represents a transfer listed by Paystack
+type paystackTransfer struct {
+	Reference    string  `json:"reference"`
+	Status       string  `json:"status"`
+	Amount       float64 `json:"amount"`
+	TransferCode string  `json:"transfer_code"`
+}
+
+// paystackListMeta represents the pagination of a Paystack list response
+type paystackListMeta struct {
+	Page      int `json:"page"`
+	PageCount int `json:"pageCount"`
+}
+
+// internalRecord is our side of a provider record
+type internalRecord struct {
+	amount float64
+	status string
+}
+
+// reconcileCollections matches the successful collections Paystack lists for the day, dedicated account
+// deposits and card top-ups, against the wallet credits and held deposits recorded for them
+func reconcileCollections(db *pgx.Conn, from, to time.Time) ([]Item, error) {
+	var transactions []paystackTransaction
+	err := listPaystack("/transaction", from, to, url.Values{"status": {"success"}}, func(data json.RawMessage) error {
+		var page []paystackTransaction
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		transactions = append(transactions, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Paystack transactions: %w", err)
+	}
+
+	references := make([]string, 0, len(transactions))
+	for _, transaction := range transactions {
+		references = append(references, transaction.Reference)
+	}
+
+	// Released held deposits are credited under the same reference, so only unreleased ones count
+	records, err := internalRecords(db, `
+		SELECT reference, amount, 'success' FROM user_transaction WHERE transaction_type = 'credit' AND reference = ANY($1)
+		UNION ALL
+		SELECT reference, amount, 'held' FROM held_credit WHERE released_at IS NULL AND reference = ANY($1)
+	`, references)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []Item
+	seen := make(map[string]bool, len(transactions))
+	for _, transaction := range transactions {
+		seen[transaction.Reference] = true
+		// The subaccount's share of a split deposit is never credited to the wallet
+		amount := transaction.Amount
+		if transaction.FeesSplit != nil {
+			amount -= transaction.FeesSplit.Subaccount
+		}
+		items = append(items, compare(SourcePaystackTransaction, transaction.Reference, amount/100, "success", records[transaction.Reference])...)
+	}
+
+	ours, err := internalRecords(db, `
+		SELECT reference, amount, 'success' FROM user_transaction
+		WHERE transaction_type = 'credit' AND customer_code IS NOT NULL AND created_at >= $1 AND created_at < $2
+		UNION ALL
+		SELECT reference, amount, status FROM card_funding
+		WHERE status IN ('success', 'held') AND created_at >= $1 AND created_at < $2
+		UNION ALL
+		SELECT h.reference, h.amount, 'held' FROM held_credit h
+		WHERE h.created_at >= $1 AND h.created_at < $2 AND NOT EXISTS (SELECT 1 FROM card_funding f WHERE f.reference = h.reference)
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	items = append(items, missingAtProvider(SourcePaystackTransaction, ours, seen)...)
+	return items, nil
+}
+
+// reconcileTransfers matches the transfers Paystack lists for the day against the wallet debits of single
+// transfers and the lines of bulk transfers
+func reconcileTransfers(db *pgx.Conn, from, to time.Time) ([]Item, error) {
+	var transfers []paystackTransfer
+	err := listPaystack("/transfer", from, to, nil, func(data json.RawMessage) error {
+		var page []paystackTransfer
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		transfers = append(transfers, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Paystack transfers: %w", err)
+	}
+
+	references := make([]string, 0, len(transfers))
+	for _, transfer := range transfers {
+		references = append(references, transfer.Reference)
+	}
+
+	// Fee debits share the transfer's reference and are left out
+	const transferRecords = `
+		SELECT t.reference, t.amount,
+			CASE WHEN EXISTS (SELECT 1 FROM transaction_reversal r WHERE r.original_transaction_id = t.transaction_id)
+				THEN 'reversed' ELSE 'success' END
+		FROM user_transaction t
+		WHERE t.transaction_type = 'debit' AND %s
+			AND NOT EXISTS (SELECT 1 FROM fee_revenue f WHERE f.transaction_id = t.transaction_id)
+		UNION ALL
+		SELECT reference, amount, status FROM bulk_transfer_item WHERE %s
+	`
+	records, err := internalRecords(db, fmt.Sprintf(transferRecords, "t.reference = ANY($1)", "reference = ANY($1)"), references)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []Item
+	seen := make(map[string]bool, len(transfers))
+	for _, transfer := range transfers {
+		seen[transfer.Reference] = true
+		items = append(items, compare(SourcePaystackTransfer, transfer.Reference, transfer.Amount/100, transfer.Status, records[transfer.Reference])...)
+	}
+
+	ours, err := internalRecords(db, fmt.Sprintf(transferRecords,
+		"t.reference LIKE 'TRF-%%' AND t.created_at >= $1 AND t.created_at < $2",
+		"transfer_code IS NOT NULL AND created_at >= $1 AND created_at < $2"), from, to)
+	if err != nil {
+		return nil, err
+	}
+	items = append(items, missingAtProvider(SourcePaystackTransfer, ours, seen)...)
+	return items, nil
+}
+
+// reconcileVASPurchases matches Dojah's transaction history for the day against our purchases by Dojah's
+// reference. Other providers only report a purchase's status, so their purchases are checked one by one
+// and amounts cannot be compared.
+func reconcileVASPurchases(db *pgx.Conn, from, to time.Time) ([]Item, error) {
+	items, err := reconcileDojahPurchases(db, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT reference, provider, COALESCE(provider_reference, ''), amount, status,
+			COUNT(*) OVER (PARTITION BY provider, provider_reference)
+		FROM vas_purchase
+		WHERE created_at >= $1 AND created_at < $2 AND provider <> 'dojah'
+		ORDER BY created_at
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	type purchase struct {
+		reference, provider, providerReference, status string
+		amount                                         float64
+		count                                          int
+	}
+	var purchases []purchase
+	for rows.Next() {
+		var p purchase
+		if err := rows.Scan(&p.reference, &p.provider, &p.providerReference, &p.amount, &p.status, &p.count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		purchases = append(purchases, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, p := range purchases {
+		item := Item{Source: SourceVASPurchase, Reference: p.reference, InternalAmount: &p.amount, InternalStatus: p.status}
+		if p.providerReference == "" {
+			item.Issue = IssueMissingProvider
+			item.Details = p.provider + " returned no reference for the purchase"
+			items = append(items, item)
+			continue
+		}
+		if p.count > 1 {
+			item.Issue = IssueDuplicate
+			item.Details = fmt.Sprintf("%d purchases share %s reference %s", p.count, p.provider, p.providerReference)
+			items = append(items, item)
+		}
+
+		result, err := bill.QueryPurchaseStatus(p.provider, p.providerReference)
+		if err != nil {
+			item.Issue = IssueMissingProvider
+			item.Details = err.Error()
+			items = append(items, item)
+			continue
+		}
+		if settled(result.Status) && settled(p.status) && result.Status != p.status {
+			item.Issue = IssueStatusMismatch
+			item.ProviderStatus = result.Status
+			item.Details = fmt.Sprintf("%s reports the purchase %s", p.provider, result.Status)
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// reconcileDojahPurchases matches the purchases Dojah lists for the day against our Dojah purchases by
+// Dojah's reference. Dojah does not see our reference, so items carry Dojah's.
+func reconcileDojahPurchases(db *pgx.Conn, from, to time.Time) ([]Item, error) {
+	purchases, err := bill.ListDojahPurchases(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Dojah transactions: %w", err)
+	}
+
+	references := make([]string, 0, len(purchases))
+	for _, purchase := range purchases {
+		references = append(references, purchase.ReferenceID)
+	}
+
+	records, err := internalRecords(db, `
+		SELECT provider_reference, amount, status FROM vas_purchase WHERE provider = 'dojah' AND provider_reference = ANY($1)
+	`, references)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []Item
+	seen := make(map[string]bool, len(purchases))
+	for _, purchase := range purchases {
+		seen[purchase.ReferenceID] = true
+		items = append(items, compare(SourceVASPurchase, purchase.ReferenceID, purchase.Amount, purchase.Status, records[purchase.ReferenceID])...)
+	}
+
+	// Purchases Dojah returned no reference for cannot be matched and are reported on their own
+	rows, err := db.Query(context.Background(), `
+		SELECT reference, amount, status FROM vas_purchase
+		WHERE provider = 'dojah' AND provider_reference IS NULL AND created_at >= $1 AND created_at < $2
+	`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch internal records: %w", err)
+	}
+	for rows.Next() {
+		item := Item{Source: SourceVASPurchase, Issue: IssueMissingProvider, Details: "dojah returned no reference for the purchase"}
+		var amount float64
+		if err := rows.Scan(&item.Reference, &amount, &item.InternalStatus); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		item.InternalAmount = &amount
+		items = append(items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ours, err := internalRecords(db, `
+		SELECT provider_reference, amount, status FROM vas_purchase
+		WHERE provider = 'dojah' AND provider_reference IS NOT NULL AND created_at >= $1 AND created_at < $2
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	items = append(items, missingAtProvider(SourceVASPurchase, ours, seen)...)
+	return items, nil
+}
+
+// compare reports the differences between a provider record and the internal records under its reference
+func compare(source, reference string, providerAmount float64, providerStatus string, records []internalRecord) []Item {
+	item := Item{Source: source, Reference: reference, ProviderAmount: &providerAmount, ProviderStatus: providerStatus}
+
+	if len(records) == 0 {
+		// Transfers Paystack rejected never left the wallet
+		if providerStatus == "failed" || providerStatus == "reversed" {
+			return nil
+		}
+		item.Issue = IssueMissingInternal
+		item.Details = "Provider has a record we do not"
+		return []Item{item}
+	}
+
+	record := records[0]
+	item.InternalAmount = &record.amount
+	item.InternalStatus = record.status
+
+	var items []Item
+	if len(records) > 1 {
+		duplicate := item
+		duplicate.Issue = IssueDuplicate
+		duplicate.Details = fmt.Sprintf("%d records share the reference", len(records))
+		items = append(items, duplicate)
+	}
+	if math.Abs(record.amount-providerAmount) >= 0.01 {
+		mismatch := item
+		mismatch.Issue = IssueAmountMismatch
+		mismatch.Details = fmt.Sprintf("Provider amount %.2f, ours %.2f", providerAmount, record.amount)
+		items = append(items, mismatch)
+	}
+	if settled(providerStatus) && settled(record.status) && (providerStatus == "success") != (record.status == "success" || record.status == "held") {
+		mismatch := item
+		mismatch.Issue = IssueStatusMismatch
+		mismatch.Details = fmt.Sprintf("Provider reports %s, ours is %s", providerStatus, record.status)
+		items = append(items, mismatch)
+	}
+	return items
+}
+
+// missingAtProvider reports internal records whose reference the provider did not list.
+// Records that failed or were reversed are not expected at the provider.
+func missingAtProvider(source string, records map[string][]internalRecord, seen map[string]bool) []Item {
+	var items []Item
+	for reference, matches := range records {
+		record := matches[0]
+		if seen[reference] || record.status == "failed" || record.status == "reversed" {
+			continue
+		}
+		items = append(items, Item{
+			Source:         source,
+			Reference:      reference,
+			Issue:          IssueMissingProvider,
+			InternalAmount: &record.amount,
+			InternalStatus: record.status,
+			Details:        "We have a record the provider does not",
+		})
+	}
+	return items
+}
+
+// internalRecords runs a query returning reference, amount and status rows and groups them by reference
+func internalRecords(db *pgx.Conn, query string, args ...interface{}) (map[string][]internalRecord, error) {
+	rows, err := db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch internal records: %w", err)
+	}
+	defer rows.Close()
+
+	records := make(map[string][]internalRecord)
+	for rows.Next() {
+		var reference string
+		var record internalRecord
+		if err := rows.Scan(&reference, &record.amount, &record.status); err != nil {
+			return nil, err
+		}
+		records[reference] = append(records[reference], record)
+	}
+	return records, rows.Err()
+}
+
+// settled reports whether a status is final on either side
+func settled(status string) bool {
+	switch status {
+	case "success", "held", "failed", "reversed":
+		return true
+	}
+	return false
+}
+
+// listPaystack pages through a Paystack list endpoint for the period, passing each page's data to handle
+func listPaystack(path string, from, to time.Time, query url.Values, handle func(json.RawMessage) error) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("from", from.Format(time.RFC3339))
+	query.Set("to", to.Format(time.RFC3339))
+	query.Set("perPage", strconv.Itoa(paystackPageSize))
+
+	for page := 1; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+
+		req, err := http.NewRequest("GET", "https://api.paystack.co"+path+"?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+os.Getenv("PAYSTACK_SECRET_KEY"))
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("Error from Paystack: %s", body)
+		}
+
+		var result struct {
+			Status  bool             `json:"status"`
+			Message string           `json:"message"`
+			Data    json.RawMessage  `json:"data"`
+			Meta    paystackListMeta `json:"meta"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return err
+		}
+		if !result.Status {
+			return fmt.Errorf("Error from Paystack: %s", result.Message)
+		}
+		if err := handle(result.Data); err != nil {
+			return err
+		}
+
+		if result.Meta.PageCount <= page {
+			return nil
+		}
+	}
+}
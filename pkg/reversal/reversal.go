@@ -0,0 +1,313 @@
+package reversal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/auth"
+	"go_code/pkg/fee"
+	"go_code/pkg/ledger"
+)
+
+// InitiatedBySystem marks reversals triggered automatically on provider failure
+const InitiatedBySystem = "system"
+
+// ErrTransactionNotFound is returned when the transaction to reverse does not exist
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// ErrAlreadyReversed is returned when the transaction has already been reversed
+var ErrAlreadyReversed = errors.New("transaction has already been reversed")
+
+// ErrNotReversible is returned for transactions that cannot be refunded on their own
+var ErrNotReversible = errors.New("transaction cannot be reversed")
+
+// Reversal represents the refund of a debit, linked to the original transaction
+type Reversal struct {
+	ReversalID            int64     `json:"reversal_id"`
+	OriginalTransactionID int64     `json:"original_transaction_id"`
+	ReversalTransactionID int64     `json:"reversal_transaction_id"`
+	UserID                int       `json:"user_id"`
+	Reference             string    `json:"reference"`
+	Amount                float64   `json:"amount"`
+	FeeAmount             float64   `json:"fee_amount"`
+	Reason                string    `json:"reason"`
+	InitiatedBy           string    `json:"initiated_by"`
+	CreatedAt             time.Time `json:"created_at"`
+}
+
+// ReverseRequest represents the request payload for a manual reversal
+type ReverseRequest struct {
+	TransactionID int64  `json:"transaction_id"`
+	Reason        string `json:"reason"`
+}
+
+// Response represents the generic response structure
+type Response struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message"`
+	Result  interface{} `json:"result,omitempty"`
+}
+
+// ReverseTransactionHandler lets support refund a debit with a reason
+func ReverseTransactionHandler(c *gin.Context) {
+	var request ReverseRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON input: " + err.Error(),
+		})
+		return
+	}
+
+	request.Reason = strings.TrimSpace(request.Reason)
+	if request.TransactionID == 0 || request.Reason == "" {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Transaction ID and reason are required",
+		})
+		return
+	}
+
+	staff, _ := auth.StaffFromContext(c)
+	reversal, err := Reverse(request.TransactionID, request.Reason, "staff:"+staff.Name)
+	if err != nil {
+		respondReversalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Transaction reversed successfully",
+		Result:  reversal,
+	})
+}
+
+// GetReversalHandler returns the reversal linked to a transaction
+func GetReversalHandler(c *gin.Context) {
+	transactionID, err := strconv.ParseInt(c.Param("transaction_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid transaction ID",
+		})
+		return
+	}
+
+	reversal, err := FetchReversal(transactionID)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Transaction has not been reversed",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch reversal: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Reversal fetched successfully",
+		Result:  reversal,
+	})
+}
+
+// respondReversalError writes a reversal failure as a JSON error response
+func respondReversalError(c *gin.Context, err error) {
+	statusCode := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrTransactionNotFound):
+		statusCode = http.StatusNotFound
+	case errors.Is(err, ErrAlreadyReversed):
+		statusCode = http.StatusConflict
+	case errors.Is(err, ErrNotReversible):
+		statusCode = http.StatusBadRequest
+	}
+	c.JSON(statusCode, Response{
+		Status:  "error",
+		Message: err.Error(),
+	})
+}
+
+// Reverse refunds a debit and the fee charged with it back to the user's wallet.
+// The refund is posted as a credit under the original reference and linked to the
+// original transaction, so a transaction can only ever be reversed once.
+func Reverse(transactionID int64, reason, initiatedBy string) (*Reversal, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close(context.Background())
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	reversal := &Reversal{
+		OriginalTransactionID: transactionID,
+		Reason:                reason,
+		InitiatedBy:           initiatedBy,
+	}
+
+	// Lock the original transaction so concurrent reversals of it are serialised
+	var transactionType, narration, accountName, bankName string
+	err = tx.QueryRow(context.Background(), `
+		SELECT user_id, COALESCE(reference, ''), amount, transaction_type, COALESCE(narration, ''),
+			COALESCE(account_name, ''), COALESCE(bank_name, '')
+		FROM user_transaction
+		WHERE transaction_id = $1
+		FOR UPDATE
+	`, transactionID).Scan(&reversal.UserID, &reversal.Reference, &reversal.Amount, &transactionType, &narration, &accountName, &bankName)
+	if err == pgx.ErrNoRows {
+		return nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transaction: %w", err)
+	}
+
+	if err := checkReversible(tx, transactionID, transactionType, reversal.Reference); err != nil {
+		return nil, err
+	}
+
+	reversal.ReversalTransactionID, err = ledger.Credit(tx, ledger.Entry{
+		UserID:      reversal.UserID,
+		Amount:      reversal.Amount,
+		Reference:   reversal.Reference,
+		Narration:   "Reversal: " + narration,
+		AccountName: accountName,
+		BankName:    bankName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Refund whatever fee is still held against the reference, once for each product it was charged under
+	if reversal.Reference != "" {
+		reversal.FeeAmount, err = refundFees(tx, reversal.UserID, reversal.Reference)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.QueryRow(context.Background(), `
+		INSERT INTO transaction_reversal (original_transaction_id, reversal_transaction_id, user_id, reference, amount, fee_amount, reason, initiated_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING reversal_id, created_at
+	`, reversal.OriginalTransactionID, reversal.ReversalTransactionID, reversal.UserID, reversal.Reference,
+		reversal.Amount, reversal.FeeAmount, reversal.Reason, reversal.InitiatedBy).Scan(&reversal.ReversalID, &reversal.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save reversal: %w", err)
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return reversal, nil
+}
+
+// refundFees refunds the fees still held against a reference and returns the total refunded
+func refundFees(tx pgx.Tx, userID int, reference string) (float64, error) {
+	rows, err := tx.Query(context.Background(), `
+		SELECT product, SUM(amount) FROM fee_revenue
+		WHERE reference = $1 AND user_id = $2
+		GROUP BY product
+	`, reference, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch fee: %w", err)
+	}
+
+	held := make(map[string]float64)
+	for rows.Next() {
+		var product string
+		var amount float64
+		if err := rows.Scan(&product, &amount); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to fetch fee: %w", err)
+		}
+		held[product] = amount
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to fetch fee: %w", err)
+	}
+
+	var total float64
+	for product, amount := range held {
+		if amount <= 0 {
+			continue
+		}
+		if err := fee.Refund(tx, userID, product, amount, reference); err != nil {
+			return 0, err
+		}
+		total += amount
+	}
+	return math.Round(total*100) / 100, nil
+}
+
+// checkReversible rejects credits, fee postings, transactions already reversed and transfers
+// that move money between wallets or batches whose items are refunded individually
+func checkReversible(tx pgx.Tx, transactionID int64, transactionType, reference string) error {
+	if transactionType != "debit" {
+		return fmt.Errorf("%w: only debits can be reversed", ErrNotReversible)
+	}
+	if strings.HasPrefix(reference, "P2P-") || strings.HasPrefix(reference, "BULK-") || strings.HasPrefix(reference, "BVAS-") {
+		return fmt.Errorf("%w: wallet and bulk transfers are refunded through their own flows", ErrNotReversible)
+	}
+
+	var isFee, reversed bool
+	err := tx.QueryRow(context.Background(), `
+		SELECT
+			EXISTS (SELECT 1 FROM fee_revenue WHERE transaction_id = $1),
+			EXISTS (SELECT 1 FROM transaction_reversal WHERE original_transaction_id = $1)
+	`, transactionID).Scan(&isFee, &reversed)
+	if err != nil {
+		return fmt.Errorf("failed to check transaction: %w", err)
+	}
+	if isFee {
+		return fmt.Errorf("%w: fees are refunded together with their transaction", ErrNotReversible)
+	}
+	if reversed {
+		return ErrAlreadyReversed
+	}
+
+	return nil
+}
+
+// FetchReversal returns the reversal of a transaction
+func FetchReversal(transactionID int64) (*Reversal, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	var reversal Reversal
+	err = db.QueryRow(context.Background(), `
+		SELECT reversal_id, original_transaction_id, reversal_transaction_id, user_id, reference, amount, fee_amount,
+			reason, initiated_by, created_at
+		FROM transaction_reversal
+		WHERE original_transaction_id = $1
+	`, transactionID).Scan(&reversal.ReversalID, &reversal.OriginalTransactionID, &reversal.ReversalTransactionID, &reversal.UserID,
+		&reversal.Reference, &reversal.Amount, &reversal.FeeAmount, &reversal.Reason, &reversal.InitiatedBy, &reversal.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reversal, nil
+}
@@ -0,0 +1,267 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go_code/database"
+	"go_code/pkg/bill"
+	"go_code/pkg/ledger"
+	"go_code/pkg/notification"
+	"go_code/pkg/transaction"
+)
+
+// Run outcomes recorded in the schedule history
+const (
+	RunSuccess = "success"
+	RunFailed  = "failed"
+	RunRetry   = "retrying"
+	RunSkipped = "skipped"
+)
+
+// retryBackoff is multiplied by the attempt number to space out retries of a failed run
+const retryBackoff = 15 * time.Minute
+
+// claimTimeout stops other scheduler instances from picking up a schedule while it is running
+const claimTimeout = "10 minutes"
+
+// StartScheduler runs due scheduled payments every interval in a background goroutine
+func StartScheduler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := runDueSchedules(); err != nil {
+				log.Printf("Scheduler run failed: %v\n", err)
+			}
+		}
+	}()
+}
+
+// runDueSchedules claims every active schedule whose next run is due and executes it
+func runDueSchedules() error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		UPDATE scheduled_payment SET locked_until = NOW() + INTERVAL '`+claimTimeout+`'
+		WHERE schedule_id IN (
+			SELECT schedule_id FROM scheduled_payment
+			WHERE status = 'active' AND next_run_at <= NOW() AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY next_run_at
+			LIMIT 50
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+scheduleColumns)
+	if err != nil {
+		return fmt.Errorf("failed to claim due schedules: %w", err)
+	}
+
+	var due []*ScheduledPayment
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, schedule)
+	}
+	rows.Close()
+
+	for _, schedule := range due {
+		runSchedule(schedule)
+	}
+
+	return nil
+}
+
+// runSchedule executes one run of a schedule, applies the retry and insufficient funds policies
+// and moves the schedule to its next run
+func runSchedule(schedule *ScheduledPayment) {
+	attempt := schedule.attempts + 1
+	scheduledFor := schedule.scheduledFor
+	reference, err := executeScheduledPayment(schedule)
+
+	var status, errMessage string
+	switch {
+	case err == nil:
+		status = RunSuccess
+	case errors.Is(err, ledger.ErrInsufficientBalance) && schedule.InsufficientFundsPolicy == PolicySkip:
+		status = RunSkipped
+		errMessage = "Insufficient balance"
+	case attempt <= schedule.MaxRetries:
+		status = RunRetry
+		errMessage = err.Error()
+	default:
+		status = RunFailed
+		errMessage = err.Error()
+	}
+
+	scheduleStatus := StatusActive
+	if status == RunRetry {
+		schedule.attempts = attempt
+		retryAt := time.Now().Add(time.Duration(attempt) * retryBackoff)
+		schedule.NextRunAt = &retryAt
+	} else if !schedule.advance() {
+		scheduleStatus = StatusCompleted
+	}
+
+	if err := saveRun(schedule, scheduledFor, attempt, status, reference, errMessage, scheduleStatus); err != nil {
+		log.Printf("Failed to save run of schedule %d: %v\n", schedule.ScheduleID, err)
+	}
+
+	notifyRun(schedule, status, reference, errMessage)
+}
+
+// executeScheduledPayment runs the payment through the same logic as the matching API endpoint
+func executeScheduledPayment(schedule *ScheduledPayment) (string, error) {
+	switch schedule.Kind {
+	case KindTransfer:
+		var payload transaction.FundTransfer
+		if err := json.Unmarshal(schedule.Payload, &payload); err != nil {
+			return "", err
+		}
+		result, err := transaction.ExecuteTransfer(payload)
+		if err != nil {
+			return "", err
+		}
+		return result.Reference, nil
+
+	case KindAirtime:
+		var payload bill.AirtimePurchaseRequest
+		if err := json.Unmarshal(schedule.Payload, &payload); err != nil {
+			return "", err
+		}
+		result, err := bill.PurchaseAirtime(payload)
+		if err != nil {
+			return "", err
+		}
+		return result.Entity.ReferenceID, nil
+
+	case KindData:
+		var payload bill.DataPurchaseRequest
+		if err := json.Unmarshal(schedule.Payload, &payload); err != nil {
+			return "", err
+		}
+		result, err := bill.PurchaseData(payload)
+		if err != nil {
+			return "", err
+		}
+		return result.Result.ReferenceID, nil
+	}
+
+	return "", fmt.Errorf("unsupported schedule kind %q", schedule.Kind)
+}
+
+// saveRun records the run in the history and stores the schedule's next run.
+// A schedule paused or cancelled while it was running keeps that status.
+func saveRun(schedule *ScheduledPayment, scheduledFor time.Time, attempt int, status, reference, errMessage, scheduleStatus string) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(context.Background(), `
+		INSERT INTO scheduled_payment_run (schedule_id, scheduled_for, attempt, status, reference, error)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''))
+	`, schedule.ScheduleID, scheduledFor, attempt, status, reference, errMessage)
+	if err != nil {
+		return fmt.Errorf("failed to save run: %w", err)
+	}
+
+	_, err = tx.Exec(context.Background(), `
+		UPDATE scheduled_payment SET
+			next_run_at = $1, scheduled_for = $2, occurrence = $3, attempts = $4,
+			run_count = run_count + 1, last_run_at = NOW(), last_status = $5, last_error = NULLIF($6, ''),
+			status = CASE WHEN status = 'active' THEN $7 ELSE status END,
+			locked_until = NULL, updated_at = NOW()
+		WHERE schedule_id = $8
+	`, schedule.NextRunAt, schedule.scheduledFor, schedule.occurrence, schedule.attempts, status, errMessage, scheduleStatus, schedule.ScheduleID)
+	if err != nil {
+		return fmt.Errorf("failed to update schedule: %w", err)
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// notifyRun emails the user the outcome of a scheduled payment run
+func notifyRun(schedule *ScheduledPayment, status, reference, errMessage string) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		log.Printf("Failed to connect to database: %v\n", err)
+		return
+	}
+	defer db.Close(context.Background())
+
+	var fullname, email string
+	err = db.QueryRow(context.Background(), "SELECT fullname, email FROM users WHERE user_id = $1", schedule.UserID).Scan(&fullname, &email)
+	if err != nil {
+		log.Printf("Failed to fetch user for schedule %d: %v\n", schedule.ScheduleID, err)
+		return
+	}
+
+	description := describePayment(schedule)
+	var subject, outcome string
+	switch status {
+	case RunSuccess:
+		subject = "Scheduled payment successful"
+		outcome = fmt.Sprintf("Your scheduled %s was successful.\nReference: %s", description, reference)
+	case RunSkipped:
+		subject = "Scheduled payment skipped"
+		outcome = fmt.Sprintf("Your scheduled %s was skipped because your wallet balance was insufficient.", description)
+	case RunRetry:
+		subject = "Scheduled payment will be retried"
+		outcome = fmt.Sprintf("Your scheduled %s failed and will be retried at %s.\nReason: %s",
+			description, schedule.NextRunAt.Format("02 Jan 2006 15:04"), errMessage)
+	default:
+		subject = "Scheduled payment failed"
+		outcome = fmt.Sprintf("Your scheduled %s failed.\nReason: %s", description, errMessage)
+	}
+	if schedule.NextRunAt != nil && status != RunRetry {
+		outcome += "\nNext payment: " + schedule.NextRunAt.Format("02 Jan 2006 15:04")
+	}
+
+	body := fmt.Sprintf("Dear %s,\n\n%s", fullname, outcome)
+	if err := notification.SendEmail(email, subject, body); err != nil {
+		fmt.Println("Failed to send email:", err)
+	}
+}
+
+// describePayment summarises the scheduled payment for notifications
+func describePayment(schedule *ScheduledPayment) string {
+	switch schedule.Kind {
+	case KindTransfer:
+		var payload transaction.FundTransfer
+		if json.Unmarshal(schedule.Payload, &payload) == nil {
+			if payload.BeneficiaryID != 0 {
+				return fmt.Sprintf("transfer of NGN %.2f to your saved beneficiary", payload.Amount)
+			}
+			return fmt.Sprintf("transfer of NGN %.2f to %s", payload.Amount, payload.AccountNumber)
+		}
+	case KindAirtime:
+		var payload bill.AirtimePurchaseRequest
+		if json.Unmarshal(schedule.Payload, &payload) == nil {
+			return fmt.Sprintf("airtime top-up of NGN %s to %s", payload.Amount, payload.Destination)
+		}
+	case KindData:
+		var payload bill.DataPurchaseRequest
+		if json.Unmarshal(schedule.Payload, &payload) == nil {
+			return fmt.Sprintf("data top-up (%s) to %s", payload.Plan, payload.Destination)
+		}
+	}
+	return schedule.Kind + " payment"
+}
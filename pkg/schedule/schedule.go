@@ -0,0 +1,532 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/bill"
+	"go_code/pkg/transaction"
+)
+
+// Payment kinds that can be scheduled
+const (
+	KindTransfer = "transfer"
+	KindAirtime  = "airtime"
+	KindData     = "data"
+)
+
+// Schedule frequencies
+const (
+	FrequencyOnce    = "once"
+	FrequencyDaily   = "daily"
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+)
+
+// Schedule statuses
+const (
+	StatusActive    = "active"
+	StatusPaused    = "paused"
+	StatusCancelled = "cancelled"
+	StatusCompleted = "completed"
+)
+
+// Policies applied when the wallet cannot cover a run
+const (
+	PolicySkip  = "skip"
+	PolicyRetry = "retry"
+)
+
+const defaultMaxRetries = 3
+
+// Response represents the generic response structure
+type Response struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message"`
+	Result  interface{} `json:"result,omitempty"`
+}
+
+// ScheduledPayment represents a one-off or recurring transfer or bill payment
+type ScheduledPayment struct {
+	ScheduleID              int64           `json:"schedule_id"`
+	UserID                  int             `json:"user_id"`
+	Kind                    string          `json:"kind"`
+	Frequency               string          `json:"frequency"`
+	Payload                 json.RawMessage `json:"payload"`
+	StartAt                 time.Time       `json:"start_at"`
+	EndAt                   *time.Time      `json:"end_at,omitempty"`
+	NextRunAt               *time.Time      `json:"next_run_at,omitempty"`
+	InsufficientFundsPolicy string          `json:"insufficient_funds_policy"`
+	MaxRetries              int             `json:"max_retries"`
+	Status                  string          `json:"status"`
+	RunCount                int             `json:"run_count"`
+	LastRunAt               *time.Time      `json:"last_run_at,omitempty"`
+	LastStatus              string          `json:"last_status,omitempty"`
+	LastError               string          `json:"last_error,omitempty"`
+	CreatedAt               time.Time       `json:"created_at"`
+
+	// Internal run state
+	occurrence   int
+	scheduledFor time.Time
+	attempts     int
+}
+
+// ScheduleRun represents a single execution of a scheduled payment
+type ScheduleRun struct {
+	RunID        int64     `json:"run_id"`
+	ScheduledFor time.Time `json:"scheduled_for"`
+	Attempt      int       `json:"attempt"`
+	Status       string    `json:"status"`
+	Reference    string    `json:"reference,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CreateScheduleRequest represents the request body for scheduling a payment.
+// Payload carries the same body the matching transfer, airtime or data endpoint accepts.
+type CreateScheduleRequest struct {
+	UserID                  int             `json:"user_id"`
+	Kind                    string          `json:"kind"`
+	Frequency               string          `json:"frequency"`
+	StartAt                 time.Time       `json:"start_at"`
+	EndAt                   *time.Time      `json:"end_at"`
+	InsufficientFundsPolicy string          `json:"insufficient_funds_policy"`
+	MaxRetries              *int            `json:"max_retries"`
+	Payload                 json.RawMessage `json:"payload"`
+}
+
+// ScheduleActionRequest represents the request body for pausing, resuming or cancelling a schedule
+type ScheduleActionRequest struct {
+	UserID int `json:"user_id"`
+}
+
+const scheduleColumns = `schedule_id, user_id, kind, frequency, payload, start_at, end_at, next_run_at, insufficient_funds_policy,
+	max_retries, status, run_count, last_run_at, COALESCE(last_status, ''), COALESCE(last_error, ''), created_at,
+	occurrence, scheduled_for, attempts`
+
+// CreateScheduleHandler validates and saves a new scheduled payment
+func CreateScheduleHandler(c *gin.Context) {
+	var request CreateScheduleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON input: " + err.Error(),
+		})
+		return
+	}
+
+	schedule, err := newSchedule(request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to connect to database: " + err.Error(),
+		})
+		return
+	}
+	defer db.Close(context.Background())
+
+	err = db.QueryRow(context.Background(), `
+		INSERT INTO scheduled_payment (user_id, kind, frequency, payload, start_at, end_at, next_run_at, scheduled_for,
+			insufficient_funds_policy, max_retries, status, run_count, occurrence, attempts)
+		VALUES ($1, $2, $3, $4, $5, $6, $5, $5, $7, $8, $9, 0, 0, 0)
+		RETURNING schedule_id, created_at
+	`, schedule.UserID, schedule.Kind, schedule.Frequency, []byte(schedule.Payload), schedule.StartAt, schedule.EndAt,
+		schedule.InsufficientFundsPolicy, schedule.MaxRetries, schedule.Status).Scan(&schedule.ScheduleID, &schedule.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to save schedule: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, Response{
+		Status:  "success",
+		Message: "Payment scheduled successfully",
+		Result:  schedule,
+	})
+}
+
+// ListSchedulesHandler returns all scheduled payments of a user
+func ListSchedulesHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user_id parameter",
+		})
+		return
+	}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to connect to database: " + err.Error(),
+		})
+		return
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(),
+		"SELECT "+scheduleColumns+" FROM scheduled_payment WHERE user_id = $1 ORDER BY created_at DESC", userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch schedules: " + err.Error(),
+		})
+		return
+	}
+	defer rows.Close()
+
+	schedules := []ScheduledPayment{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, Response{
+				Status:  "error",
+				Message: "Failed to read schedules: " + err.Error(),
+			})
+			return
+		}
+		schedules = append(schedules, *schedule)
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Schedules retrieved successfully",
+		Result:  schedules,
+	})
+}
+
+// ListScheduleRunsHandler returns the run history of a scheduled payment
+func ListScheduleRunsHandler(c *gin.Context) {
+	scheduleID, err := strconv.ParseInt(c.Param("schedule_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid schedule_id parameter",
+		})
+		return
+	}
+	userID, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user_id parameter",
+		})
+		return
+	}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to connect to database: " + err.Error(),
+		})
+		return
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		SELECT r.run_id, r.scheduled_for, r.attempt, r.status, COALESCE(r.reference, ''), COALESCE(r.error, ''), r.created_at
+		FROM scheduled_payment_run r
+		JOIN scheduled_payment s ON s.schedule_id = r.schedule_id
+		WHERE r.schedule_id = $1 AND s.user_id = $2
+		ORDER BY r.created_at DESC
+	`, scheduleID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch schedule runs: " + err.Error(),
+		})
+		return
+	}
+	defer rows.Close()
+
+	runs := []ScheduleRun{}
+	for rows.Next() {
+		var run ScheduleRun
+		if err := rows.Scan(&run.RunID, &run.ScheduledFor, &run.Attempt, &run.Status, &run.Reference, &run.Error, &run.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, Response{
+				Status:  "error",
+				Message: "Failed to read schedule runs: " + err.Error(),
+			})
+			return
+		}
+		runs = append(runs, run)
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Schedule runs retrieved successfully",
+		Result:  runs,
+	})
+}
+
+// PauseScheduleHandler stops an active schedule from running until it is resumed
+func PauseScheduleHandler(c *gin.Context) {
+	changeScheduleStatus(c, StatusPaused, []string{StatusActive}, "Schedule paused successfully")
+}
+
+// ResumeScheduleHandler resumes a paused schedule from its next future occurrence
+func ResumeScheduleHandler(c *gin.Context) {
+	changeScheduleStatus(c, StatusActive, []string{StatusPaused}, "Schedule resumed successfully")
+}
+
+// CancelScheduleHandler permanently cancels a schedule
+func CancelScheduleHandler(c *gin.Context) {
+	changeScheduleStatus(c, StatusCancelled, []string{StatusActive, StatusPaused}, "Schedule cancelled successfully")
+}
+
+// changeScheduleStatus moves a user's schedule to a new status if it is currently in one of the allowed statuses
+func changeScheduleStatus(c *gin.Context, status string, from []string, message string) {
+	scheduleID, err := strconv.ParseInt(c.Param("schedule_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid schedule_id parameter",
+		})
+		return
+	}
+
+	var request ScheduleActionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON input: " + err.Error(),
+		})
+		return
+	}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to connect to database: " + err.Error(),
+		})
+		return
+	}
+	defer db.Close(context.Background())
+
+	schedule, err := scanSchedule(db.QueryRow(context.Background(),
+		"SELECT "+scheduleColumns+" FROM scheduled_payment WHERE schedule_id = $1 AND user_id = $2", scheduleID, request.UserID))
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Schedule not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch schedule: " + err.Error(),
+		})
+		return
+	}
+
+	allowed := false
+	for _, current := range from {
+		if schedule.Status == current {
+			allowed = true
+		}
+	}
+	if !allowed {
+		c.JSON(http.StatusConflict, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("A %s schedule cannot be %s", schedule.Status, status),
+		})
+		return
+	}
+
+	schedule.Status = status
+	if status == StatusActive {
+		// Occurrences of a recurring schedule missed while paused are skipped rather than executed all at once,
+		// while an overdue one-off payment runs straight away
+		if schedule.Frequency == FrequencyOnce && schedule.NextRunAt != nil && schedule.NextRunAt.Before(time.Now()) {
+			now := time.Now()
+			schedule.NextRunAt = &now
+		}
+		for schedule.NextRunAt != nil && schedule.NextRunAt.Before(time.Now()) {
+			if !schedule.advance() {
+				schedule.Status = StatusCompleted
+				break
+			}
+		}
+		schedule.attempts = 0
+	}
+
+	_, err = db.Exec(context.Background(), `
+		UPDATE scheduled_payment SET status = $1, next_run_at = $2, scheduled_for = $3, occurrence = $4, attempts = $5, updated_at = NOW()
+		WHERE schedule_id = $6
+	`, schedule.Status, schedule.NextRunAt, schedule.scheduledFor, schedule.occurrence, schedule.attempts, schedule.ScheduleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to update schedule: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: message,
+		Result:  schedule,
+	})
+}
+
+// newSchedule validates a schedule request, including the payload for its payment kind
+func newSchedule(request CreateScheduleRequest) (*ScheduledPayment, error) {
+	schedule := ScheduledPayment{
+		UserID:                  request.UserID,
+		Kind:                    strings.ToLower(request.Kind),
+		Frequency:               strings.ToLower(request.Frequency),
+		StartAt:                 request.StartAt,
+		EndAt:                   request.EndAt,
+		InsufficientFundsPolicy: strings.ToLower(request.InsufficientFundsPolicy),
+		MaxRetries:              defaultMaxRetries,
+		Status:                  StatusActive,
+	}
+
+	switch schedule.Frequency {
+	case FrequencyOnce, FrequencyDaily, FrequencyWeekly, FrequencyMonthly:
+	default:
+		return nil, fmt.Errorf("Invalid frequency. Supported frequencies are once, daily, weekly and monthly")
+	}
+
+	if schedule.InsufficientFundsPolicy == "" {
+		schedule.InsufficientFundsPolicy = PolicySkip
+	}
+	if schedule.InsufficientFundsPolicy != PolicySkip && schedule.InsufficientFundsPolicy != PolicyRetry {
+		return nil, fmt.Errorf("Invalid insufficient_funds_policy. Supported policies are skip and retry")
+	}
+
+	if request.MaxRetries != nil {
+		if *request.MaxRetries < 0 || *request.MaxRetries > 10 {
+			return nil, fmt.Errorf("max_retries must be between 0 and 10")
+		}
+		schedule.MaxRetries = *request.MaxRetries
+	}
+
+	if schedule.StartAt.IsZero() || schedule.StartAt.Before(time.Now().Add(-time.Minute)) {
+		return nil, fmt.Errorf("start_at must be a future time")
+	}
+	if schedule.EndAt != nil && schedule.EndAt.Before(schedule.StartAt) {
+		return nil, fmt.Errorf("end_at must be after start_at")
+	}
+
+	// The payload must be a valid request for the payment kind and belong to the same user
+	switch schedule.Kind {
+	case KindTransfer:
+		var payload transaction.FundTransfer
+		if err := json.Unmarshal(request.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("Invalid transfer payload: %s", err.Error())
+		}
+		if payload.Amount <= 0 || (payload.BeneficiaryID == 0 && (payload.AccountNumber == "" || payload.BankCode == "")) {
+			return nil, fmt.Errorf("A transfer payload requires an amount and either a beneficiary_id or account_number and bank_code")
+		}
+		payload.UserID = request.UserID
+		schedule.Payload, _ = json.Marshal(payload)
+	case KindAirtime:
+		var payload bill.AirtimePurchaseRequest
+		if err := json.Unmarshal(request.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("Invalid airtime payload: %s", err.Error())
+		}
+		if _, err := strconv.ParseFloat(payload.Amount, 64); err != nil || (payload.Destination == "" && payload.BeneficiaryID == 0) {
+			return nil, fmt.Errorf("An airtime payload requires an amount and a destination or beneficiary_id")
+		}
+		payload.UserID = request.UserID
+		schedule.Payload, _ = json.Marshal(payload)
+	case KindData:
+		var payload bill.DataPurchaseRequest
+		if err := json.Unmarshal(request.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("Invalid data payload: %s", err.Error())
+		}
+		if payload.Plan == "" || (payload.Destination == "" && payload.BeneficiaryID == 0) {
+			return nil, fmt.Errorf("A data payload requires a plan and a destination or beneficiary_id")
+		}
+		payload.UserID = request.UserID
+		schedule.Payload, _ = json.Marshal(payload)
+	default:
+		return nil, fmt.Errorf("Invalid kind. Supported kinds are transfer, airtime and data")
+	}
+
+	next := schedule.StartAt
+	schedule.NextRunAt = &next
+	schedule.scheduledFor = next
+
+	return &schedule, nil
+}
+
+// advance moves the schedule to its next occurrence. It returns false when there are no more occurrences.
+func (s *ScheduledPayment) advance() bool {
+	s.occurrence++
+	s.attempts = 0
+
+	var next time.Time
+	switch s.Frequency {
+	case FrequencyDaily:
+		next = s.StartAt.AddDate(0, 0, s.occurrence)
+	case FrequencyWeekly:
+		next = s.StartAt.AddDate(0, 0, 7*s.occurrence)
+	case FrequencyMonthly:
+		next = addMonthsClamped(s.StartAt, s.occurrence)
+	default:
+		s.NextRunAt = nil
+		return false
+	}
+
+	if s.EndAt != nil && next.After(*s.EndAt) {
+		s.NextRunAt = nil
+		return false
+	}
+
+	s.scheduledFor = next
+	s.NextRunAt = &next
+	return true
+}
+
+// addMonthsClamped adds months to t, clamping to the last day of the month so a schedule
+// starting on the 31st runs on the 30th or 28th in shorter months instead of drifting
+func addMonthsClamped(t time.Time, months int) time.Time {
+	firstOfMonth := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return firstOfMonth.AddDate(0, 0, day-1)
+}
+
+// scanSchedule scans a schedule row selected with scheduleColumns
+func scanSchedule(row pgx.Row) (*ScheduledPayment, error) {
+	var schedule ScheduledPayment
+	var payload []byte
+	err := row.Scan(&schedule.ScheduleID, &schedule.UserID, &schedule.Kind, &schedule.Frequency, &payload, &schedule.StartAt,
+		&schedule.EndAt, &schedule.NextRunAt, &schedule.InsufficientFundsPolicy, &schedule.MaxRetries, &schedule.Status,
+		&schedule.RunCount, &schedule.LastRunAt, &schedule.LastStatus, &schedule.LastError, &schedule.CreatedAt,
+		&schedule.occurrence, &schedule.scheduledFor, &schedule.attempts)
+	if err != nil {
+		return nil, err
+	}
+	schedule.Payload = payload
+	return &schedule, nil
+}
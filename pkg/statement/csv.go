@@ -0,0 +1,63 @@
+package statement
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+)
+
+// renderCSV renders the statement as CSV with a summary block followed by the entries
+func renderCSV(statement *Statement) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	summary := [][]string{
+		{brandName() + " Account Statement"},
+		{"Account Name", statement.Fullname},
+		{"Account Number", statement.AccountNumber},
+		{"Bank", statement.BankName},
+		{"Period", statement.From.Format(dateLayout) + " to " + statement.To.Format(dateLayout)},
+		{"Opening Balance", csvAmount(statement.OpeningBalance)},
+		{"Total Credit", csvAmount(statement.TotalCredit)},
+		{"Total Debit", csvAmount(statement.TotalDebit)},
+		{"Closing Balance", csvAmount(statement.ClosingBalance)},
+		{},
+		{"Date", "Description", "Reference", "Debit", "Credit", "Balance"},
+	}
+	if err := writer.WriteAll(summary); err != nil {
+		return nil, err
+	}
+
+	for _, line := range statement.Lines {
+		debit, credit := "", ""
+		if line.Type == "credit" {
+			credit = csvAmount(line.Amount)
+		} else {
+			debit = csvAmount(line.Amount)
+		}
+
+		record := []string{
+			line.Date.Format("2006-01-02 15:04:05"),
+			line.Description,
+			line.Reference,
+			debit,
+			credit,
+			csvAmount(line.Balance),
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// csvAmount formats an amount without separators so spreadsheets parse it as a number
+func csvAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
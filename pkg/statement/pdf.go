@@ -0,0 +1,263 @@
+package statement
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A4 page geometry in PDF points
+const (
+	pageWidth    = 595.0
+	pageHeight   = 842.0
+	pageMargin   = 40.0
+	rowHeight    = 14.0
+	tableFontPt  = 8.0
+	footerY      = 30.0
+	firstTableY  = 680.0
+	otherTableY  = 760.0
+	defaultColor = "#0B3D91"
+)
+
+// pdfColumn describes a column of the statement table
+type pdfColumn struct {
+	title      string
+	x          float64
+	maxChars   int
+	alignRight bool
+}
+
+var pdfColumns = []pdfColumn{
+	{title: "Date", x: pageMargin, maxChars: 16},
+	{title: "Description", x: 118, maxChars: 38},
+	{title: "Reference", x: 292, maxChars: 20},
+	{title: "Debit", x: 440, alignRight: true},
+	{title: "Credit", x: 500, alignRight: true},
+	{title: "Balance", x: pageWidth - pageMargin, alignRight: true},
+}
+
+// renderPDF renders the statement as a branded multi-page PDF document
+func renderPDF(statement *Statement) ([]byte, error) {
+	r, g, b := brandColor()
+
+	var pages []*bytes.Buffer
+	page := newPDFPage(&pages)
+	drawHeader(page, r, g, b)
+	drawSummary(page, statement)
+
+	y := firstTableY
+	drawTableHeader(page, y, r, g, b)
+	y -= rowHeight
+
+	for i, line := range statement.Lines {
+		if y < footerY+rowHeight*2 {
+			page = newPDFPage(&pages)
+			drawHeader(page, r, g, b)
+			y = otherTableY
+			drawTableHeader(page, y, r, g, b)
+			y -= rowHeight
+		}
+
+		if i%2 == 1 {
+			fmt.Fprintf(page, "0.95 0.95 0.95 rg %.2f %.2f %.2f %.2f re f\n", pageMargin-4, y-4, pageWidth-2*pageMargin+8, rowHeight)
+		}
+
+		debit, credit := "", ""
+		if line.Type == "credit" {
+			credit = formatAmount(line.Amount)
+		} else {
+			debit = formatAmount(line.Amount)
+		}
+
+		values := []string{
+			line.Date.Format("02 Jan 2006 15:04"),
+			line.Description,
+			line.Reference,
+			debit,
+			credit,
+			formatAmount(line.Balance),
+		}
+		for col, value := range values {
+			drawCell(page, pdfColumns[col], value, y, "F1", tableFontPt)
+		}
+		y -= rowHeight
+	}
+
+	if len(statement.Lines) == 0 {
+		drawText(page, "F1", 9, pageMargin, y, "No transactions in this period")
+	}
+
+	footer := fmt.Sprintf("Generated on %s. This statement was generated electronically by %s.",
+		statement.GeneratedAt.Format("02 Jan 2006 15:04"), brandName())
+	for i, p := range pages {
+		drawText(p, "F1", 7, pageMargin, footerY, footer)
+		pageLabel := fmt.Sprintf("Page %d of %d", i+1, len(pages))
+		drawText(p, "F1", 7, pageWidth-pageMargin-textWidth(pageLabel, 7), footerY, pageLabel)
+	}
+
+	return assemblePDF(pages), nil
+}
+
+// newPDFPage appends a new content stream to the page list
+func newPDFPage(pages *[]*bytes.Buffer) *bytes.Buffer {
+	page := &bytes.Buffer{}
+	*pages = append(*pages, page)
+	return page
+}
+
+// drawHeader draws the branded header band at the top of a page
+func drawHeader(page *bytes.Buffer, r, g, b float64) {
+	fmt.Fprintf(page, "%.3f %.3f %.3f rg 0 %.2f %.2f 60 re f\n", r, g, b, pageHeight-60, pageWidth)
+	page.WriteString("1 1 1 rg\n")
+	drawText(page, "F2", 20, pageMargin, pageHeight-38, brandName())
+	title := "Account Statement"
+	drawText(page, "F1", 12, pageWidth-pageMargin-textWidth(title, 12), pageHeight-36, title)
+	page.WriteString("0 0 0 rg\n")
+}
+
+// drawSummary draws the account holder details and balance summary on the first page
+func drawSummary(page *bytes.Buffer, statement *Statement) {
+	y := pageHeight - 95
+	details := [][2]string{
+		{"Account Name", statement.Fullname},
+		{"Account Number", statement.AccountNumber},
+		{"Bank", statement.BankName},
+		{"Period", statement.From.Format("02 Jan 2006") + " to " + statement.To.Format("02 Jan 2006")},
+	}
+	for _, detail := range details {
+		drawText(page, "F2", 9, pageMargin, y, detail[0])
+		drawText(page, "F1", 9, pageMargin+90, y, detail[1])
+		y -= 14
+	}
+
+	y = pageHeight - 95
+	balances := [][2]string{
+		{"Opening Balance", "NGN " + formatAmount(statement.OpeningBalance)},
+		{"Total Credit", "NGN " + formatAmount(statement.TotalCredit)},
+		{"Total Debit", "NGN " + formatAmount(statement.TotalDebit)},
+		{"Closing Balance", "NGN " + formatAmount(statement.ClosingBalance)},
+	}
+	for _, balance := range balances {
+		drawText(page, "F2", 9, 340, y, balance[0])
+		drawText(page, "F1", 9, pageWidth-pageMargin-textWidth(balance[1], 9), y, balance[1])
+		y -= 14
+	}
+}
+
+// drawTableHeader draws the column titles of the statement table
+func drawTableHeader(page *bytes.Buffer, y, r, g, b float64) {
+	fmt.Fprintf(page, "%.3f %.3f %.3f rg %.2f %.2f %.2f %.2f re f\n", r, g, b, pageMargin-4, y-4, pageWidth-2*pageMargin+8, rowHeight)
+	page.WriteString("1 1 1 rg\n")
+	for _, column := range pdfColumns {
+		drawCell(page, column, column.title, y, "F2", tableFontPt)
+	}
+	page.WriteString("0 0 0 rg\n")
+}
+
+// drawCell draws a value within a table column, truncating or right aligning as needed
+func drawCell(page *bytes.Buffer, column pdfColumn, value string, y float64, font string, size float64) {
+	if column.maxChars > 0 && len(value) > column.maxChars {
+		value = value[:column.maxChars-3] + "..."
+	}
+	x := column.x
+	if column.alignRight {
+		x -= textWidth(value, size)
+	}
+	drawText(page, font, size, x, y, value)
+}
+
+// drawText writes a single line of text at the given position
+func drawText(page *bytes.Buffer, font string, size, x, y float64, text string) {
+	fmt.Fprintf(page, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escapePDFText(text))
+}
+
+// escapePDFText escapes PDF string delimiters and replaces characters outside the base font encoding
+func escapePDFText(text string) string {
+	var sb strings.Builder
+	for _, ch := range text {
+		switch {
+		case ch == '(' || ch == ')' || ch == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(ch)
+		case ch < 32 || ch > 126:
+			sb.WriteByte('?')
+		default:
+			sb.WriteRune(ch)
+		}
+	}
+	return sb.String()
+}
+
+// textWidth approximates the rendered width of Helvetica text, which is enough for aligning amounts
+func textWidth(text string, size float64) float64 {
+	var units float64
+	for _, ch := range text {
+		switch {
+		case ch == '.' || ch == ',' || ch == ' ':
+			units += 278
+		case ch == '-':
+			units += 333
+		case ch >= 'A' && ch <= 'Z':
+			units += 667
+		default:
+			units += 556
+		}
+	}
+	return units * size / 1000
+}
+
+// brandColor parses the BRAND_COLOR hex value into PDF RGB components
+func brandColor() (float64, float64, float64) {
+	hex := strings.TrimPrefix(os.Getenv("BRAND_COLOR"), "#")
+	if len(hex) != 6 {
+		hex = strings.TrimPrefix(defaultColor, "#")
+	}
+
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		value, _ = strconv.ParseUint(strings.TrimPrefix(defaultColor, "#"), 16, 32)
+	}
+
+	return float64(value>>16&0xFF) / 255, float64(value>>8&0xFF) / 255, float64(value&0xFF) / 255
+}
+
+// assemblePDF writes the page content streams into a complete PDF file with a cross-reference table
+func assemblePDF(pages []*bytes.Buffer) []byte {
+	// Object layout: 1 catalog, 2 page tree, 3 regular font, 4 bold font, then a page and content pair per page
+	var objects []string
+	objects = append(objects, "<< /Type /Catalog /Pages 2 0 R >>")
+
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+i*2)
+	}
+	objects = append(objects, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	objects = append(objects, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	objects = append(objects, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range pages {
+		objects = append(objects, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 6+i*2))
+		objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", page.Len(), page.String()))
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return out.Bytes()
+}
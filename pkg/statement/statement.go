@@ -0,0 +1,366 @@
+package statement
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go_code/database"
+	"go_code/pkg/notification"
+)
+
+const dateLayout = "2006-01-02"
+
+// Response represents the generic response structure
+type Response struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message"`
+	Result  interface{} `json:"result,omitempty"`
+}
+
+// Statement represents a wallet statement for a date range
+type Statement struct {
+	UserID         int64     `json:"user_id"`
+	Fullname       string    `json:"fullname"`
+	Email          string    `json:"email"`
+	AccountNumber  string    `json:"account_number"`
+	BankName       string    `json:"bank_name"`
+	From           time.Time `json:"from"`
+	To             time.Time `json:"to"`
+	OpeningBalance float64   `json:"opening_balance"`
+	ClosingBalance float64   `json:"closing_balance"`
+	TotalCredit    float64   `json:"total_credit"`
+	TotalDebit     float64   `json:"total_debit"`
+	Lines          []Line    `json:"lines"`
+	GeneratedAt    time.Time `json:"generated_at"`
+}
+
+// Line represents a single statement entry with its running balance
+type Line struct {
+	Date        time.Time `json:"date"`
+	Description string    `json:"description"`
+	Reference   string    `json:"reference"`
+	Type        string    `json:"transaction_type"`
+	Amount      float64   `json:"amount"`
+	Balance     float64   `json:"balance"`
+}
+
+// EmailStatementRequest represents the request body for emailing a statement
+type EmailStatementRequest struct {
+	UserID int64  `json:"user_id"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Format string `json:"format"`
+}
+
+// DownloadStatementHandler handles the request to download a wallet statement as CSV or PDF
+func DownloadStatementHandler(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user_id parameter",
+		})
+		return
+	}
+
+	from, to, err := parsePeriod(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	format := strings.ToLower(c.DefaultQuery("format", "pdf"))
+	if format != "csv" && format != "pdf" {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid format. Supported formats are csv and pdf",
+		})
+		return
+	}
+
+	statement, err := buildStatement(userID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to generate statement: " + err.Error(),
+		})
+		return
+	}
+
+	data, contentType, err := render(statement, format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to render statement: " + err.Error(),
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=\""+fileName(statement, format)+"\"")
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// EmailStatementHandler handles the request to email a wallet statement to the user
+func EmailStatementHandler(c *gin.Context) {
+	var request EmailStatementRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON input: " + err.Error(),
+		})
+		return
+	}
+
+	from, to, err := parsePeriod(request.From, request.To)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	format := strings.ToLower(request.Format)
+	if format == "" {
+		format = "pdf"
+	}
+	if format != "csv" && format != "pdf" {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid format. Supported formats are csv and pdf",
+		})
+		return
+	}
+
+	statement, err := buildStatement(request.UserID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to generate statement: " + err.Error(),
+		})
+		return
+	}
+
+	data, contentType, err := render(statement, format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to render statement: " + err.Error(),
+		})
+		return
+	}
+
+	subject := fmt.Sprintf("%s account statement: %s to %s", brandName(), statement.From.Format(dateLayout), statement.To.Format(dateLayout))
+	body := fmt.Sprintf("Dear %s,\n\nPlease find attached your account statement for the period %s to %s.\n\nThank you for banking with %s.",
+		statement.Fullname, statement.From.Format(dateLayout), statement.To.Format(dateLayout), brandName())
+
+	err = notification.SendEmail(statement.Email, subject, body, notification.Attachment{
+		Filename:    fileName(statement, format),
+		ContentType: contentType,
+		Data:        data,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to send statement email: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Statement sent to " + statement.Email,
+	})
+}
+
+// parsePeriod parses the statement period, defaulting to the current month to date
+func parsePeriod(fromParam, toParam string) (time.Time, time.Time, error) {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var err error
+	if fromParam != "" {
+		from, err = time.ParseInLocation(dateLayout, fromParam, now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from date, expected YYYY-MM-DD")
+		}
+	}
+	if toParam != "" {
+		to, err = time.ParseInLocation(dateLayout, toParam, now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to date, expected YYYY-MM-DD")
+		}
+	}
+
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from date must not be after to date")
+	}
+
+	return from, to, nil
+}
+
+// buildStatement fetches the user's wallet entries for the period and computes balances
+func buildStatement(userID int64, from, to time.Time) (*Statement, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	statement := Statement{
+		UserID:      userID,
+		From:        from,
+		To:          to,
+		GeneratedAt: time.Now(),
+	}
+
+	err = db.QueryRow(context.Background(), "SELECT fullname, email FROM users WHERE user_id = $1 AND deleted = false", userID).
+		Scan(&statement.Fullname, &statement.Email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	var currentBalance float64
+	err = db.QueryRow(context.Background(), `
+		SELECT COALESCE(MIN(account_number), ''), COALESCE(MIN(bank_name), ''), COALESCE(SUM(current_balance), 0)
+		FROM wallet
+		WHERE user_id = $1 AND deleted = false
+	`, userID).Scan(&statement.AccountNumber, &statement.BankName, &currentBalance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch wallet: %w", err)
+	}
+
+	// Entries are linked either directly by user_id or, for DVA credits, through the wallet customer_code
+	end := to.AddDate(0, 0, 1)
+	ownership := `(t.user_id = $1 OR t.customer_code IN (SELECT customer_code FROM wallet WHERE user_id = $1))`
+
+	// Work back from the current balance to the closing balance of the period
+	var netAfter float64
+	err = db.QueryRow(context.Background(), `
+		SELECT COALESCE(SUM(CASE WHEN t.transaction_type = 'credit' THEN t.amount ELSE -t.amount END), 0)
+		FROM user_transaction t
+		WHERE `+ownership+` AND t.created_at >= $2 AND t.amount IS NOT NULL
+	`, userID, end).Scan(&netAfter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute closing balance: %w", err)
+	}
+	statement.ClosingBalance = currentBalance - netAfter
+
+	rows, err := db.Query(context.Background(), `
+		SELECT t.created_at, t.transaction_type, COALESCE(t.amount, 0), COALESCE(t.narration, ''),
+			COALESCE(t.reference, t.reference_id, t.transfer_code, ''), COALESCE(t.account_name, ''), COALESCE(t.bank_name, t.bank, '')
+		FROM user_transaction t
+		WHERE `+ownership+` AND t.created_at >= $2 AND t.created_at < $3
+		ORDER BY t.created_at ASC
+	`, userID, from, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line Line
+		var narration, accountName, bankName string
+		if err := rows.Scan(&line.Date, &line.Type, &line.Amount, &narration, &line.Reference, &accountName, &bankName); err != nil {
+			return nil, err
+		}
+		line.Description = describe(line.Type, narration, accountName, bankName)
+		if line.Type == "credit" {
+			statement.TotalCredit += line.Amount
+		} else {
+			statement.TotalDebit += line.Amount
+		}
+		statement.Lines = append(statement.Lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statement.OpeningBalance = statement.ClosingBalance - statement.TotalCredit + statement.TotalDebit
+
+	balance := statement.OpeningBalance
+	for i := range statement.Lines {
+		if statement.Lines[i].Type == "credit" {
+			balance += statement.Lines[i].Amount
+		} else {
+			balance -= statement.Lines[i].Amount
+		}
+		statement.Lines[i].Balance = balance
+	}
+
+	return &statement, nil
+}
+
+// describe builds a human readable description for a statement line
+func describe(transactionType, narration, accountName, bankName string) string {
+	if narration != "" {
+		return narration
+	}
+
+	counterparty := strings.TrimSpace(accountName + " " + bankName)
+	if transactionType == "credit" {
+		if counterparty == "" {
+			return "Wallet funding"
+		}
+		return "Wallet funding from " + counterparty
+	}
+	if counterparty == "" {
+		return "Transfer"
+	}
+	return "Transfer to " + counterparty
+}
+
+// render renders the statement in the requested format
+func render(statement *Statement, format string) ([]byte, string, error) {
+	if format == "csv" {
+		data, err := renderCSV(statement)
+		return data, "text/csv", err
+	}
+	data, err := renderPDF(statement)
+	return data, "application/pdf", err
+}
+
+// fileName builds the attachment file name for a statement
+func fileName(statement *Statement, format string) string {
+	return fmt.Sprintf("statement_%d_%s_%s.%s", statement.UserID, statement.From.Format("20060102"), statement.To.Format("20060102"), format)
+}
+
+// brandName returns the brand name printed on statements
+func brandName() string {
+	if name := os.Getenv("BRAND_NAME"); name != "" {
+		return name
+	}
+	return "Gollet"
+}
+
+// formatAmount formats an amount with thousands separators and two decimals
+func formatAmount(amount float64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	s := strconv.FormatFloat(amount, 'f', 2, 64)
+	whole, fraction := s[:len(s)-3], s[len(s)-3:]
+
+	var sb strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(digit)
+	}
+
+	return sign + sb.String() + fraction
+}
@@ -0,0 +1,404 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+)
+
+// Beneficiary types
+const (
+	BeneficiaryTypeBank  = "bank"
+	BeneficiaryTypePhone = "phone"
+)
+
+// Beneficiary represents a saved bank account or phone number a user pays regularly
+type Beneficiary struct {
+	BeneficiaryID int64     `json:"beneficiary_id"`
+	UserID        int       `json:"user_id"`
+	Type          string    `json:"type"`
+	Nickname      string    `json:"nickname"`
+	AccountNumber string    `json:"account_number,omitempty"`
+	AccountName   string    `json:"account_name,omitempty"`
+	BankCode      string    `json:"bank_code,omitempty"`
+	BankName      string    `json:"bank_name,omitempty"`
+	RecipientCode string    `json:"recipient_code,omitempty"`
+	Phone         string    `json:"phone,omitempty"`
+	Network       string    `json:"network,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// CreateBeneficiaryRequest represents the request body for saving a beneficiary
+type CreateBeneficiaryRequest struct {
+	UserID        int    `json:"user_id"`
+	Type          string `json:"type"`
+	Nickname      string `json:"nickname"`
+	AccountNumber string `json:"account_number"`
+	BankCode      string `json:"bank_code"`
+	BankName      string `json:"bank_name"`
+	Phone         string `json:"phone"`
+	Network       string `json:"network"`
+}
+
+// UpdateBeneficiaryRequest represents the request body for renaming a beneficiary
+type UpdateBeneficiaryRequest struct {
+	UserID   int    `json:"user_id"`
+	Nickname string `json:"nickname"`
+}
+
+// ErrBeneficiaryNotFound is returned when a beneficiary does not exist or belongs to another user
+var ErrBeneficiaryNotFound = errors.New("beneficiary not found")
+
+const beneficiaryColumns = `beneficiary_id, user_id, type, COALESCE(nickname, ''), COALESCE(account_number, ''), COALESCE(account_name, ''),
+	COALESCE(bank_code, ''), COALESCE(bank_name, ''), COALESCE(recipient_code, ''), COALESCE(phone, ''), COALESCE(network, ''), created_at`
+
+// CreateBeneficiaryHandler resolves and saves a new beneficiary for the user
+func CreateBeneficiaryHandler(c *gin.Context) {
+	var request CreateBeneficiaryRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON input: " + err.Error(),
+		})
+		return
+	}
+
+	beneficiary := Beneficiary{
+		UserID:   request.UserID,
+		Type:     strings.ToLower(request.Type),
+		Nickname: strings.TrimSpace(request.Nickname),
+	}
+
+	switch beneficiary.Type {
+	case BeneficiaryTypeBank:
+		if request.AccountNumber == "" || request.BankCode == "" {
+			c.JSON(http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: "account_number and bank_code are required for bank beneficiaries",
+			})
+			return
+		}
+
+		// Resolve the account and create the Paystack recipient once so later transfers can reuse them
+		account := FundTransfer{AccountNumber: request.AccountNumber, BankCode: request.BankCode}
+		accountName, err := resolveBankAccount(account)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: err.Error(),
+			})
+			return
+		}
+
+		recipientCode, err := createTransferRecipient(account, accountName)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, Response{
+				Status:  "error",
+				Message: err.Error(),
+			})
+			return
+		}
+
+		beneficiary.AccountNumber = request.AccountNumber
+		beneficiary.BankCode = request.BankCode
+		beneficiary.BankName = request.BankName
+		beneficiary.AccountName = accountName
+		beneficiary.RecipientCode = recipientCode
+		if beneficiary.Nickname == "" {
+			beneficiary.Nickname = accountName
+		}
+
+	case BeneficiaryTypePhone:
+		if request.Phone == "" {
+			c.JSON(http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: "phone is required for phone beneficiaries",
+			})
+			return
+		}
+		beneficiary.Phone = request.Phone
+		beneficiary.Network = strings.ToUpper(request.Network)
+		if beneficiary.Nickname == "" {
+			beneficiary.Nickname = request.Phone
+		}
+
+	default:
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid beneficiary type. Supported types are bank and phone",
+		})
+		return
+	}
+
+	if err := saveBeneficiary(&beneficiary); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Failed to save beneficiary: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, Response{
+		Status:  "success",
+		Message: "Beneficiary saved successfully",
+		Result:  beneficiary,
+	})
+}
+
+// ListBeneficiariesHandler returns the user's saved beneficiaries, optionally filtered by type
+func ListBeneficiariesHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user_id parameter",
+		})
+		return
+	}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to connect to database: " + err.Error(),
+		})
+		return
+	}
+	defer db.Close(context.Background())
+
+	query := "SELECT " + beneficiaryColumns + " FROM beneficiary WHERE user_id = $1 AND deleted = false"
+	args := []interface{}{userID}
+	if beneficiaryType := c.Query("type"); beneficiaryType != "" {
+		query += " AND type = $2"
+		args = append(args, strings.ToLower(beneficiaryType))
+	}
+	query += " ORDER BY nickname ASC"
+
+	rows, err := db.Query(context.Background(), query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch beneficiaries: " + err.Error(),
+		})
+		return
+	}
+	defer rows.Close()
+
+	beneficiaries := []Beneficiary{}
+	for rows.Next() {
+		beneficiary, err := scanBeneficiary(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, Response{
+				Status:  "error",
+				Message: "Failed to read beneficiaries: " + err.Error(),
+			})
+			return
+		}
+		beneficiaries = append(beneficiaries, *beneficiary)
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Beneficiaries retrieved successfully",
+		Result:  beneficiaries,
+	})
+}
+
+// UpdateBeneficiaryHandler changes the nickname of a saved beneficiary
+func UpdateBeneficiaryHandler(c *gin.Context) {
+	beneficiaryID, err := strconv.ParseInt(c.Param("beneficiary_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid beneficiary_id parameter",
+		})
+		return
+	}
+
+	var request UpdateBeneficiaryRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON input: " + err.Error(),
+		})
+		return
+	}
+	if strings.TrimSpace(request.Nickname) == "" {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "nickname is required",
+		})
+		return
+	}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to connect to database: " + err.Error(),
+		})
+		return
+	}
+	defer db.Close(context.Background())
+
+	tag, err := db.Exec(context.Background(),
+		"UPDATE beneficiary SET nickname = $1, updated_at = NOW() WHERE beneficiary_id = $2 AND user_id = $3 AND deleted = false",
+		strings.TrimSpace(request.Nickname), beneficiaryID, request.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to update beneficiary: " + err.Error(),
+		})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Beneficiary not found",
+		})
+		return
+	}
+
+	beneficiary, err := FetchBeneficiary(request.UserID, beneficiaryID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch beneficiary: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Beneficiary updated successfully",
+		Result:  beneficiary,
+	})
+}
+
+// DeleteBeneficiaryHandler removes a saved beneficiary
+func DeleteBeneficiaryHandler(c *gin.Context) {
+	beneficiaryID, err := strconv.ParseInt(c.Param("beneficiary_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid beneficiary_id parameter",
+		})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user_id parameter",
+		})
+		return
+	}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to connect to database: " + err.Error(),
+		})
+		return
+	}
+	defer db.Close(context.Background())
+
+	tag, err := db.Exec(context.Background(),
+		"UPDATE beneficiary SET deleted = true, updated_at = NOW() WHERE beneficiary_id = $1 AND user_id = $2 AND deleted = false",
+		beneficiaryID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to delete beneficiary: " + err.Error(),
+		})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Beneficiary not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Beneficiary deleted successfully",
+	})
+}
+
+// FetchBeneficiary fetches a beneficiary owned by the given user
+func FetchBeneficiary(userID int, beneficiaryID int64) (*Beneficiary, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	row := db.QueryRow(context.Background(),
+		"SELECT "+beneficiaryColumns+" FROM beneficiary WHERE beneficiary_id = $1 AND user_id = $2 AND deleted = false",
+		beneficiaryID, userID)
+	beneficiary, err := scanBeneficiary(row)
+	if err == pgx.ErrNoRows {
+		return nil, ErrBeneficiaryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return beneficiary, nil
+}
+
+// saveBeneficiary inserts the beneficiary, rejecting duplicates of an existing saved account or phone
+func saveBeneficiary(beneficiary *Beneficiary) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	var count int
+	err = db.QueryRow(context.Background(), `
+		SELECT COUNT(*) FROM beneficiary
+		WHERE user_id = $1 AND type = $2 AND deleted = false
+		AND ((type = 'bank' AND account_number = $3 AND bank_code = $4) OR (type = 'phone' AND phone = $5))
+	`, beneficiary.UserID, beneficiary.Type, beneficiary.AccountNumber, beneficiary.BankCode, beneficiary.Phone).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("this beneficiary has already been saved")
+	}
+
+	query := `
+		INSERT INTO beneficiary (user_id, type, nickname, account_number, account_name, bank_code, bank_name, recipient_code, phone, network)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING beneficiary_id, created_at
+	`
+	return db.QueryRow(context.Background(), query, beneficiary.UserID, beneficiary.Type, beneficiary.Nickname,
+		beneficiary.AccountNumber, beneficiary.AccountName, beneficiary.BankCode, beneficiary.BankName,
+		beneficiary.RecipientCode, beneficiary.Phone, beneficiary.Network).
+		Scan(&beneficiary.BeneficiaryID, &beneficiary.CreatedAt)
+}
+
+// scanBeneficiary scans a beneficiary row selected with beneficiaryColumns
+func scanBeneficiary(row pgx.Row) (*Beneficiary, error) {
+	var beneficiary Beneficiary
+	err := row.Scan(&beneficiary.BeneficiaryID, &beneficiary.UserID, &beneficiary.Type, &beneficiary.Nickname,
+		&beneficiary.AccountNumber, &beneficiary.AccountName, &beneficiary.BankCode, &beneficiary.BankName,
+		&beneficiary.RecipientCode, &beneficiary.Phone, &beneficiary.Network, &beneficiary.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &beneficiary, nil
+}
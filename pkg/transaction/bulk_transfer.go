@@ -0,0 +1,710 @@
+package transaction
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/fee"
+	"go_code/pkg/kyc"
+	"go_code/pkg/ledger"
+)
+
+// Paystack accepts at most this many transfers in one bulk request
+const maxBulkTransferItems = 100
+
+// Bulk transfer item statuses
+const (
+	BulkItemPending  = "pending"
+	BulkItemSuccess  = "success"
+	BulkItemFailed   = "failed"
+	BulkItemReversed = "reversed"
+)
+
+// BulkTransferRequest represents the JSON payload for a bulk transfer
+type BulkTransferRequest struct {
+	UserID int                `json:"user_id"`
+	Reason string             `json:"reason"`
+	Items  []BulkTransferItem `json:"items"`
+}
+
+// BulkTransferItem represents a single recipient in a bulk transfer batch
+type BulkTransferItem struct {
+	ItemID        int64     `json:"item_id,omitempty"`
+	Reference     string    `json:"reference,omitempty"`
+	AccountNumber string    `json:"account_number"`
+	BankCode      string    `json:"bank_code"`
+	BeneficiaryID int64     `json:"beneficiary_id,omitempty"`
+	AccountName   string    `json:"account_name,omitempty"`
+	RecipientCode string    `json:"-"`
+	Amount        float64   `json:"amount"` // Amount in Naira
+	Fee           float64   `json:"fee"`
+	Reason        string    `json:"reason"`
+	Status        string    `json:"status,omitempty"`
+	TransferCode  string    `json:"transfer_code,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at,omitempty"`
+}
+
+// BulkTransferBatch represents the summary of a bulk transfer batch
+type BulkTransferBatch struct {
+	BatchID        int64              `json:"batch_id"`
+	UserID         int                `json:"user_id"`
+	Reference      string             `json:"reference"`
+	Status         string             `json:"status"`
+	TotalAmount    float64            `json:"total_amount"`
+	TotalFee       float64            `json:"total_fee"`
+	ItemCount      int                `json:"item_count"`
+	SuccessCount   int                `json:"success_count"`
+	PendingCount   int                `json:"pending_count"`
+	FailedCount    int                `json:"failed_count"`
+	RefundedAmount float64            `json:"refunded_amount"`
+	CreatedAt      time.Time          `json:"created_at"`
+	Items          []BulkTransferItem `json:"items,omitempty"`
+}
+
+// PaystackBulkTransferResponse represents the response from the Paystack API for a bulk transfer
+type PaystackBulkTransferResponse struct {
+	Status  bool   `json:"status"`
+	Message string `json:"message"`
+	Data    []struct {
+		Reference    string `json:"reference"`
+		Recipient    string `json:"recipient"`
+		Amount       int64  `json:"amount"`
+		TransferCode string `json:"transfer_code"`
+		Status       string `json:"status"`
+	} `json:"data"`
+}
+
+var accountNumberRegex = regexp.MustCompile(`^[0-9]{10}$`)
+
+// BulkTransferHandler validates, reserves and submits a batch of transfers from a single wallet.
+// The batch is accepted as JSON or as a CSV upload in the "file" form field.
+func BulkTransferHandler(c *gin.Context) {
+	request, err := parseBulkTransferRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if len(request.Items) == 0 {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "The batch does not contain any transfers",
+		})
+		return
+	}
+	if len(request.Items) > maxBulkTransferItems {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("A batch can contain at most %d transfers", maxBulkTransferItems),
+		})
+		return
+	}
+
+	// Validate and resolve every account before any money moves
+	if invalid := resolveBulkTransferItems(request); invalid > 0 {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("%d of %d transfers failed validation", invalid, len(request.Items)),
+			Result:  request.Items,
+		})
+		return
+	}
+
+	amounts := make([]float64, len(request.Items))
+	for i, item := range request.Items {
+		amounts[i] = item.Amount
+	}
+	err = kyc.CheckDebit(request.UserID, amounts...)
+	if respondLimitError(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to check KYC limits: " + err.Error(),
+		})
+		return
+	}
+
+	batch, err := reserveBulkTransfer(request)
+	if err == ledger.ErrInsufficientBalance {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Insufficient balance.",
+			Result:  map[string]float64{"total_amount": batch.TotalAmount, "total_fee": batch.TotalFee},
+		})
+		return
+	}
+	if respondLimitError(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to reserve bulk transfer: " + err.Error(),
+		})
+		return
+	}
+
+	err = submitBulkTransfer(batch)
+	var rejected *transferRejectedError
+	if errors.As(err, &rejected) {
+		// Paystack refused the batch, so nothing was sent and the whole reservation is released
+		for _, item := range batch.Items {
+			if refundErr := UpdateBulkTransferItem(item.Reference, BulkItemFailed, rejected.message); refundErr != nil {
+				fmt.Println("Failed to refund bulk transfer item:", refundErr)
+			}
+		}
+		c.JSON(http.StatusBadGateway, Response{
+			Status:  "error",
+			Message: "Failed to submit bulk transfer: " + rejected.message,
+		})
+		return
+	}
+	if err != nil {
+		// Paystack may still pay the batch, so the items stay pending until the webhook or requery settles them
+		fmt.Printf("Bulk transfer %s outcome unknown, keeping it pending: %v\n", batch.Reference, err)
+	}
+
+	summary, err := fetchBulkTransferBatch(batch.BatchID, request.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch batch summary: " + err.Error(),
+		})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusAccepted, Response{
+			Status:  "success",
+			Message: "Bulk transfer is being processed, failed transfers will be refunded",
+			Result:  summary,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Bulk transfer submitted successfully",
+		Result:  summary,
+	})
+}
+
+// GetBulkTransferHandler returns the batch summary and per-item status of a bulk transfer
+func GetBulkTransferHandler(c *gin.Context) {
+	batchID, err := strconv.ParseInt(c.Param("batch_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid batch_id parameter",
+		})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user_id parameter",
+		})
+		return
+	}
+
+	summary, err := fetchBulkTransferBatch(batchID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Bulk transfer not found: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Bulk transfer retrieved successfully",
+		Result:  summary,
+	})
+}
+
+// parseBulkTransferRequest reads the batch from a JSON body or a multipart CSV upload
+func parseBulkTransferRequest(c *gin.Context) (*BulkTransferRequest, error) {
+	var request BulkTransferRequest
+
+	if !strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			return nil, fmt.Errorf("Invalid JSON input: %s", err.Error())
+		}
+		return &request, nil
+	}
+
+	userID, err := strconv.Atoi(c.PostForm("user_id"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid user_id field")
+	}
+	request.UserID = userID
+	request.Reason = c.PostForm("reason")
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("CSV file is required: %s", err.Error())
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open CSV file: %s", err.Error())
+	}
+	defer file.Close()
+
+	items, err := parseBulkTransferCSV(file)
+	if err != nil {
+		return nil, err
+	}
+	request.Items = items
+
+	return &request, nil
+}
+
+// parseBulkTransferCSV parses rows of account_number, bank_code, amount and reason.
+// A header row is optional and, when present, may list the columns in any order.
+func parseBulkTransferCSV(r io.Reader) ([]BulkTransferItem, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CSV file: %s", err.Error())
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{"account_number": 0, "bank_code": 1, "amount": 2, "reason": 3}
+	if _, err := strconv.ParseFloat(strings.TrimSpace(records[0][0]), 64); err != nil {
+		columns = map[string]int{}
+		for i, name := range records[0] {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		for _, required := range []string{"account_number", "bank_code", "amount"} {
+			if _, ok := columns[required]; !ok {
+				return nil, fmt.Errorf("CSV header is missing the %s column", required)
+			}
+		}
+		records = records[1:]
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var items []BulkTransferItem
+	for line, record := range records {
+		amount, err := strconv.ParseFloat(field(record, "amount"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid amount on row %d", line+1)
+		}
+		items = append(items, BulkTransferItem{
+			AccountNumber: field(record, "account_number"),
+			BankCode:      field(record, "bank_code"),
+			Amount:        amount,
+			Reason:        field(record, "reason"),
+		})
+	}
+
+	return items, nil
+}
+
+// resolveBulkTransferItems validates every item and resolves its account name and recipient code.
+// It returns the number of items that failed, recording the failure on each item.
+func resolveBulkTransferItems(request *BulkTransferRequest) int {
+	invalid := 0
+	for i := range request.Items {
+		item := &request.Items[i]
+		if item.Reason == "" {
+			item.Reason = request.Reason
+		}
+
+		if item.Amount <= 0 {
+			item.Error = "amount must be greater than zero"
+			invalid++
+			continue
+		}
+
+		if item.BeneficiaryID != 0 {
+			beneficiary, err := FetchBeneficiary(request.UserID, item.BeneficiaryID)
+			if err != nil || beneficiary.Type != BeneficiaryTypeBank {
+				item.Error = "bank beneficiary not found"
+				invalid++
+				continue
+			}
+			item.AccountNumber = beneficiary.AccountNumber
+			item.BankCode = beneficiary.BankCode
+			item.AccountName = beneficiary.AccountName
+			item.RecipientCode = beneficiary.RecipientCode
+			continue
+		}
+
+		if !accountNumberRegex.MatchString(item.AccountNumber) || item.BankCode == "" {
+			item.Error = "a 10 digit account_number and a bank_code are required"
+			invalid++
+			continue
+		}
+
+		account := FundTransfer{AccountNumber: item.AccountNumber, BankCode: item.BankCode}
+		accountName, err := resolveBankAccount(account)
+		if err != nil {
+			item.Error = err.Error()
+			invalid++
+			continue
+		}
+		recipientCode, err := createTransferRecipient(account, accountName)
+		if err != nil {
+			item.Error = err.Error()
+			invalid++
+			continue
+		}
+		item.AccountName = accountName
+		item.RecipientCode = recipientCode
+	}
+
+	return invalid
+}
+
+// reserveBulkTransfer debits the batch total and the fee on each transfer from the wallet
+// and records the batch and its items
+func reserveBulkTransfer(request *BulkTransferRequest) (*BulkTransferBatch, error) {
+	batch := &BulkTransferBatch{
+		UserID:    request.UserID,
+		Reference: ledger.NewReference("BULK"),
+		Status:    "processing",
+		ItemCount: len(request.Items),
+		Items:     request.Items,
+	}
+	quotes := make([]*fee.Quote, len(request.Items))
+	for i, item := range request.Items {
+		quote, err := fee.Calculate(request.UserID, fee.ProductTransfer, item.Amount)
+		if err != nil {
+			return batch, err
+		}
+		quotes[i] = quote
+		request.Items[i].Fee = quote.Fee
+		batch.TotalAmount += item.Amount
+		batch.TotalFee += quote.Fee
+	}
+	batch.TotalAmount = math.Round(batch.TotalAmount*100) / 100
+	batch.TotalFee = math.Round(batch.TotalFee*100) / 100
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return batch, err
+	}
+	defer db.Close(context.Background())
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return batch, err
+	}
+	defer tx.Rollback(context.Background())
+
+	amounts := make([]float64, len(batch.Items))
+	for i, item := range batch.Items {
+		amounts[i] = item.Amount
+	}
+	if err := kyc.CheckDebitTx(tx, request.UserID, amounts...); err != nil {
+		return batch, err
+	}
+
+	debitID, err := ledger.Debit(tx, ledger.Entry{
+		UserID:    request.UserID,
+		Amount:    batch.TotalAmount,
+		Reference: batch.Reference,
+		Narration: fmt.Sprintf("Bulk transfer to %d recipients", batch.ItemCount),
+	})
+	if err != nil {
+		return batch, err
+	}
+
+	err = tx.QueryRow(context.Background(), `
+		INSERT INTO bulk_transfer_batch (user_id, reference, total_amount, total_fee, item_count, status, debit_transaction_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING batch_id, created_at
+	`, batch.UserID, batch.Reference, batch.TotalAmount, batch.TotalFee, batch.ItemCount, batch.Status, debitID).Scan(&batch.BatchID, &batch.CreatedAt)
+	if err != nil {
+		return batch, fmt.Errorf("failed to save batch: %w", err)
+	}
+
+	for i := range batch.Items {
+		item := &batch.Items[i]
+		item.Reference = fmt.Sprintf("%s-%d", batch.Reference, i+1)
+		item.Status = BulkItemPending
+		err = tx.QueryRow(context.Background(), `
+			INSERT INTO bulk_transfer_item (batch_id, reference, account_number, bank_code, account_name, recipient_code, amount, fee, reason, status)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			RETURNING item_id
+		`, batch.BatchID, item.Reference, item.AccountNumber, item.BankCode, item.AccountName, item.RecipientCode, item.Amount, item.Fee, item.Reason, item.Status).Scan(&item.ItemID)
+		if err != nil {
+			return batch, fmt.Errorf("failed to save batch item: %w", err)
+		}
+
+		if _, err := fee.Charge(tx, batch.UserID, quotes[i], item.Reference); err != nil {
+			return batch, err
+		}
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return batch, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return batch, nil
+}
+
+// submitBulkTransfer sends the batch to Paystack's bulk transfer endpoint and stores the transfer codes.
+// It returns a transferRejectedError only when Paystack refused the batch; any other error leaves the
+// outcome unknown.
+func submitBulkTransfer(batch *BulkTransferBatch) error {
+	type transfer struct {
+		Amount    int64  `json:"amount"`
+		Reference string `json:"reference"`
+		Reason    string `json:"reason"`
+		Recipient string `json:"recipient"`
+	}
+
+	transfers := make([]transfer, len(batch.Items))
+	for i, item := range batch.Items {
+		transfers[i] = transfer{
+			Amount:    int64(math.Round(item.Amount * 100)), // Convert Naira to Kobo
+			Reference: item.Reference,
+			Reason:    item.Reason,
+			Recipient: item.RecipientCode,
+		}
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"currency":  "NGN",
+		"source":    "balance",
+		"transfers": transfers,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to marshal JSON: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", "https://api.paystack.co/transfer/bulk", bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("Failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("PAYSTACK_SECRET_KEY"))
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to read response: %w", err)
+	}
+
+	var bulkResponse PaystackBulkTransferResponse
+	parseErr := json.Unmarshal(body, &bulkResponse)
+
+	// Paystack answers a batch it refuses with a 4xx or a false status; any other failure leaves the outcome unknown
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		message := bulkResponse.Message
+		if message == "" {
+			message = string(body)
+		}
+		return &transferRejectedError{message: message}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error from Paystack: %s", body)
+	}
+	if parseErr != nil {
+		return fmt.Errorf("Failed to parse response: %w", parseErr)
+	}
+	if !bulkResponse.Status {
+		return &transferRejectedError{message: bulkResponse.Message}
+	}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	for _, result := range bulkResponse.Data {
+		_, err = db.Exec(context.Background(),
+			"UPDATE bulk_transfer_item SET transfer_code = $1, updated_at = NOW() WHERE reference = $2",
+			result.TransferCode, result.Reference)
+		if err != nil {
+			fmt.Println("Failed to save transfer code:", err)
+		}
+	}
+
+	return nil
+}
+
+// UpdateBulkTransferItem records the final status of a bulk transfer item reported by Paystack.
+// Failed and reversed items are refunded to the wallet exactly once. References that do not
+// belong to a bulk transfer are ignored.
+func UpdateBulkTransferItem(reference, status, failureReason string) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	var batchID int64
+	var userID int
+	var amount, itemFee float64
+	var accountName string
+	var refunded bool
+	err = tx.QueryRow(context.Background(), `
+		SELECT i.batch_id, b.user_id, i.amount, COALESCE(i.fee, 0), COALESCE(i.account_name, ''), i.refunded
+		FROM bulk_transfer_item i
+		JOIN bulk_transfer_batch b ON b.batch_id = i.batch_id
+		WHERE i.reference = $1
+		FOR UPDATE OF i
+	`, reference).Scan(&batchID, &userID, &amount, &itemFee, &accountName, &refunded)
+	if err == pgx.ErrNoRows {
+		// Not a bulk transfer reference
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch batch item: %w", err)
+	}
+
+	refund := (status == BulkItemFailed || status == BulkItemReversed) && !refunded
+	_, err = tx.Exec(context.Background(), `
+		UPDATE bulk_transfer_item SET status = $1, failure_reason = NULLIF($2, ''), refunded = refunded OR $3, updated_at = NOW()
+		WHERE reference = $4
+	`, status, failureReason, refund, reference)
+	if err != nil {
+		return fmt.Errorf("failed to update batch item: %w", err)
+	}
+
+	if refund {
+		_, err = ledger.Credit(tx, ledger.Entry{
+			UserID:      userID,
+			Amount:      amount,
+			Reference:   reference,
+			Narration:   "Refund for failed bulk transfer to " + accountName,
+			AccountName: accountName,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := fee.Refund(tx, userID, fee.ProductTransfer, itemFee, reference); err != nil {
+			return err
+		}
+	}
+
+	// Close the batch once no item is still pending
+	_, err = tx.Exec(context.Background(), `
+		UPDATE bulk_transfer_batch b SET status = CASE
+				WHEN s.failed = 0 THEN 'completed'
+				WHEN s.failed = b.item_count THEN 'failed'
+				ELSE 'partially_failed'
+			END, updated_at = NOW()
+		FROM (
+			SELECT COUNT(*) FILTER (WHERE status = 'pending') AS pending,
+				COUNT(*) FILTER (WHERE status IN ('failed', 'reversed')) AS failed
+			FROM bulk_transfer_item WHERE batch_id = $1
+		) s
+		WHERE b.batch_id = $1 AND s.pending = 0
+	`, batchID)
+	if err != nil {
+		return fmt.Errorf("failed to update batch: %w", err)
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// fetchBulkTransferBatch fetches a batch owned by the user together with its items
+func fetchBulkTransferBatch(batchID int64, userID int) (*BulkTransferBatch, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	var batch BulkTransferBatch
+	err = db.QueryRow(context.Background(), `
+		SELECT batch_id, user_id, reference, status, total_amount, COALESCE(total_fee, 0), item_count, created_at
+		FROM bulk_transfer_batch
+		WHERE batch_id = $1 AND user_id = $2
+	`, batchID, userID).Scan(&batch.BatchID, &batch.UserID, &batch.Reference, &batch.Status, &batch.TotalAmount, &batch.TotalFee, &batch.ItemCount, &batch.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(context.Background(), `
+		SELECT item_id, reference, account_number, bank_code, COALESCE(account_name, ''), amount, COALESCE(fee, 0), COALESCE(reason, ''),
+			status, COALESCE(transfer_code, ''), COALESCE(failure_reason, ''), refunded, updated_at
+		FROM bulk_transfer_item
+		WHERE batch_id = $1
+		ORDER BY item_id ASC
+	`, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item BulkTransferItem
+		var refunded bool
+		err := rows.Scan(&item.ItemID, &item.Reference, &item.AccountNumber, &item.BankCode, &item.AccountName, &item.Amount,
+			&item.Fee, &item.Reason, &item.Status, &item.TransferCode, &item.Error, &refunded, &item.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		switch item.Status {
+		case BulkItemSuccess:
+			batch.SuccessCount++
+		case BulkItemFailed, BulkItemReversed:
+			batch.FailedCount++
+		default:
+			batch.PendingCount++
+		}
+		if refunded {
+			batch.RefundedAmount += item.Amount + item.Fee
+		}
+		batch.Items = append(batch.Items, item)
+	}
+
+	return &batch, rows.Err()
+}
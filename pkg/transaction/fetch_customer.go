@@ -61,62 +61,53 @@ type CustomerResponse struct {
 
 // GetCustomerHandler handles the request to fetch customer details
 func GetCustomerHandler(c *gin.Context) {
-	emailOrCode := c.Param("emailOrCode")
-	url := fmt.Sprintf("https://api.paystack.co/customer/%s", emailOrCode)
-	authorization := "Bearer " + os.Getenv("PAYSTACK_SECRET_KEY")
-
-	req, err := http.NewRequest("GET", url, nil)
+	customerResponse, err := FetchCustomer(c.Param("emailOrCode"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Status:  "error",
-			Message: "Failed to create request: " + err.Error(),
+			Message: err.Error(),
 		})
 		return
 	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Customer details retrieved successfully",
+		Result:  customerResponse.Data,
+	})
+}
+
+// FetchCustomer fetches a Paystack customer, including their saved card authorizations, by email or customer code
+func FetchCustomer(emailOrCode string) (*CustomerResponse, error) {
+	url := fmt.Sprintf("https://api.paystack.co/customer/%s", emailOrCode)
+	authorization := "Bearer " + os.Getenv("PAYSTACK_SECRET_KEY")
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request: %w", err)
+	}
 	req.Header.Set("Authorization", authorization)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to send request: " + err.Error(),
-		})
-		return
+		return nil, fmt.Errorf("Failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to read response: " + err.Error(),
-		})
-		return
+		return nil, fmt.Errorf("Failed to read response: %w", err)
 	}
 
 	var customerResponse CustomerResponse
 	if err := json.Unmarshal(body, &customerResponse); err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to parse response: " + err.Error(),
-			Result:  json.RawMessage(body),
-		})
-		return
+		return nil, fmt.Errorf("Failed to parse response: %w", err)
 	}
 
 	if !customerResponse.Status {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to fetch customer details: " + customerResponse.Message,
-			Result:  customerResponse,
-		})
-		return
+		return nil, fmt.Errorf("Failed to fetch customer details: %s", customerResponse.Message)
 	}
 
-	c.JSON(http.StatusOK, Response{
-		Status:  "success",
-		Message: "Customer details retrieved successfully",
-		Result:  customerResponse.Data,
-	})
+	return &customerResponse, nil
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -11,6 +12,10 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"go_code/database"
+	"go_code/pkg/fee"
+	"go_code/pkg/kyc"
+	"go_code/pkg/ledger"
+	"go_code/pkg/reversal"
 )
 
 // FundTransfer represents the request payload for fund transfer
@@ -21,6 +26,7 @@ type FundTransfer struct {
 	Source        string  `json:"source"`
 	Reason        string  `json:"reason"`
 	Amount        float64 `json:"amount"` // Amount in Naira
+	BeneficiaryID int64   `json:"beneficiary_id"`
 }
 
 // Response represents the generic response structure
@@ -74,8 +80,9 @@ type VerifyTransferResponse struct {
 	Status  bool   `json:"status"`
 	Message string `json:"message"`
 	Data    struct {
-		Status    string `json:"status"`
-		Recipient struct {
+		Status       string `json:"status"`
+		TransferCode string `json:"transfer_code"`
+		Recipient    struct {
 			Details struct {
 				BankName string `json:"bank_name"`
 			} `json:"details"`
@@ -83,6 +90,20 @@ type VerifyTransferResponse struct {
 	} `json:"data"`
 }
 
+// TransferResult represents the outcome of an outgoing bank transfer
+type TransferResult struct {
+	TransactionID int64   `json:"transaction_id"`
+	Reference     string  `json:"reference"`
+	Status        string  `json:"status"`
+	TransferCode  string  `json:"transfer_code"`
+	AccountNumber string  `json:"account_number"`
+	AccountName   string  `json:"account_name"`
+	BankName      string  `json:"bank_name"`
+	Amount        float64 `json:"amount"`
+	Fee           float64 `json:"fee"`
+	TotalDebited  float64 `json:"total_debited"`
+}
+
 // FundTransferHandler handles the complete fund transfer process
 func FundTransferHandler(c *gin.Context) {
 	var fundTransfer FundTransfer
@@ -99,51 +120,42 @@ func FundTransferHandler(c *gin.Context) {
 		return
 	}
 
-	// Resolve the bank account information
-	accountName, err := resolveBankAccount(fundTransfer)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
+	result, err := ExecuteTransfer(fundTransfer)
+	if err == ErrBeneficiaryNotFound {
+		c.JSON(http.StatusNotFound, Response{
 			Status:  "error",
-			Message: err.Error(),
+			Message: "Bank beneficiary not found",
 		})
 		return
 	}
-
-	// Create transfer recipient
-	recipientCode, err := createTransferRecipient(fundTransfer, accountName)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
+	if err == ledger.ErrInsufficientBalance {
+		c.JSON(http.StatusBadRequest, Response{
 			Status:  "error",
-			Message: err.Error(),
+			Message: "Insufficient balance.",
 		})
 		return
 	}
-
-	// Initiate the transfer
-	transferCode, reference, err := initiateTransfer(fundTransfer, recipientCode)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: err.Error(),
-		})
+	if respondLimitError(c, err) {
 		return
 	}
-
-	// Verify the transfer
-	bankName, err := verifyTransfer(reference)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Status:  "error",
-			Message: "Failed to verify transfer: " + err.Error(),
+			Message: err.Error(),
 		})
 		return
 	}
 
-	// Save the transfer data in the database
-	if err := saveTransferDataInDatabase(fundTransfer.UserID, recipientCode, transferCode, accountName, bankName, fundTransfer.BankCode); err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Status:  "error",
-			Message: "Failed to save transfer data: " + err.Error(),
+	respondTransferResult(c, result)
+}
+
+// respondTransferResult writes a sent transfer, answering 202 while its outcome is still unknown
+func respondTransferResult(c *gin.Context, result *TransferResult) {
+	if result.Status == TransferStatusPending {
+		c.JSON(http.StatusAccepted, Response{
+			Status:  "success",
+			Message: "Transfer is being processed, you will be refunded if it fails",
+			Result:  result,
 		})
 		return
 	}
@@ -151,9 +163,174 @@ func FundTransferHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, Response{
 		Status:  "success",
 		Message: "Fund transfer process completed and verified",
+		Result:  result,
 	})
 }
 
+// ExecuteTransfer resolves the destination account and fee, then sends the money through Paystack
+func ExecuteTransfer(fundTransfer FundTransfer) (*TransferResult, error) {
+	if fundTransfer.Amount <= 0 {
+		return nil, fmt.Errorf("Amount must be greater than zero")
+	}
+
+	accountName, recipientCode, err := resolveTransferRecipient(&fundTransfer)
+	if err != nil {
+		return nil, err
+	}
+
+	quote, err := fee.Calculate(fundTransfer.UserID, fee.ProductTransfer, fundTransfer.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	return sendTransfer(fundTransfer, accountName, recipientCode, quote)
+}
+
+// resolveTransferRecipient returns the account name and Paystack recipient code for the transfer destination.
+// A saved beneficiary's account details are copied onto the transfer.
+func resolveTransferRecipient(fundTransfer *FundTransfer) (string, string, error) {
+	if fundTransfer.BeneficiaryID != 0 {
+		// Reuse the resolved account and recipient code cached on the saved beneficiary
+		beneficiary, err := FetchBeneficiary(fundTransfer.UserID, fundTransfer.BeneficiaryID)
+		if err != nil {
+			return "", "", err
+		}
+		if beneficiary.Type != BeneficiaryTypeBank {
+			return "", "", ErrBeneficiaryNotFound
+		}
+
+		fundTransfer.AccountNumber = beneficiary.AccountNumber
+		fundTransfer.BankCode = beneficiary.BankCode
+		return beneficiary.AccountName, beneficiary.RecipientCode, nil
+	}
+
+	// Resolve the bank account information
+	accountName, err := resolveBankAccount(*fundTransfer)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Create transfer recipient
+	recipientCode, err := createTransferRecipient(*fundTransfer, accountName)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accountName, recipientCode, nil
+}
+
+// sendTransfer debits the wallet and sends the money to an already resolved recipient through Paystack.
+// The debit is reversed if Paystack rejects the transfer. When the outcome is unknown the transfer is
+// returned as pending and settled later by the transfer webhook or requery.
+func sendTransfer(fundTransfer FundTransfer, accountName, recipientCode string, quote *fee.Quote) (*TransferResult, error) {
+	// Debit the wallet and the fee before the money leaves so concurrent requests cannot overspend it
+	reference := ledger.NewReference("TRF")
+	transactionID, err := debitWithFee(ledger.Entry{
+		UserID:      fundTransfer.UserID,
+		Amount:      fundTransfer.Amount,
+		Reference:   reference,
+		Narration:   "Transfer to " + accountName,
+		AccountName: accountName,
+	}, quote)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TransferResult{
+		TransactionID: transactionID,
+		Reference:     reference,
+		Status:        TransferStatusSubmitted,
+		AccountNumber: fundTransfer.AccountNumber,
+		AccountName:   accountName,
+		Amount:        fundTransfer.Amount,
+		Fee:           quote.Fee,
+		TotalDebited:  quote.Total,
+	}
+
+	// Initiate the transfer
+	transferCode, _, err := initiateTransfer(fundTransfer, recipientCode, reference)
+	var rejected *transferRejectedError
+	if errors.As(err, &rejected) {
+		if _, refundErr := reversal.Reverse(transactionID, "Transfer failed: "+err.Error(), reversal.InitiatedBySystem); refundErr != nil {
+			fmt.Println("Failed to refund transfer:", refundErr)
+		}
+		return nil, err
+	}
+	if err != nil {
+		// Paystack may still have queued the transfer, so the debit stays until the webhook or requery settles it
+		fmt.Printf("Transfer %s outcome unknown, keeping it pending: %v\n", reference, err)
+		result.Status = TransferStatusPending
+		if err := saveTransferDataInDatabase(transactionID, recipientCode, "", accountName, "", fundTransfer.BankCode); err != nil {
+			fmt.Println("Failed to save transfer data:", err)
+		}
+		return result, nil
+	}
+
+	// Verify the transfer. Paystack has accepted it at this point, so a failed lookup is not fatal.
+	bankName, err := verifyTransfer(reference)
+	if err != nil {
+		fmt.Println("Failed to verify transfer:", err)
+	}
+
+	// Save the transfer data in the database
+	if err := saveTransferDataInDatabase(transactionID, recipientCode, transferCode, accountName, bankName, fundTransfer.BankCode); err != nil {
+		fmt.Println("Failed to save transfer data:", err)
+	}
+
+	result.TransferCode = transferCode
+	result.BankName = bankName
+	return result, nil
+}
+
+// debitWithFee checks the KYC limits and debits the transfer amount and its fee from the wallet in a
+// single database transaction
+func debitWithFee(entry ledger.Entry, quote *fee.Quote) (int64, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to connect to database: %w", err)
+	}
+	defer db.Close(context.Background())
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(context.Background())
+
+	if err := kyc.CheckDebitTx(tx, entry.UserID, entry.Amount); err != nil {
+		return 0, err
+	}
+
+	transactionID, err := ledger.Debit(tx, entry)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := fee.Charge(tx, entry.UserID, quote, entry.Reference); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return 0, fmt.Errorf("Failed to commit transaction: %w", err)
+	}
+
+	return transactionID, nil
+}
+
+// respondLimitError writes a 403 response and returns true if err is a KYC limit error
+func respondLimitError(c *gin.Context, err error) bool {
+	var limitErr *kyc.LimitError
+	if !errors.As(err, &limitErr) {
+		return false
+	}
+	c.JSON(http.StatusForbidden, Response{
+		Status:  "error",
+		Message: limitErr.Error(),
+		Result:  limitErr,
+	})
+	return true
+}
+
 // checkBalanceAndProceed checks the user's balance and proceeds with the transfer if sufficient
 func checkBalanceAndProceed(c *gin.Context, fundTransfer FundTransfer) error {
 	db, err := database.PostgreSQLConnect()
@@ -271,18 +448,20 @@ func createTransferRecipient(fundTransfer FundTransfer, accountName string) (str
 }
 
 // initiateTransfer initiates the transfer using the Paystack API
-func initiateTransfer(fundTransfer FundTransfer, recipientCode string) (string, string, error) {
+func initiateTransfer(fundTransfer FundTransfer, recipientCode, reference string) (string, string, error) {
 	initiateTransferURL := "https://api.paystack.co/transfer"
 	transferData := struct {
 		Source    string  `json:"source"`
 		Reason    string  `json:"reason"`
 		Amount    float64 `json:"amount"`
 		Recipient string  `json:"recipient"`
+		Reference string  `json:"reference"`
 	}{
 		Source:    fundTransfer.Source,
 		Reason:    fundTransfer.Reason,
 		Amount:    fundTransfer.Amount * 100, // Convert Naira to Kobo
 		Recipient: recipientCode,
+		Reference: reference,
 	}
 	transferDataJSON, err := json.Marshal(transferData)
 	if err != nil {
@@ -313,15 +492,20 @@ func initiateTransfer(fundTransfer FundTransfer, recipientCode string) (string,
 	fmt.Println("Response Body:", string(body))
 
 	var initiateTransferResponse InitiateTransferResponse
-	if err := json.Unmarshal(body, &initiateTransferResponse); err != nil {
-		return "", "", fmt.Errorf("Failed to parse response: %w", err)
-	}
+	parseErr := json.Unmarshal(body, &initiateTransferResponse)
 
+	// Paystack answers a transfer it refuses with a 4xx; any other failure leaves the outcome unknown
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return "", "", &transferRejectedError{message: initiateTransferResponse.Message}
+	}
+	if parseErr != nil {
+		return "", "", fmt.Errorf("Failed to parse response: %w", parseErr)
+	}
 	if !initiateTransferResponse.Status {
 		return "", "", fmt.Errorf("Failed to initiate transfer: %s", initiateTransferResponse.Message)
 	}
 
-	// Handle the recipient data based on its type
+	// Handle the recipient data based on its type. Paystack has accepted the transfer, so an unexpected type is only logged.
 	switch recipient := initiateTransferResponse.Data.Recipient.(type) {
 	case float64:
 		// Recipient is an ID
@@ -330,7 +514,7 @@ func initiateTransfer(fundTransfer FundTransfer, recipientCode string) (string,
 		// Recipient is a detailed object
 		fmt.Printf("Recipient Data: %+v\n", recipient)
 	default:
-		return "", "", fmt.Errorf("Unexpected recipient type: %T", recipient)
+		fmt.Printf("Unexpected recipient type: %T\n", recipient)
 	}
 
 	return initiateTransferResponse.Data.TransferCode, initiateTransferResponse.Data.TransferReference, nil
@@ -338,41 +522,56 @@ func initiateTransfer(fundTransfer FundTransfer, recipientCode string) (string,
 
 // verifyTransfer verifies the transfer using the Paystack API
 func verifyTransfer(reference string) (string, error) {
+	verifyTransferResponse, err := fetchTransfer(reference)
+	if err != nil {
+		return "", err
+	}
+
+	return verifyTransferResponse.Data.Recipient.Details.BankName, nil
+}
+
+// fetchTransfer looks up a transfer on Paystack by its reference. errTransferNotFound is returned when
+// Paystack has no transfer with the reference.
+func fetchTransfer(reference string) (*VerifyTransferResponse, error) {
 	verifyTransferURL := fmt.Sprintf("https://api.paystack.co/transfer/verify/%s", reference)
 	authorization := "Bearer " + os.Getenv("PAYSTACK_SECRET_KEY")
 
 	req, err := http.NewRequest("GET", verifyTransferURL, nil)
 	if err != nil {
-		return "", fmt.Errorf("Failed to create request: %w", err)
+		return nil, fmt.Errorf("Failed to create request: %w", err)
 	}
 	req.Header.Set("Authorization", authorization)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("Failed to send request: %w", err)
+		return nil, fmt.Errorf("Failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errTransferNotFound
+	}
+
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("Failed to read response: %w", err)
+		return nil, fmt.Errorf("Failed to read response: %w", err)
 	}
 
 	var verifyTransferResponse VerifyTransferResponse
 	if err := json.Unmarshal(body, &verifyTransferResponse); err != nil {
-		return "", fmt.Errorf("Failed to parse response: %w", err)
+		return nil, fmt.Errorf("Failed to parse response: %w", err)
 	}
 
 	if !verifyTransferResponse.Status {
-		return "", fmt.Errorf("Failed to verify transfer: %s", verifyTransferResponse.Message)
+		return nil, fmt.Errorf("Failed to verify transfer: %s", verifyTransferResponse.Message)
 	}
 
-	return verifyTransferResponse.Data.Recipient.Details.BankName, nil
+	return &verifyTransferResponse, nil
 }
 
-// saveTransferDataInDatabase saves the transfer data in the database
-func saveTransferDataInDatabase(userID int, recipientCode, transferCode, accountName, bankName, bankCode string) error {
+// saveTransferDataInDatabase saves the Paystack transfer details on the wallet debit entry
+func saveTransferDataInDatabase(transactionID int64, recipientCode, transferCode, accountName, bankName, bankCode string) error {
 	db, err := database.PostgreSQLConnect()
 	if err != nil {
 		return fmt.Errorf("Failed to connect to database: %w", err)
@@ -380,13 +579,14 @@ func saveTransferDataInDatabase(userID int, recipientCode, transferCode, account
 	defer db.Close(context.Background())
 
 	sqlStatement := `
-		INSERT INTO user_transaction (user_id, recipient_code, transfer_code, account_name, bank_name, bank_code, transaction_type)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		UPDATE user_transaction
+		SET recipient_code = $1, transfer_code = NULLIF($2, ''), account_name = $3, bank_name = NULLIF($4, ''), bank_code = $5
+		WHERE transaction_id = $6
 	`
-	_, err = db.Exec(context.Background(), sqlStatement, userID, recipientCode, transferCode, accountName, bankName, bankCode, "debit")
+	_, err = db.Exec(context.Background(), sqlStatement, recipientCode, transferCode, accountName, bankName, bankCode, transactionID)
 	if err != nil {
 		return fmt.Errorf("Failed to save transfer data: %w", err)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
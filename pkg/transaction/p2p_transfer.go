@@ -0,0 +1,323 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/fee"
+	"go_code/pkg/kyc"
+	"go_code/pkg/ledger"
+	"go_code/pkg/notification"
+)
+
+// P2PTransfer represents the request payload for a wallet-to-wallet transfer
+type P2PTransfer struct {
+	UserID    int     `json:"user_id"`
+	Recipient string  `json:"recipient"` // Email, phone number or wallet account number
+	Amount    float64 `json:"amount"`    // Amount in Naira
+	Narration string  `json:"narration"`
+}
+
+// WalletRecipient represents the platform user a P2P transfer is addressed to
+type WalletRecipient struct {
+	UserID        int    `json:"user_id"`
+	Fullname      string `json:"fullname"`
+	Email         string `json:"-"`
+	AccountNumber string `json:"account_number"`
+	BankName      string `json:"bank_name"`
+}
+
+// P2PTransferResult represents the result of a completed wallet-to-wallet transfer
+type P2PTransferResult struct {
+	Reference      string          `json:"reference"`
+	Amount         float64         `json:"amount"`
+	Fee            float64         `json:"fee"`
+	Recipient      WalletRecipient `json:"recipient"`
+	CurrentBalance float64         `json:"current_balance"`
+}
+
+var nonDigitRegex = regexp.MustCompile(`[^0-9]`)
+
+// LookupWalletRecipientHandler resolves a recipient's name so the sender can confirm before transferring
+func LookupWalletRecipientHandler(c *gin.Context) {
+	identifier := c.Query("identifier")
+	if identifier == "" {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "identifier is required",
+		})
+		return
+	}
+
+	recipient, err := resolveWalletRecipient(identifier)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to resolve recipient: " + err.Error(),
+		})
+		return
+	}
+	if recipient == nil {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "No wallet found for this recipient",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Recipient resolved successfully",
+		Result:  recipient,
+	})
+}
+
+// P2PTransferHandler handles an instant transfer between two wallets on the platform
+func P2PTransferHandler(c *gin.Context) {
+	var transfer P2PTransfer
+	if err := c.ShouldBindJSON(&transfer); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON input: " + err.Error(),
+		})
+		return
+	}
+
+	if transfer.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Amount must be greater than zero",
+		})
+		return
+	}
+
+	recipient, err := resolveWalletRecipient(transfer.Recipient)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to resolve recipient: " + err.Error(),
+		})
+		return
+	}
+	if recipient == nil {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "No wallet found for this recipient",
+		})
+		return
+	}
+	if recipient.UserID == transfer.UserID {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "You cannot transfer to your own wallet",
+		})
+		return
+	}
+
+	result, sender, err := executeP2PTransfer(transfer, *recipient)
+	if err == ledger.ErrInsufficientBalance {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Insufficient balance.",
+		})
+		return
+	}
+	if err == errRecipientLimit {
+		c.JSON(http.StatusForbidden, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+	if respondLimitError(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to complete transfer: " + err.Error(),
+		})
+		return
+	}
+
+	notifyP2PTransfer(sender, *recipient, result)
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Transfer completed successfully",
+		Result:  result,
+	})
+}
+
+// resolveWalletRecipient finds the user owning a wallet by email, phone number or account number
+func resolveWalletRecipient(identifier string) (*WalletRecipient, error) {
+	identifier = strings.TrimSpace(identifier)
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	query := `
+		SELECT u.user_id, u.fullname, u.email, w.account_number, w.bank_name
+		FROM users u
+		JOIN wallet w ON w.user_id = u.user_id AND w.deleted = false
+		WHERE u.deleted = false AND %s
+		ORDER BY %s
+		LIMIT 1
+	`
+
+	var recipient WalletRecipient
+	if strings.Contains(identifier, "@") {
+		err = db.QueryRow(context.Background(), fmt.Sprintf(query, "LOWER(u.email) = LOWER($1)", "u.user_id"), identifier).
+			Scan(&recipient.UserID, &recipient.Fullname, &recipient.Email, &recipient.AccountNumber, &recipient.BankName)
+	} else {
+		// Compare the last 10 digits so 080..., 234... and +234... forms of a phone number all match
+		digits := nonDigitRegex.ReplaceAllString(identifier, "")
+		if len(digits) < 10 {
+			return nil, nil
+		}
+		// An exact account number match takes precedence over a phone number match
+		condition := `(w.account_number = $1 OR RIGHT(REGEXP_REPLACE(u.phone, '[^0-9]', '', 'g'), 10) = RIGHT($1, 10))`
+		err = db.QueryRow(context.Background(), fmt.Sprintf(query, condition, "(w.account_number = $1) DESC, u.user_id"), digits).
+			Scan(&recipient.UserID, &recipient.Fullname, &recipient.Email, &recipient.AccountNumber, &recipient.BankName)
+	}
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &recipient, nil
+}
+
+// errRecipientLimit is returned when a transfer would take the recipient over their KYC balance limit
+var errRecipientLimit = errors.New("The recipient cannot receive this amount on their current KYC tier")
+
+// executeP2PTransfer debits the sender and credits the recipient in a single database transaction
+func executeP2PTransfer(transfer P2PTransfer, recipient WalletRecipient) (*P2PTransferResult, *WalletRecipient, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close(context.Background())
+
+	var sender WalletRecipient
+	err = db.QueryRow(context.Background(), `
+		SELECT u.user_id, u.fullname, u.email, w.account_number, w.bank_name
+		FROM users u
+		JOIN wallet w ON w.user_id = u.user_id AND w.deleted = false
+		WHERE u.user_id = $1 AND u.deleted = false
+		LIMIT 1
+	`, transfer.UserID).Scan(&sender.UserID, &sender.Fullname, &sender.Email, &sender.AccountNumber, &sender.BankName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch sender wallet: %w", err)
+	}
+
+	if err := kyc.CheckCredit(recipient.UserID, transfer.Amount); err != nil {
+		if errors.Is(err, kyc.ErrLimitExceeded) {
+			return nil, nil, errRecipientLimit
+		}
+		return nil, nil, err
+	}
+
+	quote, err := fee.Calculate(transfer.UserID, fee.ProductP2P, transfer.Amount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	// Lock both wallets in a consistent order so opposite transfers between the same users cannot deadlock
+	_, err = tx.Exec(context.Background(),
+		"SELECT wallet_id FROM wallet WHERE user_id IN ($1, $2) AND deleted = false ORDER BY user_id FOR UPDATE",
+		transfer.UserID, recipient.UserID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lock wallets: %w", err)
+	}
+
+	// Limits are checked under the wallet lock so concurrent transfers cannot together exceed them
+	if err := kyc.CheckDebitTx(tx, transfer.UserID, transfer.Amount); err != nil {
+		return nil, nil, err
+	}
+
+	reference := ledger.NewReference("P2P")
+	narration := transfer.Narration
+	if narration == "" {
+		narration = "Wallet transfer"
+	}
+
+	_, err = ledger.Debit(tx, ledger.Entry{
+		UserID:      transfer.UserID,
+		Amount:      transfer.Amount,
+		Reference:   reference,
+		Narration:   narration + " to " + recipient.Fullname,
+		AccountName: recipient.Fullname,
+		BankName:    recipient.BankName,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = ledger.Credit(tx, ledger.Entry{
+		UserID:      recipient.UserID,
+		Amount:      transfer.Amount,
+		Reference:   reference,
+		Narration:   narration + " from " + sender.Fullname,
+		AccountName: sender.Fullname,
+		BankName:    sender.BankName,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := fee.Charge(tx, transfer.UserID, quote, reference); err != nil {
+		return nil, nil, err
+	}
+
+	var currentBalance float64
+	err = tx.QueryRow(context.Background(), "SELECT current_balance FROM wallet WHERE user_id = $1 AND deleted = false LIMIT 1", transfer.UserID).Scan(&currentBalance)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve balance: %w", err)
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &P2PTransferResult{
+		Reference:      reference,
+		Amount:         transfer.Amount,
+		Fee:            quote.Fee,
+		Recipient:      recipient,
+		CurrentBalance: currentBalance,
+	}, &sender, nil
+}
+
+// notifyP2PTransfer emails debit and credit alerts to both sides of the transfer
+func notifyP2PTransfer(sender *WalletRecipient, recipient WalletRecipient, result *P2PTransferResult) {
+	debitAlert := fmt.Sprintf("Dear %s,\n\nYou sent NGN %.2f to %s (%s).\nFee: NGN %.2f\nReference: %s\nAvailable balance: NGN %.2f",
+		sender.Fullname, result.Amount, recipient.Fullname, recipient.AccountNumber, result.Fee, result.Reference, result.CurrentBalance)
+	if err := notification.SendEmail(sender.Email, "Debit Alert", debitAlert); err != nil {
+		fmt.Println("Failed to send email:", err)
+	}
+
+	creditAlert := fmt.Sprintf("Dear %s,\n\nYou received NGN %.2f from %s.\nReference: %s",
+		recipient.Fullname, result.Amount, sender.Fullname, result.Reference)
+	if err := notification.SendEmail(recipient.Email, "Credit Alert", creditAlert); err != nil {
+		fmt.Println("Failed to send email:", err)
+	}
+}
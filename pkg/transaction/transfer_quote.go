@@ -0,0 +1,291 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/fee"
+	"go_code/pkg/ledger"
+)
+
+// defaultQuoteTTL is how long a transfer quote can be confirmed when TRANSFER_QUOTE_TTL_SECONDS is not set
+const defaultQuoteTTL = 5 * time.Minute
+
+// Transfer quote statuses
+const (
+	QuoteOpen      = "open"
+	QuoteConfirmed = "confirmed"
+	QuoteCompleted = "completed"
+	QuoteFailed    = "failed"
+)
+
+// TransferQuote represents a resolved transfer awaiting confirmation by the user
+type TransferQuote struct {
+	QuoteID       string    `json:"quote_id"`
+	UserID        int       `json:"user_id"`
+	AccountNumber string    `json:"account_number"`
+	BankCode      string    `json:"bank_code"`
+	AccountName   string    `json:"account_name"`
+	Amount        float64   `json:"amount"`
+	Fee           float64   `json:"fee"`
+	Total         float64   `json:"total"`
+	Reason        string    `json:"reason"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	recipientCode string
+	source        string
+	feeQuote      *fee.Quote
+}
+
+// ConfirmTransferRequest represents the request payload for confirming a transfer quote
+type ConfirmTransferRequest struct {
+	UserID  int    `json:"user_id"`
+	QuoteID string `json:"quote_id"`
+}
+
+// TransferQuoteHandler resolves the destination account and fee of a transfer and returns a
+// short-lived quote the user confirms once they have checked the account name
+func TransferQuoteHandler(c *gin.Context) {
+	var fundTransfer FundTransfer
+	if err := c.ShouldBindJSON(&fundTransfer); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON input: " + err.Error(),
+		})
+		return
+	}
+
+	if fundTransfer.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Amount must be greater than zero",
+		})
+		return
+	}
+
+	accountName, recipientCode, err := resolveTransferRecipient(&fundTransfer)
+	if err == ErrBeneficiaryNotFound {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Bank beneficiary not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	feeQuote, err := fee.Calculate(fundTransfer.UserID, fee.ProductTransfer, fundTransfer.Amount)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to calculate fee: " + err.Error(),
+		})
+		return
+	}
+
+	quote := &TransferQuote{
+		QuoteID:       ledger.NewReference("QTE"),
+		UserID:        fundTransfer.UserID,
+		AccountNumber: fundTransfer.AccountNumber,
+		BankCode:      fundTransfer.BankCode,
+		AccountName:   accountName,
+		Amount:        fundTransfer.Amount,
+		Fee:           feeQuote.Fee,
+		Total:         feeQuote.Total,
+		Reason:        fundTransfer.Reason,
+		ExpiresAt:     time.Now().Add(quoteTTL()),
+		recipientCode: recipientCode,
+		source:        fundTransfer.Source,
+		feeQuote:      feeQuote,
+	}
+
+	if err := saveTransferQuote(quote); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to save quote: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Transfer quote created, confirm it before it expires",
+		Result:  quote,
+	})
+}
+
+// ConfirmTransferHandler executes the transfer described by a valid, unexpired quote.
+// A quote can only be confirmed once.
+func ConfirmTransferHandler(c *gin.Context) {
+	var request ConfirmTransferRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON input: " + err.Error(),
+		})
+		return
+	}
+
+	quote, err := claimTransferQuote(request.QuoteID, request.UserID)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		switch err {
+		case errQuoteNotFound:
+			statusCode = http.StatusNotFound
+		case errQuoteExpired:
+			statusCode = http.StatusGone
+		case errQuoteUsed:
+			statusCode = http.StatusConflict
+		}
+		c.JSON(statusCode, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	fundTransfer := FundTransfer{
+		AccountNumber: quote.AccountNumber,
+		BankCode:      quote.BankCode,
+		UserID:        quote.UserID,
+		Source:        quote.source,
+		Reason:        quote.Reason,
+		Amount:        quote.Amount,
+	}
+
+	result, err := sendTransfer(fundTransfer, quote.AccountName, quote.recipientCode, quote.feeQuote)
+	if err != nil {
+		finishTransferQuote(quote.QuoteID, QuoteFailed, "")
+		if respondLimitError(c, err) {
+			return
+		}
+		statusCode := http.StatusInternalServerError
+		message := err.Error()
+		if err == ledger.ErrInsufficientBalance {
+			statusCode = http.StatusBadRequest
+			message = "Insufficient balance."
+		}
+		c.JSON(statusCode, Response{
+			Status:  "error",
+			Message: message,
+		})
+		return
+	}
+
+	finishTransferQuote(quote.QuoteID, QuoteCompleted, result.Reference)
+
+	respondTransferResult(c, result)
+}
+
+var (
+	errQuoteNotFound = fmt.Errorf("Quote not found")
+	errQuoteExpired  = fmt.Errorf("Quote has expired, request a new one")
+	errQuoteUsed     = fmt.Errorf("Quote has already been used")
+)
+
+// quoteTTL returns how long a transfer quote stays valid
+func quoteTTL() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("TRANSFER_QUOTE_TTL_SECONDS"))
+	if err != nil || seconds <= 0 {
+		return defaultQuoteTTL
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// saveTransferQuote stores a new open quote
+func saveTransferQuote(quote *TransferQuote) error {
+	feeQuote, err := json.Marshal(quote.feeQuote)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	_, err = db.Exec(context.Background(), `
+		INSERT INTO transfer_quote (quote_id, user_id, account_number, bank_code, account_name, recipient_code,
+			amount, fee, total, reason, source, fee_quote, status, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	`, quote.QuoteID, quote.UserID, quote.AccountNumber, quote.BankCode, quote.AccountName, quote.recipientCode,
+		quote.Amount, quote.Fee, quote.Total, quote.Reason, quote.source, feeQuote, QuoteOpen, quote.ExpiresAt)
+	return err
+}
+
+// claimTransferQuote marks an open, unexpired quote as confirmed and returns it.
+// Concurrent confirmations of the same quote cannot both succeed.
+func claimTransferQuote(quoteID string, userID int) (*TransferQuote, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	var quote TransferQuote
+	var feeQuote []byte
+	err = db.QueryRow(context.Background(), `
+		UPDATE transfer_quote SET status = $1, confirmed_at = NOW()
+		WHERE quote_id = $2 AND user_id = $3 AND status = $4 AND expires_at > NOW()
+		RETURNING quote_id, user_id, account_number, bank_code, account_name, recipient_code,
+			amount, fee, total, COALESCE(reason, ''), COALESCE(source, ''), fee_quote, expires_at
+	`, QuoteConfirmed, quoteID, userID, QuoteOpen).Scan(&quote.QuoteID, &quote.UserID, &quote.AccountNumber, &quote.BankCode,
+		&quote.AccountName, &quote.recipientCode, &quote.Amount, &quote.Fee, &quote.Total, &quote.Reason, &quote.source,
+		&feeQuote, &quote.ExpiresAt)
+	if err == pgx.ErrNoRows {
+		// Work out why the quote could not be claimed
+		var status string
+		var expiresAt time.Time
+		err = db.QueryRow(context.Background(),
+			"SELECT status, expires_at FROM transfer_quote WHERE quote_id = $1 AND user_id = $2", quoteID, userID).Scan(&status, &expiresAt)
+		if err == pgx.ErrNoRows {
+			return nil, errQuoteNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if status != QuoteOpen {
+			return nil, errQuoteUsed
+		}
+		return nil, errQuoteExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(feeQuote, &quote.feeQuote); err != nil {
+		return nil, fmt.Errorf("failed to read quoted fee: %w", err)
+	}
+
+	return &quote, nil
+}
+
+// finishTransferQuote records the outcome of a confirmed quote
+func finishTransferQuote(quoteID, status, reference string) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		fmt.Println("Failed to connect to database:", err)
+		return
+	}
+	defer db.Close(context.Background())
+
+	_, err = db.Exec(context.Background(),
+		"UPDATE transfer_quote SET status = $1, reference = NULLIF($2, '') WHERE quote_id = $3",
+		status, reference, quoteID)
+	if err != nil {
+		fmt.Println("Failed to update quote:", err)
+	}
+}
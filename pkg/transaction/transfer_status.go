@@ -0,0 +1,251 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/reversal"
+)
+
+// Single transfer statuses returned to the caller
+const (
+	// TransferStatusSubmitted is a transfer Paystack has accepted
+	TransferStatusSubmitted = "submitted"
+	// TransferStatusPending is a transfer whose submission outcome is unknown until it is requeried
+	TransferStatusPending = "pending"
+)
+
+// transferRequeryDelay gives Paystack time to record a transfer before a missing one is treated as never sent
+const transferRequeryDelay = 30 * time.Minute
+
+// transferRequeryWindow is how long transfers with an unknown outcome are requeried before being left to reconciliation
+const transferRequeryWindow = "1 day"
+
+// errTransferNotFound is returned when Paystack has no transfer with the reference
+var errTransferNotFound = errors.New("transfer not found")
+
+// transferRejectedError is returned when Paystack explicitly refuses a transfer, so it was certainly not sent
+type transferRejectedError struct {
+	message string
+}
+
+func (e *transferRejectedError) Error() string {
+	return "Failed to initiate transfer: " + e.message
+}
+
+// SettleTransfer records the status Paystack reports for a single transfer. Failed and reversed transfers
+// are refunded to the wallet exactly once and others keep their transfer code. References that do not
+// belong to a single transfer are ignored.
+func SettleTransfer(reference, transferCode, status, reason string) error {
+	transactionID, err := findTransferDebit(reference)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch transfer: %w", err)
+	}
+
+	switch status {
+	case "failed", "reversed", "rejected", "abandoned":
+		_, err = reversal.Reverse(transactionID, reason, reversal.InitiatedBySystem)
+		if errors.Is(err, reversal.ErrAlreadyReversed) {
+			return nil
+		}
+		return err
+	}
+
+	// Transfers Paystack holds are settled by a later webhook once their transfer code is known
+	if transferCode == "" {
+		return nil
+	}
+	return saveTransferCode(transactionID, transferCode)
+}
+
+// StartTransferRequery checks single and bulk transfers whose submission outcome is unknown every interval in a background goroutine
+func StartTransferRequery(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := requeryPendingTransfers(); err != nil {
+				log.Printf("Transfer requery failed: %v\n", err)
+			}
+		}
+	}()
+}
+
+// requeryPendingTransfers verifies transfers that were debited but never confirmed by Paystack.
+// Transfers Paystack accepted get their transfer code and are left to the webhook; those it failed
+// or never received are refunded.
+func requeryPendingTransfers() error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		SELECT t.transaction_id, t.reference, t.created_at
+		FROM user_transaction t
+		WHERE t.transaction_type = 'debit' AND t.reference LIKE 'TRF-%' AND t.transfer_code IS NULL
+			AND t.created_at > NOW() - INTERVAL '`+transferRequeryWindow+`'
+			AND NOT EXISTS (SELECT 1 FROM fee_revenue f WHERE f.transaction_id = t.transaction_id)
+			AND NOT EXISTS (SELECT 1 FROM transaction_reversal r WHERE r.original_transaction_id = t.transaction_id)
+		ORDER BY t.created_at
+		LIMIT 50
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to fetch pending transfers: %w", err)
+	}
+
+	type pendingTransfer struct {
+		transactionID int64
+		reference     string
+		createdAt     time.Time
+	}
+	var pending []pendingTransfer
+	for rows.Next() {
+		var transfer pendingTransfer
+		if err := rows.Scan(&transfer.transactionID, &transfer.reference, &transfer.createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, transfer)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, transfer := range pending {
+		verified, err := fetchTransfer(transfer.reference)
+		if err == errTransferNotFound {
+			if time.Since(transfer.createdAt) < transferRequeryDelay {
+				continue
+			}
+			err = SettleTransfer(transfer.reference, "", "failed", "Transfer was not received by Paystack")
+		} else if err == nil {
+			err = SettleTransfer(transfer.reference, verified.Data.TransferCode, verified.Data.Status, "Paystack reported transfer "+verified.Data.Status)
+		}
+		if err != nil {
+			log.Printf("Failed to requery transfer %s: %v\n", transfer.reference, err)
+		}
+	}
+
+	// Bulk transfer items whose submission outcome is unknown are settled the same way
+	return requeryPendingBulkTransfers()
+}
+
+// requeryPendingBulkTransfers verifies pending bulk transfer items Paystack never returned a transfer code for.
+// Items Paystack accepted get their transfer code and are left to the webhook; those it failed or never
+// received are refunded.
+func requeryPendingBulkTransfers() error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		SELECT reference, created_at
+		FROM bulk_transfer_item
+		WHERE status = $1 AND transfer_code IS NULL AND created_at > NOW() - INTERVAL '`+transferRequeryWindow+`'
+		ORDER BY created_at
+		LIMIT 50
+	`, BulkItemPending)
+	if err != nil {
+		return fmt.Errorf("failed to fetch pending bulk transfer items: %w", err)
+	}
+
+	type pendingItem struct {
+		reference string
+		createdAt time.Time
+	}
+	var pending []pendingItem
+	for rows.Next() {
+		var item pendingItem
+		if err := rows.Scan(&item.reference, &item.createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, item := range pending {
+		verified, err := fetchTransfer(item.reference)
+		if err == errTransferNotFound {
+			if time.Since(item.createdAt) < transferRequeryDelay {
+				continue
+			}
+			err = UpdateBulkTransferItem(item.reference, BulkItemFailed, "Transfer was not received by Paystack")
+		} else if err == nil {
+			err = settleBulkTransferItem(db, item.reference, verified.Data.TransferCode, verified.Data.Status)
+		}
+		if err != nil {
+			log.Printf("Failed to requery bulk transfer item %s: %v\n", item.reference, err)
+		}
+	}
+
+	return nil
+}
+
+// settleBulkTransferItem records the status Paystack reports for a bulk transfer item. Final statuses
+// settle the item; others keep its transfer code so the webhook can settle it.
+func settleBulkTransferItem(db *pgx.Conn, reference, transferCode, status string) error {
+	switch status {
+	case "success":
+		return UpdateBulkTransferItem(reference, BulkItemSuccess, "")
+	case "failed", "rejected", "abandoned":
+		return UpdateBulkTransferItem(reference, BulkItemFailed, "Paystack reported transfer "+status)
+	case "reversed":
+		return UpdateBulkTransferItem(reference, BulkItemReversed, "Paystack reported transfer reversed")
+	}
+
+	if transferCode == "" {
+		return nil
+	}
+	_, err := db.Exec(context.Background(),
+		"UPDATE bulk_transfer_item SET transfer_code = $1, updated_at = NOW() WHERE reference = $2 AND transfer_code IS NULL",
+		transferCode, reference)
+	return err
+}
+
+// findTransferDebit returns the wallet debit of a single transfer, leaving out the fee posted under the same reference
+func findTransferDebit(reference string) (int64, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close(context.Background())
+
+	var transactionID int64
+	err = db.QueryRow(context.Background(), `
+		SELECT t.transaction_id FROM user_transaction t
+		WHERE t.reference = $1 AND t.reference LIKE 'TRF-%' AND t.transaction_type = 'debit'
+			AND NOT EXISTS (SELECT 1 FROM fee_revenue f WHERE f.transaction_id = t.transaction_id)
+	`, reference).Scan(&transactionID)
+	return transactionID, err
+}
+
+// saveTransferCode stores the Paystack transfer code of a transfer submitted without a confirmed response
+func saveTransferCode(transactionID int64, transferCode string) error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	_, err = db.Exec(context.Background(),
+		"UPDATE user_transaction SET transfer_code = $1 WHERE transaction_id = $2 AND transfer_code IS NULL",
+		transferCode, transactionID)
+	return err
+}
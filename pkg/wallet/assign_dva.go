@@ -0,0 +1,118 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"go_code/database"
+)
+
+// Identity represents the verified identity a dedicated account is assigned with
+type Identity struct {
+	UserID     int64
+	FirstName  string
+	MiddleName string
+	LastName   string
+	Phone      string
+	BVN        string
+}
+
+// AssignDVAResponse represents the response payload of a Paystack dedicated account assignment
+type AssignDVAResponse struct {
+	Status  bool   `json:"status"`
+	Message string `json:"message"`
+}
+
+// HasWallet reports whether the user already has a wallet or a dedicated account being assigned
+func HasWallet(userID int64) (bool, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return false, err
+	}
+	defer db.Close(context.Background())
+
+	var count int
+	err = db.QueryRow(context.Background(),
+		`SELECT (SELECT COUNT(*) FROM wallet WHERE user_id = $1 AND deleted = false)
+			+ (SELECT COUNT(*) FROM wallet_dva WHERE user_id = $1 AND status <> 'failed')`, userID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// AssignDVAWithIdentity asks Paystack to create a customer and assign them a dedicated account
+// using identity data verified through KYC. Paystack validates the customer before assigning the account,
+// so the account is recorded as pending and completed by the dedicatedaccount.assign webhooks.
+func AssignDVAWithIdentity(identity Identity) (*AssignDVAResponse, error) {
+	user, err := fetchUserFromDatabase(identity.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user information: %w", err)
+	}
+
+	phone := identity.Phone
+	if phone == "" {
+		phone = user.Phone
+	}
+
+	data := map[string]interface{}{
+		"email":          user.Email,
+		"first_name":     identity.FirstName,
+		"middle_name":    identity.MiddleName,
+		"last_name":      identity.LastName,
+		"phone":          phone,
+		"preferred_bank": defaultPreferredBank(),
+		"country":        "NG",
+	}
+	if identity.BVN != "" {
+		data["bvn"] = identity.BVN
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", "https://api.paystack.co/dedicated_account/assign", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("PAYSTACK_SECRET_KEY"))
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error assigning dedicated account: %s", body)
+	}
+
+	var result AssignDVAResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	if !result.Status {
+		return nil, fmt.Errorf("Error assigning dedicated account: %s", result.Message)
+	}
+
+	if _, err := createPendingDVA(0, identity.UserID, "", defaultPreferredBank(), true); err != nil {
+		return nil, fmt.Errorf("failed to record pending dedicated account: %w", err)
+	}
+
+	return &result, nil
+}
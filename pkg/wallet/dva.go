@@ -0,0 +1,344 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+)
+
+// Dedicated account statuses
+const (
+	DVAStatusPending  = "pending"
+	DVAStatusActive   = "active"
+	DVAStatusFailed   = "failed"
+	DVAStatusInactive = "inactive"
+)
+
+// DVAProvider represents a bank Paystack can issue dedicated accounts on
+type DVAProvider struct {
+	ID           int    `json:"id"`
+	ProviderSlug string `json:"provider_slug"`
+	BankID       int    `json:"bank_id"`
+	BankName     string `json:"bank_name"`
+}
+
+// DVAProvidersResponse represents the response payload of Paystack's available dedicated account providers
+type DVAProvidersResponse struct {
+	Status  bool          `json:"status"`
+	Message string        `json:"message"`
+	Data    []DVAProvider `json:"data"`
+}
+
+// DedicatedAccount represents a dedicated virtual account attached to a wallet
+type DedicatedAccount struct {
+	WalletDVAID   int64     `json:"wallet_dva_id"`
+	DVAID         int64     `json:"dva_id"`
+	WalletID      int64     `json:"wallet_id"`
+	UserID        int64     `json:"user_id"`
+	CustomerCode  string    `json:"customer_code"`
+	ProviderSlug  string    `json:"provider_slug"`
+	BankName      string    `json:"bank_name"`
+	BankID        int       `json:"bank_id"`
+	AccountName   string    `json:"account_name"`
+	AccountNumber string    `json:"account_number"`
+	Status        string    `json:"status"`
+	Primary       bool      `json:"primary"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	Subaccount    string    `json:"subaccount,omitempty"`
+	SplitCode     string    `json:"split_code,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+const dedicatedAccountColumns = `wallet_dva_id, COALESCE(dva_id, 0), COALESCE(wallet_id, 0), user_id, COALESCE(customer_code, ''),
+	provider_slug, COALESCE(bank_name, ''), COALESCE(bank_id, 0), COALESCE(account_name, ''), COALESCE(account_number, ''),
+	status, is_primary, attempts, COALESCE(last_error, ''), COALESCE(split_subaccount, ''), COALESCE(split_code, ''), created_at`
+
+// RequestDVARequest represents the request payload for an additional dedicated account
+type RequestDVARequest struct {
+	PreferredBank string `json:"preferred_bank"` // provider_slug from /wallet/dva/providers
+}
+
+// walletOwner holds the wallet a new dedicated account is attached to
+type walletOwner struct {
+	walletID     int64
+	customerCode string
+}
+
+// DVAProvidersHandler returns the banks dedicated accounts can be requested on
+func DVAProvidersHandler(c *gin.Context) {
+	providers, err := fetchDVAProviders()
+	if err != nil {
+		c.JSON(http.StatusBadGateway, Response{
+			Status:  "error",
+			Message: "Failed to fetch dedicated account providers: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Dedicated account providers retrieved successfully",
+		Result:  providers,
+	})
+}
+
+// DedicatedAccountsHandler returns every dedicated account attached to the user's wallet
+func DedicatedAccountsHandler(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user_id parameter",
+		})
+		return
+	}
+
+	accounts, err := FetchDedicatedAccounts(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch dedicated accounts: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Dedicated accounts retrieved successfully",
+		Result:  accounts,
+	})
+}
+
+// RequestDVAHandler attaches a dedicated account on another bank to the user's existing wallet
+func RequestDVAHandler(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user_id parameter",
+		})
+		return
+	}
+
+	var request RequestDVARequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Malformed JSON request: " + err.Error(),
+		})
+		return
+	}
+
+	owner, err := fetchWalletOwner(userID)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Create a wallet before requesting another dedicated account",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch wallet: " + err.Error(),
+		})
+		return
+	}
+
+	preferredBank, err := validatePreferredBank(request.PreferredBank)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	accounts, err := FetchDedicatedAccounts(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch dedicated accounts: " + err.Error(),
+		})
+		return
+	}
+	for _, account := range accounts {
+		if account.ProviderSlug == preferredBank && account.Status != DVAStatusFailed {
+			c.JSON(http.StatusConflict, Response{
+				Status:  "error",
+				Message: "You already have a dedicated account on this bank",
+				Result:  account,
+			})
+			return
+		}
+	}
+
+	account, err := createPendingDVA(owner.walletID, userID, owner.customerCode, preferredBank, false)
+	if err == nil {
+		account, err = requestDVA(account)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to save dedicated account: " + err.Error(),
+		})
+		return
+	}
+
+	if account.Status != DVAStatusActive {
+		c.JSON(http.StatusAccepted, Response{
+			Status:  "success",
+			Message: dvaStatusMessage(account.Status),
+			Result:  account,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Dedicated account created successfully",
+		Result:  account,
+	})
+}
+
+// FetchDedicatedAccounts returns the dedicated accounts attached to the user's wallet, primary first.
+// Wallets created before accounts were tracked separately report their original account as primary.
+func FetchDedicatedAccounts(userID int64) ([]DedicatedAccount, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		SELECT `+dedicatedAccountColumns+`
+		FROM wallet_dva
+		WHERE user_id = $1
+		UNION ALL
+		SELECT 0, w.dva_id, w.wallet_id, w.user_id, w.customer_code, w.bank_slug, w.bank_name, w.bank_id, w.account_name,
+			w.account_number, 'active', true, 0, '', '', '', NOW()
+		FROM wallet w
+		WHERE w.user_id = $1 AND w.deleted = false AND COALESCE(w.account_number, '') <> ''
+			AND NOT EXISTS (SELECT 1 FROM wallet_dva d WHERE d.dva_id = w.dva_id)
+		ORDER BY is_primary DESC, created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []DedicatedAccount{}
+	for rows.Next() {
+		account, err := scanDedicatedAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *account)
+	}
+	return accounts, rows.Err()
+}
+
+// fetchWalletOwner returns the user's wallet and Paystack customer code
+func fetchWalletOwner(userID int64) (*walletOwner, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	var owner walletOwner
+	err = db.QueryRow(context.Background(),
+		"SELECT wallet_id, customer_code FROM wallet WHERE user_id = $1 AND deleted = false ORDER BY wallet_id LIMIT 1", userID).
+		Scan(&owner.walletID, &owner.customerCode)
+	if err != nil {
+		return nil, err
+	}
+	return &owner, nil
+}
+
+// validatePreferredBank checks a requested bank against Paystack's providers, defaulting to PAYSTACK_PREFERRED_BANK
+func validatePreferredBank(preferredBank string) (string, error) {
+	preferredBank = strings.TrimSpace(preferredBank)
+	if preferredBank == "" {
+		return defaultPreferredBank(), nil
+	}
+
+	providers, err := fetchDVAProviders()
+	if err != nil {
+		return "", fmt.Errorf("Failed to fetch dedicated account providers: %w", err)
+	}
+
+	slugs := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		if provider.ProviderSlug == preferredBank {
+			return preferredBank, nil
+		}
+		slugs = append(slugs, provider.ProviderSlug)
+	}
+	return "", fmt.Errorf("preferred_bank must be one of %s", strings.Join(slugs, ", "))
+}
+
+// defaultPreferredBank returns the bank new wallets get their first dedicated account on
+func defaultPreferredBank() string {
+	if bank := os.Getenv("PAYSTACK_PREFERRED_BANK"); bank != "" {
+		return bank
+	}
+	return "wema-bank"
+}
+
+// fetchDVAProviders fetches the banks Paystack can currently issue dedicated accounts on
+func fetchDVAProviders() ([]DVAProvider, error) {
+	req, err := http.NewRequest("GET", "https://api.paystack.co/dedicated_account/available_providers", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("PAYSTACK_SECRET_KEY"))
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error fetching providers: %s", body)
+	}
+
+	var result DVAProvidersResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	if !result.Status {
+		return nil, fmt.Errorf("Error fetching providers: %s", result.Message)
+	}
+
+	return result.Data, nil
+}
+
+func scanDedicatedAccount(row pgx.Row) (*DedicatedAccount, error) {
+	var account DedicatedAccount
+	err := row.Scan(&account.WalletDVAID, &account.DVAID, &account.WalletID, &account.UserID, &account.CustomerCode,
+		&account.ProviderSlug, &account.BankName, &account.BankID, &account.AccountName, &account.AccountNumber, &account.Status,
+		&account.Primary, &account.Attempts, &account.LastError, &account.Subaccount, &account.SplitCode, &account.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
@@ -0,0 +1,418 @@
+package wallet
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/notification"
+)
+
+// maxDVAAttempts is the number of failed assignments after which a dedicated account is no longer retried
+const maxDVAAttempts = 5
+
+// dvaRetryBackoff is multiplied by the attempt number to space out retries of a failed assignment
+const dvaRetryBackoff = 10 * time.Minute
+
+// Wallet statuses reported to the client
+const (
+	WalletStatusNone     = "none"
+	WalletStatusPending  = "pending"
+	WalletStatusActive   = "active"
+	WalletStatusFailed   = "failed"
+	WalletStatusInactive = "inactive"
+)
+
+// DedicatedAccountEvent represents a Paystack dedicatedaccount.assign.success or dedicatedaccount.assign.failed event
+type DedicatedAccountEvent struct {
+	Event string `json:"event"`
+	Data  struct {
+		Customer struct {
+			CustomerCode string `json:"customer_code"`
+			Email        string `json:"email"`
+		} `json:"customer"`
+		DedicatedAccount *struct {
+			Bank struct {
+				Name string `json:"name"`
+				ID   int    `json:"id"`
+				Slug string `json:"slug"`
+			} `json:"bank"`
+			AccountName   string `json:"account_name"`
+			AccountNumber string `json:"account_number"`
+			ID            int64  `json:"id"`
+		} `json:"dedicated_account"`
+		Identification struct {
+			Status string `json:"status"`
+		} `json:"identification"`
+	} `json:"data"`
+}
+
+// WalletStatus represents the state of a user's wallet and its dedicated accounts
+type WalletStatus struct {
+	UserID            int64              `json:"user_id"`
+	Status            string             `json:"status"`
+	Message           string             `json:"message"`
+	DedicatedAccounts []DedicatedAccount `json:"dedicated_accounts"`
+}
+
+// dvaDetails are the account details of an assigned dedicated account
+type dvaDetails struct {
+	dvaID         int64
+	customerCode  string
+	bankName      string
+	bankID        int
+	bankSlug      string
+	accountName   string
+	accountNumber string
+}
+
+// WalletStatusHandler returns whether the user's wallet is ready to receive transfers
+func WalletStatusHandler(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid user_id parameter",
+		})
+		return
+	}
+
+	accounts, err := FetchDedicatedAccounts(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch wallet status: " + err.Error(),
+		})
+		return
+	}
+
+	status := WalletStatus{UserID: userID, Status: WalletStatusNone, DedicatedAccounts: accounts}
+	for _, account := range accounts {
+		switch {
+		case account.Status == DVAStatusActive:
+			status.Status = WalletStatusActive
+		case account.Status == DVAStatusPending && status.Status != WalletStatusActive:
+			status.Status = WalletStatusPending
+		case account.Status == DVAStatusFailed && (status.Status == WalletStatusNone || status.Status == WalletStatusInactive):
+			status.Status = WalletStatusFailed
+		case account.Status == DVAStatusInactive && status.Status == WalletStatusNone:
+			status.Status = WalletStatusInactive
+		}
+	}
+	status.Message = dvaStatusMessage(status.Status)
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Wallet status retrieved successfully",
+		Result:  status,
+	})
+}
+
+// StartDVARetry retries failed dedicated account assignments that are due every interval in a background goroutine
+func StartDVARetry(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := retryFailedDVAs(); err != nil {
+				log.Printf("DVA retry failed: %v\n", err)
+			}
+		}
+	}()
+}
+
+// CompleteDVAAssignment activates the pending dedicated account Paystack has assigned, creating the wallet
+// for accounts that were requested before the user had one
+func CompleteDVAAssignment(event DedicatedAccountEvent) error {
+	if event.Data.DedicatedAccount == nil {
+		return fmt.Errorf("assignment event has no dedicated account")
+	}
+	assigned := event.Data.DedicatedAccount
+	details := dvaDetails{
+		dvaID:         assigned.ID,
+		customerCode:  event.Data.Customer.CustomerCode,
+		bankName:      assigned.Bank.Name,
+		bankID:        assigned.Bank.ID,
+		bankSlug:      assigned.Bank.Slug,
+		accountName:   assigned.AccountName,
+		accountNumber: assigned.AccountNumber,
+	}
+
+	account, err := findPendingDVA(event.Data.Customer.CustomerCode, event.Data.Customer.Email, assigned.Bank.Slug, assigned.ID)
+	if err == pgx.ErrNoRows {
+		log.Printf("No pending dedicated account for customer %s, ignoring assignment of %s\n",
+			event.Data.Customer.CustomerCode, assigned.AccountNumber)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if account.Status == DVAStatusActive {
+		// Paystack retries webhooks, so the account may already be active
+		return nil
+	}
+
+	_, err = activateDVA(account, details)
+	return err
+}
+
+// FailDVAAssignment records that Paystack could not assign a pending dedicated account and schedules a retry
+func FailDVAAssignment(event DedicatedAccountEvent) error {
+	account, err := findPendingDVA(event.Data.Customer.CustomerCode, event.Data.Customer.Email, "", 0)
+	if err == pgx.ErrNoRows {
+		log.Printf("No pending dedicated account for customer %s, ignoring failed assignment\n", event.Data.Customer.CustomerCode)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if account.Status != DVAStatusPending {
+		return nil
+	}
+
+	reason := "Paystack could not assign the dedicated account"
+	if event.Data.Identification.Status != "" {
+		reason += ", customer identification " + event.Data.Identification.Status
+	}
+	_, err = failDVA(account.WalletDVAID, reason)
+	return err
+}
+
+// createPendingDVA records a dedicated account that is about to be requested from Paystack
+func createPendingDVA(walletID, userID int64, customerCode, providerSlug string, primary bool) (*DedicatedAccount, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	row := db.QueryRow(context.Background(), `
+		INSERT INTO wallet_dva (wallet_id, user_id, customer_code, provider_slug, status, is_primary, attempts, created_at)
+		VALUES (NULLIF($1, 0), $2, NULLIF($3, ''), $4, $5, $6, 0, NOW())
+		RETURNING `+dedicatedAccountColumns, walletID, userID, customerCode, providerSlug, DVAStatusPending, primary)
+	return scanDedicatedAccount(row)
+}
+
+// requestDVA asks Paystack for a pending dedicated account. The account is activated when Paystack assigns
+// it straight away, stays pending when the assignment completes through a webhook, and is failed for a retry
+// when Paystack rejects the request.
+func requestDVA(account *DedicatedAccount) (*DedicatedAccount, error) {
+	dvaData, err := createDVAWithPaystack(account.CustomerCode, account.ProviderSlug)
+	if err != nil {
+		return failDVA(account.WalletDVAID, err.Error())
+	}
+	if dvaData.Data.AccountNumber == "" {
+		return account, nil
+	}
+
+	return activateDVA(account, dvaDetails{
+		dvaID:         dvaData.Data.DVAid,
+		customerCode:  dvaData.Data.Customer.CustomerCode,
+		bankName:      dvaData.Data.Bank.Name,
+		bankID:        dvaData.Data.Bank.ID,
+		bankSlug:      dvaData.Data.Bank.Slug,
+		accountName:   dvaData.Data.AccountName,
+		accountNumber: dvaData.Data.AccountNumber,
+	})
+}
+
+// activateDVA saves the assigned account details. The wallet is created if the account was requested
+// without one, and a primary account's details are copied onto the wallet. The account row is locked so
+// an assignment webhook racing the Paystack response activates it, and creates the wallet, only once.
+func activateDVA(account *DedicatedAccount, details dvaDetails) (*DedicatedAccount, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	locked, err := scanDedicatedAccount(tx.QueryRow(context.Background(),
+		"SELECT "+dedicatedAccountColumns+" FROM wallet_dva WHERE wallet_dva_id = $1 FOR UPDATE", account.WalletDVAID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock dedicated account: %w", err)
+	}
+	if locked.Status == DVAStatusActive {
+		return locked, nil
+	}
+
+	walletID := locked.WalletID
+	if walletID == 0 {
+		walletID, err = lockOrCreateWallet(tx, locked.UserID, details.customerCode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create wallet: %w", err)
+		}
+	}
+
+	row := tx.QueryRow(context.Background(), `
+		UPDATE wallet_dva
+		SET status = $2, wallet_id = $3, dva_id = $4, customer_code = COALESCE(NULLIF($5, ''), customer_code),
+			provider_slug = COALESCE(NULLIF($6, ''), provider_slug), bank_name = $7, bank_id = $8, account_name = $9,
+			account_number = $10, last_error = NULL, next_retry_at = NULL, updated_at = NOW()
+		WHERE wallet_dva_id = $1
+		RETURNING `+dedicatedAccountColumns, account.WalletDVAID, DVAStatusActive, walletID, details.dvaID, details.customerCode,
+		details.bankSlug, details.bankName, details.bankID, details.accountName, details.accountNumber)
+	activated, err := scanDedicatedAccount(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to activate dedicated account: %w", err)
+	}
+
+	if activated.Primary {
+		_, err = tx.Exec(context.Background(), `
+			UPDATE wallet SET bank_name = $2, bank_id = $3, bank_slug = $4, account_name = $5, account_number = $6, dva_id = $7
+			WHERE wallet_id = $1
+		`, walletID, details.bankName, details.bankID, activated.ProviderSlug, details.accountName, details.accountNumber, details.dvaID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update wallet: %w", err)
+		}
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return nil, err
+	}
+	return activated, nil
+}
+
+// lockOrCreateWallet returns the user's wallet, creating it inside tx when there is none. The user row is
+// locked first so concurrent assignments for different banks do not both create a wallet.
+func lockOrCreateWallet(tx pgx.Tx, userID int64, customerCode string) (int64, error) {
+	_, err := tx.Exec(context.Background(), "SELECT user_id FROM users WHERE user_id = $1 FOR UPDATE", userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var walletID int64
+	err = tx.QueryRow(context.Background(),
+		"SELECT wallet_id FROM wallet WHERE user_id = $1 AND deleted = false ORDER BY wallet_id LIMIT 1", userID).Scan(&walletID)
+	if err != pgx.ErrNoRows {
+		return walletID, err
+	}
+
+	err = tx.QueryRow(context.Background(), `
+		INSERT INTO wallet (user_id, customer_code, bank_name, bank_id, bank_slug, account_name, account_number, dva_id)
+		VALUES ($1, $2, '', 0, '', '', '', 0)
+		RETURNING wallet_id
+	`, userID, customerCode).Scan(&walletID)
+	return walletID, err
+}
+
+// failDVA records a failed assignment and schedules a retry with a growing backoff. Accounts without a
+// Paystack customer cannot be retried, and the admin is alerted once the retries run out.
+func failDVA(walletDVAID int64, reason string) (*DedicatedAccount, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	row := db.QueryRow(context.Background(), `
+		UPDATE wallet_dva
+		SET status = $2, attempts = attempts + 1, last_error = $3, updated_at = NOW(),
+			next_retry_at = CASE WHEN customer_code IS NOT NULL AND attempts + 1 < $4
+				THEN NOW() + (attempts + 1) * $5 * INTERVAL '1 second' END
+		WHERE wallet_dva_id = $1
+		RETURNING `+dedicatedAccountColumns, walletDVAID, DVAStatusFailed, reason, maxDVAAttempts, dvaRetryBackoff.Seconds())
+	account, err := scanDedicatedAccount(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record failed assignment: %w", err)
+	}
+
+	if account.Attempts >= maxDVAAttempts {
+		body := fmt.Sprintf("Dedicated account %d on %s for user %d could not be assigned after %d attempts.\nLast error: %s",
+			account.WalletDVAID, account.ProviderSlug, account.UserID, account.Attempts, reason)
+		if err := notification.SendEmail(os.Getenv("ADMIN_EMAIL"), "Dedicated account assignment failed", body); err != nil {
+			fmt.Println("Failed to send email:", err)
+		}
+	}
+	return account, nil
+}
+
+// retryFailedDVAs claims failed assignments that are due a retry and requests them again
+func retryFailedDVAs() error {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	rows, err := db.Query(context.Background(), `
+		UPDATE wallet_dva SET status = 'pending', next_retry_at = NULL, updated_at = NOW()
+		WHERE wallet_dva_id IN (
+			SELECT wallet_dva_id FROM wallet_dva
+			WHERE status = 'failed' AND next_retry_at <= NOW()
+			ORDER BY next_retry_at
+			LIMIT 20
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+dedicatedAccountColumns)
+	if err != nil {
+		return fmt.Errorf("failed to claim failed assignments: %w", err)
+	}
+
+	var due []*DedicatedAccount
+	for rows.Next() {
+		account, err := scanDedicatedAccount(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, account)
+	}
+	rows.Close()
+
+	for _, account := range due {
+		if _, err := requestDVA(account); err != nil {
+			log.Printf("Failed to retry dedicated account %d: %v\n", account.WalletDVAID, err)
+		}
+	}
+	return nil
+}
+
+// findPendingDVA finds the dedicated account an assignment event is for: the customer's account already
+// holding the assigned dva_id, or else their oldest pending or failed account, preferring the assigned bank.
+// Accounts requested before the customer existed are matched by email.
+func findPendingDVA(customerCode, email, bankSlug string, dvaID int64) (*DedicatedAccount, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	row := db.QueryRow(context.Background(), `
+		SELECT `+dedicatedAccountColumns+`
+		FROM wallet_dva
+		WHERE (customer_code = $1
+				OR (customer_code IS NULL AND user_id = (SELECT user_id FROM users WHERE LOWER(email) = LOWER($2) AND deleted = false LIMIT 1)))
+			AND (status IN ('pending', 'failed') OR ($4 <> 0 AND dva_id = $4))
+		ORDER BY ($4 <> 0 AND dva_id = $4) DESC, (provider_slug = $3) DESC, status = 'pending' DESC, created_at
+		LIMIT 1
+	`, customerCode, email, bankSlug, dvaID)
+	return scanDedicatedAccount(row)
+}
+
+// dvaStatusMessage describes a dedicated account or wallet status to the client
+func dvaStatusMessage(status string) string {
+	switch status {
+	case DVAStatusActive:
+		return "Your wallet is ready to receive transfers"
+	case DVAStatusPending:
+		return "Your dedicated account is being assigned, we will notify you when it is ready"
+	case DVAStatusFailed:
+		return "Your dedicated account could not be assigned yet, we will retry shortly"
+	case DVAStatusInactive:
+		return "Your dedicated account has been deactivated, reactivate it to receive transfers"
+	}
+	return "You do not have a wallet yet"
+}
@@ -0,0 +1,421 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go_code/database"
+	"go_code/pkg/auth"
+)
+
+// SplitDVARequest represents the request payload for splitting a dedicated account's payments with a subaccount
+type SplitDVARequest struct {
+	Subaccount string `json:"subaccount"` // ACCT_ code of a Paystack subaccount
+	SplitCode  string `json:"split_code"` // SPL_ code of a Paystack multi-split
+}
+
+// paystackDVAResponse represents the response payload of the Paystack dedicated account management endpoints
+type paystackDVAResponse struct {
+	Status  bool   `json:"status"`
+	Message string `json:"message"`
+}
+
+// DeactivateDVAHandler deactivates a dedicated account on Paystack so it stops receiving transfers.
+// On /wallet/:user_id routes the account must belong to the user.
+func DeactivateDVAHandler(c *gin.Context) {
+	account, ok := managedDVAFromRequest(c)
+	if !ok {
+		return
+	}
+
+	if account.Status != DVAStatusActive {
+		c.JSON(http.StatusConflict, Response{
+			Status:  "error",
+			Message: "Only active dedicated accounts can be deactivated",
+			Result:  account,
+		})
+		return
+	}
+
+	if _, err := paystackDVARequest("DELETE", "/dedicated_account/"+strconv.FormatInt(account.DVAID, 10), nil); err != nil {
+		c.JSON(http.StatusBadGateway, Response{
+			Status:  "error",
+			Message: "Failed to deactivate dedicated account: " + err.Error(),
+		})
+		return
+	}
+
+	account, err := setDVAStatus(account.WalletDVAID, DVAStatusInactive)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Dedicated account was deactivated but could not be saved: " + err.Error(),
+		})
+		return
+	}
+	log.Printf("Dedicated account %s deactivated by %s\n", account.AccountNumber, dvaActor(c))
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Dedicated account deactivated successfully",
+		Result:  account,
+	})
+}
+
+// ReactivateDVAHandler requests a deactivated dedicated account again on the same bank. Paystack has no
+// reactivation endpoint, so the customer may be assigned a new account number.
+func ReactivateDVAHandler(c *gin.Context) {
+	account, ok := managedDVAFromRequest(c)
+	if !ok {
+		return
+	}
+
+	if account.Status != DVAStatusInactive {
+		c.JSON(http.StatusConflict, Response{
+			Status:  "error",
+			Message: "Only deactivated dedicated accounts can be reactivated",
+			Result:  account,
+		})
+		return
+	}
+
+	account, err := setDVAStatus(account.WalletDVAID, DVAStatusPending)
+	if err == nil {
+		account, err = requestDVA(account)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to reactivate dedicated account: " + err.Error(),
+		})
+		return
+	}
+	log.Printf("Dedicated account %d reactivation requested by %s\n", account.WalletDVAID, dvaActor(c))
+
+	if account.Status != DVAStatusActive {
+		c.JSON(http.StatusAccepted, Response{
+			Status:  "success",
+			Message: dvaStatusMessage(account.Status),
+			Result:  account,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Dedicated account reactivated successfully",
+		Result:  account,
+	})
+}
+
+// RequeryDVAHandler asks Paystack to check a dedicated account for transfers that were not notified.
+// Paystack sends the missed charge.success webhooks, which credit the wallet once per reference.
+// An optional date query parameter (YYYY-MM-DD) limits the check to that day.
+func RequeryDVAHandler(c *gin.Context) {
+	account, ok := managedDVAFromRequest(c)
+	if !ok {
+		return
+	}
+
+	query := url.Values{}
+	query.Set("account_number", account.AccountNumber)
+	query.Set("provider_slug", account.ProviderSlug)
+	if date := c.Query("date"); date != "" {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: "date must be in YYYY-MM-DD format",
+			})
+			return
+		}
+		query.Set("date", date)
+	}
+
+	result, err := paystackDVARequest("GET", "/dedicated_account/requery?"+query.Encode(), nil)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, Response{
+			Status:  "error",
+			Message: "Failed to requery dedicated account: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: result.Message,
+		Result:  account,
+	})
+}
+
+// SplitDVAHandler splits payments into a merchant's dedicated account with a Paystack subaccount or split
+func SplitDVAHandler(c *gin.Context) {
+	account, ok := managedDVAFromRequest(c)
+	if !ok {
+		return
+	}
+
+	var request SplitDVARequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Malformed JSON request: " + err.Error(),
+		})
+		return
+	}
+	request.Subaccount = strings.TrimSpace(request.Subaccount)
+	request.SplitCode = strings.TrimSpace(request.SplitCode)
+	if (request.Subaccount == "") == (request.SplitCode == "") {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Provide either subaccount or split_code",
+		})
+		return
+	}
+
+	if account.Status != DVAStatusActive {
+		c.JSON(http.StatusConflict, Response{
+			Status:  "error",
+			Message: "Only active dedicated accounts can be split",
+			Result:  account,
+		})
+		return
+	}
+
+	payload := map[string]interface{}{
+		"customer":       account.CustomerCode,
+		"preferred_bank": account.ProviderSlug,
+	}
+	if request.Subaccount != "" {
+		payload["subaccount"] = request.Subaccount
+	} else {
+		payload["split_code"] = request.SplitCode
+	}
+
+	if _, err := paystackDVARequest("POST", "/dedicated_account/split", payload); err != nil {
+		c.JSON(http.StatusBadGateway, Response{
+			Status:  "error",
+			Message: "Failed to split dedicated account: " + err.Error(),
+		})
+		return
+	}
+
+	account, err := saveDVASplit(account.WalletDVAID, request.Subaccount, request.SplitCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Dedicated account was split but could not be saved: " + err.Error(),
+		})
+		return
+	}
+	log.Printf("Dedicated account %s split by %s\n", account.AccountNumber, dvaActor(c))
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Dedicated account split successfully",
+		Result:  account,
+	})
+}
+
+// RemoveDVASplitHandler stops splitting payments into a dedicated account
+func RemoveDVASplitHandler(c *gin.Context) {
+	account, ok := managedDVAFromRequest(c)
+	if !ok {
+		return
+	}
+
+	if account.Subaccount == "" && account.SplitCode == "" {
+		c.JSON(http.StatusConflict, Response{
+			Status:  "error",
+			Message: "Dedicated account is not split",
+			Result:  account,
+		})
+		return
+	}
+
+	payload := map[string]interface{}{"account_number": account.AccountNumber}
+	if _, err := paystackDVARequest("DELETE", "/dedicated_account/split", payload); err != nil {
+		c.JSON(http.StatusBadGateway, Response{
+			Status:  "error",
+			Message: "Failed to remove dedicated account split: " + err.Error(),
+		})
+		return
+	}
+
+	account, err := saveDVASplit(account.WalletDVAID, "", "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Dedicated account split was removed but could not be saved: " + err.Error(),
+		})
+		return
+	}
+	log.Printf("Dedicated account %s split removed by %s\n", account.AccountNumber, dvaActor(c))
+
+	c.JSON(http.StatusOK, Response{
+		Status:  "success",
+		Message: "Dedicated account split removed successfully",
+		Result:  account,
+	})
+}
+
+// managedDVAFromRequest loads the dedicated account named by the dva_id parameter, checking it belongs to
+// the user_id parameter when the route has one. It writes the error response and returns false on failure.
+func managedDVAFromRequest(c *gin.Context) (*DedicatedAccount, bool) {
+	dvaID, err := strconv.ParseInt(c.Param("dva_id"), 10, 64)
+	if err != nil || dvaID <= 0 {
+		c.JSON(http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid dva_id parameter",
+		})
+		return nil, false
+	}
+
+	var userID int64
+	if param := c.Param("user_id"); param != "" {
+		userID, err = strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: "Invalid user_id parameter",
+			})
+			return nil, false
+		}
+	}
+
+	account, err := fetchManagedDVA(dvaID)
+	if err == pgx.ErrNoRows || (err == nil && userID != 0 && account.UserID != userID) {
+		c.JSON(http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Dedicated account not found",
+		})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: "Failed to fetch dedicated account: " + err.Error(),
+		})
+		return nil, false
+	}
+	return account, true
+}
+
+// fetchManagedDVA returns the dedicated account with the Paystack dva_id. Accounts saved on the wallet
+// before dedicated accounts were tracked separately are copied into wallet_dva so their status can be kept.
+func fetchManagedDVA(dvaID int64) (*DedicatedAccount, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	account, err := scanDedicatedAccount(db.QueryRow(context.Background(),
+		"SELECT "+dedicatedAccountColumns+" FROM wallet_dva WHERE dva_id = $1 ORDER BY wallet_dva_id DESC LIMIT 1", dvaID))
+	if err != pgx.ErrNoRows {
+		return account, err
+	}
+
+	return scanDedicatedAccount(db.QueryRow(context.Background(), `
+		INSERT INTO wallet_dva (wallet_id, user_id, customer_code, provider_slug, dva_id, bank_name, bank_id, account_name,
+			account_number, status, is_primary, attempts, created_at)
+		SELECT wallet_id, user_id, customer_code, bank_slug, dva_id, bank_name, bank_id, account_name,
+			account_number, $2, true, 0, NOW()
+		FROM wallet
+		WHERE dva_id = $1 AND deleted = false AND COALESCE(account_number, '') <> ''
+		LIMIT 1
+		RETURNING `+dedicatedAccountColumns, dvaID, DVAStatusActive))
+}
+
+// setDVAStatus updates the status of a dedicated account
+func setDVAStatus(walletDVAID int64, status string) (*DedicatedAccount, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	return scanDedicatedAccount(db.QueryRow(context.Background(), `
+		UPDATE wallet_dva SET status = $2, updated_at = NOW()
+		WHERE wallet_dva_id = $1
+		RETURNING `+dedicatedAccountColumns, walletDVAID, status))
+}
+
+// saveDVASplit records the subaccount or split a dedicated account's payments are shared with
+func saveDVASplit(walletDVAID int64, subaccount, splitCode string) (*DedicatedAccount, error) {
+	db, err := database.PostgreSQLConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(context.Background())
+
+	return scanDedicatedAccount(db.QueryRow(context.Background(), `
+		UPDATE wallet_dva SET split_subaccount = NULLIF($2, ''), split_code = NULLIF($3, ''), updated_at = NOW()
+		WHERE wallet_dva_id = $1
+		RETURNING `+dedicatedAccountColumns, walletDVAID, subaccount, splitCode))
+}
+
+// dvaActor describes who is managing a dedicated account for the logs
+func dvaActor(c *gin.Context) string {
+	if staff, ok := auth.StaffFromContext(c); ok {
+		return "staff " + staff.Name
+	}
+	return "user " + c.Param("user_id")
+}
+
+// paystackDVARequest calls a Paystack dedicated account management endpoint
+func paystackDVARequest(method, path string, payload interface{}) (*paystackDVAResponse, error) {
+	requestBody := bytes.NewBuffer(nil)
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		requestBody = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequest(method, "https://api.paystack.co"+path, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("PAYSTACK_SECRET_KEY"))
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("Error from Paystack: %s", body)
+	}
+
+	var result paystackDVAResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	if !result.Status {
+		return nil, fmt.Errorf("Error from Paystack: %s", result.Message)
+	}
+	return &result, nil
+}
@@ -29,6 +29,7 @@ type Wallet struct {
     AccountNumber string `json:"account_number"`
     DVAid int64 `json:"dva_id"`
     CurrentBalance int64 `json:"current_balance"`
+    DedicatedAccounts []DedicatedAccount `json:"dedicated_accounts"`
 }
 
 type UserWalletResponse struct {
@@ -107,6 +108,20 @@ func fetchUserAndWallets(userID int64) (*UserWalletResponse, error) {
         wallets = append(wallets, wallet)
     }
 
+    // Attach every dedicated account, including those added on other banks
+    accounts, err := FetchDedicatedAccounts(userID)
+    if err != nil {
+        return nil, err
+    }
+    for i := range wallets {
+        wallets[i].DedicatedAccounts = []DedicatedAccount{}
+        for _, account := range accounts {
+            if account.WalletID == wallets[i].WalletID {
+                wallets[i].DedicatedAccounts = append(wallets[i].DedicatedAccounts, account)
+            }
+        }
+    }
+
     return &UserWalletResponse{
         User:    user,
         Wallets: wallets,
@@ -33,7 +33,8 @@ type Response struct {
 
 // CreateCustomerRequest represents the request body for creating a Paystack customer
 type CreateCustomerRequest struct {
-	UserID int64 `json:"user_id"`
+	UserID        int64  `json:"user_id"`
+	PreferredBank string `json:"preferred_bank"` // Optional provider_slug from /wallet/dva/providers
 }
 
 type CreateCustomerResponse struct {
@@ -80,6 +81,17 @@ func CreateCustomerHandler(c *gin.Context) {
 		return
 	}
 
+	preferredBank, err := validatePreferredBank(request.PreferredBank)
+	if err != nil {
+		response = Response{
+			Status:     "error",
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(response.StatusCode, response)
+		return
+	}
+
 	// Fetch user information from the database
 	user, err := fetchUserFromDatabase(request.UserID)
 	if err != nil {
@@ -121,24 +133,27 @@ func CreateCustomerHandler(c *gin.Context) {
 		return
 	}
 
-	// Create a DVA with Paystack using the customer code
-	dvaData, err := createDVAWithPaystack(customerCode)
+	// Save the wallet and a pending DVA before asking Paystack, which may assign the account asynchronously
+	walletID, err := saveWalletInDatabase(request.UserID, customerCode)
 	if err != nil {
 		response = Response{
 			Status:     "error",
 			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to create DVA: " + err.Error(),
+			Message:    "Oops! " + err.Error(),
 		}
 		c.JSON(response.StatusCode, response)
 		return
 	}
 
-	// Save DVA information in the database
-	if err := saveDVAInDatabase(request.UserID, dvaData); err != nil {
+	account, err := createPendingDVA(walletID, request.UserID, customerCode, preferredBank, true)
+	if err == nil {
+		account, err = requestDVA(account)
+	}
+	if err != nil {
 		response = Response{
 			Status:     "error",
 			StatusCode: http.StatusInternalServerError,
-			Message:    "Oops! " + err.Error(),
+			Message:    "Failed to save DVA: " + err.Error(),
 		}
 		c.JSON(response.StatusCode, response)
 		return
@@ -148,7 +163,11 @@ func CreateCustomerHandler(c *gin.Context) {
 		Status:     "success",
 		StatusCode: http.StatusOK,
 		Message:    "Wallet created successfully",
-		Result:     dvaData,
+		Result:     account,
+	}
+	if account.Status != DVAStatusActive {
+		response.StatusCode = http.StatusAccepted
+		response.Message = dvaStatusMessage(account.Status)
 	}
 	c.JSON(response.StatusCode, response)
 }
@@ -233,15 +252,15 @@ func createCustomerWithPaystack(customer Customer) (string, error) {
 	return result.Data.CustomerCode, nil
 }
 
-// createDVAWithPaystack sends a request to Paystack to create a dedicated virtual account
-func createDVAWithPaystack(customerCode string) (*CreateDVAResponse, error) {
+// createDVAWithPaystack sends a request to Paystack to create a dedicated virtual account on the preferred bank
+func createDVAWithPaystack(customerCode, preferredBank string) (*CreateDVAResponse, error) {
 	url := "https://api.paystack.co/dedicated_account"
 	authorization := "Bearer " + os.Getenv("PAYSTACK_SECRET_KEY")
 	contentType := "application/json"
 
 	data := map[string]interface{}{
 		"customer":      customerCode,
-		"preferred_bank": "wema-bank",
+		"preferred_bank": preferredBank,
 	}
 
 	jsonData, err := json.Marshal(data)
@@ -284,8 +303,8 @@ func createDVAWithPaystack(customerCode string) (*CreateDVAResponse, error) {
 	return &result, nil
 }
 
-// checkDVAExists checks if a DVA already exists in the database
-func checkDVAExists(userID int64, dvaData *CreateDVAResponse) (bool, error) {
+// checkWalletExists checks if the user already has a wallet in the database
+func checkWalletExists(userID int64) (bool, error) {
 	db, err := database.PostgreSQLConnect()
 	if err != nil {
 		return false, err
@@ -293,12 +312,12 @@ func checkDVAExists(userID int64, dvaData *CreateDVAResponse) (bool, error) {
 	defer db.Close(context.Background())
 
 	query := `
-		SELECT COUNT(*) 
-		FROM wallet 
-		WHERE user_id = $1 AND customer_code = $2 AND deleted = false
+		SELECT COUNT(*)
+		FROM wallet
+		WHERE user_id = $1 AND deleted = false
 	`
 	var count int
-	err = db.QueryRow(context.Background(), query, userID,dvaData.Data.Customer.CustomerCode).Scan(&count)
+	err = db.QueryRow(context.Background(), query, userID).Scan(&count)
 	if err != nil {
 		return false, err
 	}
@@ -306,33 +325,33 @@ func checkDVAExists(userID int64, dvaData *CreateDVAResponse) (bool, error) {
 	return count > 0, nil
 }
 
-
-// saveDVAInDatabase saves the DVA information in the database
-func saveDVAInDatabase(userID int64, dvaData *CreateDVAResponse) error {
-
-	exists, err := checkDVAExists(userID, dvaData)
+// saveWalletInDatabase saves a wallet for the Paystack customer. Its account details are filled in
+// once the primary DVA is assigned.
+func saveWalletInDatabase(userID int64, customerCode string) (int64, error) {
+	exists, err := checkWalletExists(userID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if exists {
-		return fmt.Errorf("wallet address has already been created for you")
+		return 0, fmt.Errorf("wallet address has already been created for you")
 	}
-	
 
 	db, err := database.PostgreSQLConnect()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer db.Close(context.Background())
 
 	query := `
 		INSERT INTO wallet (user_id, customer_code, bank_name, bank_id, bank_slug, account_name, account_number, dva_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		VALUES ($1, $2, '', 0, '', '', '', 0)
+		RETURNING wallet_id
 	`
-	_, err = db.Exec(context.Background(), query, userID, dvaData.Data.Customer.CustomerCode, dvaData.Data.Bank.Name, dvaData.Data.Bank.ID, dvaData.Data.Bank.Slug, dvaData.Data.AccountName, dvaData.Data.AccountNumber, dvaData.Data.DVAid)
+	var walletID int64
+	err = db.QueryRow(context.Background(), query, userID, customerCode).Scan(&walletID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
-}
\ No newline at end of file
+	return walletID, nil
+}
@@ -6,13 +6,19 @@ import (
 	"crypto/sha512"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
 	"go_code/database"
+	"go_code/pkg/funding"
+	"go_code/pkg/kyc"
+	"go_code/pkg/transaction"
+	"go_code/pkg/wallet"
 )
 
 // PaystackEvent represents the structure of the webhook event from Paystack
@@ -22,6 +28,7 @@ type PaystackEvent struct {
 		Domain          string  `json:"domain"`
 		Status          string  `json:"status"`
 		Reference       string  `json:"reference"`
+		TransferCode    string  `json:"transfer_code"`
 		Amount          float64 `json:"amount"`
 		PaidAt          string  `json:"paid_at"`
 		Customer        struct {
@@ -31,9 +38,20 @@ type PaystackEvent struct {
 			Bank        string `json:"bank"`
 			AccountName string `json:"account_name"`
 		} `json:"authorization"`
+		// FeesSplit is set on payments into a dedicated account split with a subaccount
+		FeesSplit *struct {
+			Subaccount float64 `json:"subaccount"`
+		} `json:"fees_split"`
 	} `json:"data"`
 }
 
+// transferEventStatus maps Paystack transfer events to bulk transfer item statuses
+var transferEventStatus = map[string]string{
+	"transfer.success":  transaction.BulkItemSuccess,
+	"transfer.failed":   transaction.BulkItemFailed,
+	"transfer.reversed": transaction.BulkItemReversed,
+}
+
 // Response represents the generic response structure
 type Response struct {
 	Status  string      `json:"status"`
@@ -82,6 +100,26 @@ func WebhookHandler(c *gin.Context) {
 		return
 	}
 
+	// Card top-ups are verified with Paystack and credited by the funding package
+	if event.Event == "charge.success" && event.Data.Status == "success" {
+		isCardFunding, err := funding.IsCardFunding(event.Data.Reference)
+		if err == nil && isCardFunding {
+			_, err = funding.CompleteCardFunding(event.Data.Reference)
+		}
+		if err != nil {
+			log.Printf("Failed to complete card funding: %v\n", err)
+			c.JSON(http.StatusInternalServerError, Response{
+				Status:  "error",
+				Message: "Failed to complete card funding: " + err.Error(),
+			})
+			return
+		}
+		if isCardFunding {
+			c.Status(http.StatusOK)
+			return
+		}
+	}
+
 	// Handle the event
 	if event.Event == "charge.success" && event.Data.Status == "success" {
 		if err := insertTransaction(event); err != nil {
@@ -94,9 +132,59 @@ func WebhookHandler(c *gin.Context) {
 		}
 	}
 
+	// Track the outcome of transfers submitted in a bulk transfer batch
+	if status, ok := transferEventStatus[event.Event]; ok {
+		failureReason := ""
+		if status != transaction.BulkItemSuccess {
+			failureReason = "Paystack reported " + event.Event
+		}
+		if err := transaction.UpdateBulkTransferItem(event.Data.Reference, status, failureReason); err != nil {
+			log.Printf("Failed to update bulk transfer item: %v\n", err)
+			c.JSON(http.StatusInternalServerError, Response{
+				Status:  "error",
+				Message: "Failed to update bulk transfer item: " + err.Error(),
+			})
+			return
+		}
+
+		// Single transfers are debited before they are sent, so failed ones are refunded here
+		if err := transaction.SettleTransfer(event.Data.Reference, event.Data.TransferCode, event.Data.Status, "Paystack reported "+event.Event); err != nil {
+			log.Printf("Failed to settle transfer: %v\n", err)
+			c.JSON(http.StatusInternalServerError, Response{
+				Status:  "error",
+				Message: "Failed to settle transfer: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	// Complete or retry dedicated accounts Paystack assigns asynchronously
+	if event.Event == "dedicatedaccount.assign.success" || event.Event == "dedicatedaccount.assign.failed" {
+		if err := handleDVAAssignment(body); err != nil {
+			log.Printf("Failed to process dedicated account assignment: %v\n", err)
+			c.JSON(http.StatusInternalServerError, Response{
+				Status:  "error",
+				Message: "Failed to process dedicated account assignment: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	c.Status(http.StatusOK)
 }
 
+// handleDVAAssignment completes or fails the pending dedicated account an assignment event is for
+func handleDVAAssignment(body []byte) error {
+	var event wallet.DedicatedAccountEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return err
+	}
+	if event.Event == "dedicatedaccount.assign.success" {
+		return wallet.CompleteDVAAssignment(event)
+	}
+	return wallet.FailDVAAssignment(event)
+}
+
 // validateSignature checks if the request signature matches the expected signature
 func validateSignature(body []byte, signature, secret string) bool {
 	hash := hmac.New(sha512.New, []byte(secret))
@@ -107,6 +195,13 @@ func validateSignature(body []byte, signature, secret string) bool {
 
 // insertTransaction inserts a successful transaction into the database
 func insertTransaction(event PaystackEvent) error {
+	// The subaccount's share of a split payment is settled to the merchant, not the wallet
+	amount := creditAmount(event)
+	if amount <= 0 {
+		log.Printf("Deposit %s was settled entirely to a subaccount, nothing to credit\n", event.Data.Reference)
+		return nil
+	}
+
 	db, err := database.PostgreSQLConnect()
 	if err != nil {
 		log.Printf("Failed to connect to database: %v\n", err)
@@ -119,14 +214,45 @@ func insertTransaction(event PaystackEvent) error {
 		log.Printf("Failed to begin transaction: %v\n", err)
 		return err
 	}
+	defer tx.Rollback(context.Background())
+
+	// Lock the wallet so deliveries of the same deposit are credited one after the other
+	var userID int
+	err = tx.QueryRow(context.Background(),
+		"SELECT user_id FROM wallet WHERE customer_code = $1 AND deleted = false ORDER BY wallet_id LIMIT 1 FOR UPDATE",
+		event.Data.Customer.CustomerCode).Scan(&userID)
+	if err != nil && err != pgx.ErrNoRows {
+		return fmt.Errorf("failed to lock wallet: %w", err)
+	}
+
+	// Paystack resends missed webhooks when a dedicated account is requeried, so credit each reference once
+	recorded, err := creditRecorded(tx, event.Data.Reference)
+	if err != nil {
+		return err
+	}
+	if recorded {
+		log.Printf("Deposit %s already recorded, ignoring\n", event.Data.Reference)
+		return nil
+	}
+
+	// Deposits that would take the wallet over the KYC balance limit are held until the user upgrades.
+	// Unknown wallets are credited as before.
+	if userID != 0 {
+		held, err := holdOverLimitCredit(tx, userID, event.Data.Reference, amount)
+		if err != nil {
+			return err
+		}
+		if held {
+			return tx.Commit(context.Background())
+		}
+	}
 
 	// Insert the new transaction
 	_, err = tx.Exec(context.Background(),
 		"INSERT INTO user_transaction (reference, amount, created_at, bank, account_name, customer_code, transaction_type) VALUES ($1, $2, $3, $4, $5, $6, $7)",
-		event.Data.Reference, event.Data.Amount/100, event.Data.PaidAt, event.Data.Authorization.Bank, event.Data.Authorization.AccountName, event.Data.Customer.CustomerCode, "credit")
+		event.Data.Reference, amount, event.Data.PaidAt, event.Data.Authorization.Bank, event.Data.Authorization.AccountName, event.Data.Customer.CustomerCode, "credit")
 
 	if err != nil {
-		tx.Rollback(context.Background())
 		log.Printf("Failed to insert transaction: %v\n", err)
 		return fmt.Errorf("failed to insert transaction: %w", err)
 	}
@@ -134,10 +260,9 @@ func insertTransaction(event PaystackEvent) error {
 	// Update previous_balance and current_balance
 	_, err = tx.Exec(context.Background(),
 		"UPDATE wallet SET previous_balance = current_balance, current_balance = current_balance + $1 WHERE customer_code = $2",
-		event.Data.Amount/100, event.Data.Customer.CustomerCode)
+		amount, event.Data.Customer.CustomerCode)
 
 	if err != nil {
-		tx.Rollback(context.Background())
 		log.Printf("Failed to update balance: %v\n", err)
 		return fmt.Errorf("failed to update balance: %w", err)
 	}
@@ -150,4 +275,38 @@ func insertTransaction(event PaystackEvent) error {
 	return nil
 }
 
+// creditRecorded reports whether a deposit has already been credited or held
+func creditRecorded(tx pgx.Tx, reference string) (bool, error) {
+	var recorded bool
+	err := tx.QueryRow(context.Background(), `
+		SELECT EXISTS (SELECT 1 FROM user_transaction WHERE reference = $1)
+			OR EXISTS (SELECT 1 FROM held_credit WHERE reference = $1)
+	`, reference).Scan(&recorded)
+	return recorded, err
+}
+
+// creditAmount returns the naira amount of a deposit the wallet is credited with
+func creditAmount(event PaystackEvent) float64 {
+	amount := event.Data.Amount
+	if event.Data.FeesSplit != nil {
+		amount -= event.Data.FeesSplit.Subaccount
+	}
+	return amount / 100
+}
+
+// holdOverLimitCredit holds a deposit instead of crediting it when it would exceed the wallet owner's KYC balance limit
+func holdOverLimitCredit(tx pgx.Tx, userID int, reference string, amount float64) (bool, error) {
+	err := kyc.CheckCredit(userID, amount)
+	var limitErr *kyc.LimitError
+	if !errors.As(err, &limitErr) {
+		return false, err
+	}
+
+	if err := kyc.HoldCredit(tx, userID, reference, amount, limitErr.Error()); err != nil {
+		return false, err
+	}
+	log.Printf("Held deposit %s of NGN %.2f for user %d: %v\n", reference, amount, userID, limitErr)
+	return true, nil
+}
+
 // Can we talk later this evening? I am kinda busy 
\ No newline at end of file